@@ -0,0 +1,191 @@
+package figaro
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	nlopesslack "github.com/nlopes/slack"
+)
+
+// alertTimeout limits how long sinks deliver an alert.
+const alertTimeout = 10 * time.Second
+
+// alertText returns a human readable text of the alert.
+func alertText(alert *Alert) string {
+	waited := (time.Duration(alert.Waited) * time.Second).String()
+	text := fmt.Sprintf("A guest has waited for an answer in #%s for %s since %s",
+		alert.ChannelName, waited, alert.WaitingSince.UTC().Format(time.RFC3339))
+	if alert.Count > 1 {
+		text += fmt.Sprintf(" (reminder %d)", alert.Count-1)
+	}
+	if alert.Text != "" {
+		text += ":\n> " + strings.Replace(alert.Text, "\n", "\n> ", -1)
+	}
+	return text
+}
+
+// SlackSink posts alerts to a Slack channel with chat.postMessage. Alerts
+// posted to user IDs come as direct messages from the app.
+type SlackSink struct {
+	api     *nlopesslack.Client
+	channel string
+}
+
+var _ AlertSink = (*SlackSink)(nil)
+
+// NewSlackSink creates a sink which posts to the channel, or to the user,
+// with the token. apiURL is the base URL of Slack Web API, the default one
+// is used if it's empty.
+func NewSlackSink(token, apiURL, channel string) *SlackSink {
+	options := []nlopesslack.Option{
+		nlopesslack.OptionHTTPClient(&http.Client{Timeout: alertTimeout}),
+	}
+	if apiURL != "" {
+		if !strings.HasSuffix(apiURL, "/") {
+			apiURL += "/"
+		}
+		options = append(options, nlopesslack.OptionAPIURL(apiURL))
+	}
+	return &SlackSink{api: nlopesslack.New(token, options...), channel: channel}
+}
+
+// Send posts the alert.
+func (s *SlackSink) Send(alert *Alert) error {
+	_, _, err := s.api.PostMessage(s.channel,
+		nlopesslack.MsgOptionText(alertText(alert), false))
+	return err
+}
+
+// WebhookSink posts alerts as JSON to a URL.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+var _ AlertSink = (*WebhookSink)(nil)
+
+// NewWebhookSink creates a sink which posts to the URL.
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{Timeout: alertTimeout}}
+}
+
+// Send posts the alert with its text in the Message field. Responses other
+// than 2xx are errors.
+func (s *WebhookSink) Send(alert *Alert) error {
+	data, err := json.Marshal(struct {
+		*Alert
+		Message string
+	}{alert, alertText(alert)})
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// SMTPConfig configures SMTPSink.
+type SMTPConfig struct {
+	Addr string // host:port of the SMTP server
+	From string
+	To   []string
+	// Username and Password authenticate with PLAIN auth if Username is
+	// not empty. Servers other than localhost must support TLS then.
+	Username string
+	Password string
+}
+
+// SMTPSink emails alerts.
+type SMTPSink struct {
+	conf    SMTPConfig
+	timeout time.Duration
+}
+
+var _ AlertSink = (*SMTPSink)(nil)
+
+// NewSMTPSink creates a sink which emails alerts.
+func NewSMTPSink(conf SMTPConfig) *SMTPSink {
+	return &SMTPSink{conf: conf, timeout: alertTimeout}
+}
+
+// Send emails the alert.
+func (s *SMTPSink) Send(alert *Alert) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.conf.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.conf.To, ", "))
+	fmt.Fprintf(&msg, "Subject: Figaro: guest waits in #%s\r\n", alert.ChannelName)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(alertText(alert), "\n", "\r\n", -1))
+	msg.WriteString("\r\n")
+	return s.sendMail(msg.Bytes())
+}
+
+// sendMail sends the message like smtp.SendMail does, but gives up when the
+// server doesn't finish in time.
+func (s *SMTPSink) sendMail(msg []byte) error {
+	host, _, err := net.SplitHostPort(s.conf.Addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", s.conf.Addr, s.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.conf.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		auth := smtp.PlainAuth("", s.conf.Username, s.conf.Password, host)
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.conf.From); err != nil {
+		return err
+	}
+	for _, to := range s.conf.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,168 @@
+package figaro
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adyatlov/figaro/figaro/slacktest"
+	"github.com/adyatlov/figaro/figaro/smtptest"
+)
+
+// testAlert returns the second alert about a question in #one.
+func testAlert() *Alert {
+	return &Alert{
+		ChannelID:    "C1",
+		ChannelName:  "one",
+		WaitingSince: time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC),
+		Waited:       3600,
+		UserID:       "UGUEST",
+		Text:         "help\nplease",
+		Count:        2,
+	}
+}
+
+func TestAlertText(t *testing.T) {
+	want := "A guest has waited for an answer in #one for 1h0m0s since " +
+		"2020-03-02T10:00:00Z (reminder 1):\n> help\n> please"
+	if text := alertText(testAlert()); text != want {
+		t.Errorf("got %q, want %q", text, want)
+	}
+}
+
+func TestSlackSink(t *testing.T) {
+	srv := slacktest.NewServer(historyFixtures(0))
+	defer srv.Close()
+	tests := []struct {
+		name    string
+		channel string
+		wantErr bool
+	}{
+		{name: "channel", channel: "C1"},
+		{name: "no channel", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := NewSlackSink("xoxb-test", srv.APIURL, tt.channel)
+			err := sink.Send(testAlert())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			messages := srv.Messages(tt.channel)
+			if len(messages) != 1 || messages[0].Text != alertText(testAlert()) {
+				t.Errorf("got messages %+v, want the alert text", messages)
+			}
+		})
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "OK", status: http.StatusOK},
+		{name: "no content", status: http.StatusNoContent},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: true},
+		{name: "not modified", status: http.StatusNotModified, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost ||
+					r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("got %s with %q", r.Method, r.Header.Get("Content-Type"))
+				}
+				body, _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(tt.status)
+			}))
+			defer hs.Close()
+			err := NewWebhookSink(hs.URL).Send(testAlert())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			var got struct {
+				ChannelID string
+				Count     uint
+				Message   string
+			}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatalf("invalid payload %s: %v", body, err)
+			}
+			if got.ChannelID != "C1" || got.Count != 2 || got.Message != alertText(testAlert()) {
+				t.Errorf("got payload %s", body)
+			}
+		})
+	}
+}
+
+func TestSMTPSink(t *testing.T) {
+	srv, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	sink := NewSMTPSink(SMTPConfig{
+		Addr: srv.Addr,
+		From: "figaro@corp.com",
+		To:   []string{"ann@corp.com", "support@corp.com"},
+	})
+	if err := sink.Send(testAlert()); err != nil {
+		t.Fatal(err)
+	}
+	mail := srv.Mail()
+	if len(mail) != 1 {
+		t.Fatalf("got %d emails, want 1", len(mail))
+	}
+	if mail[0].From != "figaro@corp.com" ||
+		strings.Join(mail[0].To, ",") != "ann@corp.com,support@corp.com" {
+		t.Errorf("got email from %s to %v", mail[0].From, mail[0].To)
+	}
+	for _, want := range []string{
+		"To: ann@corp.com, support@corp.com\r\n",
+		"Subject: Figaro: guest waits in #one\r\n",
+		"\r\n> help\r\n> please\r\n",
+	} {
+		if !strings.Contains(mail[0].Data, want) {
+			t.Errorf("got email %q without %q", mail[0].Data, want)
+		}
+	}
+}
+
+func TestSMTPSinkTimeout(t *testing.T) {
+	// The server accepts connections and never greets
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	sink := NewSMTPSink(SMTPConfig{Addr: ln.Addr().String(),
+		From: "figaro@corp.com", To: []string{"ann@corp.com"}})
+	sink.timeout = 100 * time.Millisecond
+	start := time.Now()
+	if err := sink.Send(testAlert()); err == nil {
+		t.Fatal("got no error from a silent server")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gave up after %v", elapsed)
+	}
+}
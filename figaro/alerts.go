@@ -0,0 +1,217 @@
+package figaro
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// alertCheckInterval is how often waiting channels are checked for alerts.
+const alertCheckInterval = time.Minute
+
+// quietRe matches quiet hours like "22:00-07:00"
+var quietRe = regexp.MustCompile(`^(\d\d):(\d\d)-(\d\d):(\d\d)$`)
+
+// Alert tells that a guest has waited for the first response too long.
+type Alert struct {
+	ChannelID    string
+	ChannelName  string
+	TeamID       string
+	WaitingSince time.Time // When the question was asked
+	Waited       uint      // Seconds waited in business hours
+	UserID       string    // Who wrote the last message
+	Text         string    // The last message
+	// Count is 1 for the first alert about the question, 2 for the
+	// first repeated one and so on.
+	Count uint
+}
+
+// AlertSink delivers alerts, for example to Slack or by email.
+type AlertSink interface {
+	Send(alert *Alert) error
+}
+
+// AlertConfig configures alerts about questions of guests which have waited
+// too long.
+type AlertConfig struct {
+	// After is how long a question may wait in business hours before the
+	// alert. The SLA of the channel applies if it's 0.
+	After time.Duration
+	// Repeat is how often the alert repeats while the question waits. It's
+	// sent once if Repeat is 0.
+	Repeat time.Duration
+	// Quiet are hours like "22:00-07:00" when alerts are held. They are
+	// sent when the quiet hours end if the questions still wait.
+	Quiet string
+	// Location is where the quiet hours are, UTC if nil.
+	Location *time.Location
+	// Sinks get every alert.
+	Sinks []AlertSink
+}
+
+// alerter sends alerts about waiting channels of the boards. It remembers
+// the last alert about every channel in the storage, so that alerts are not
+// repeated after restarts.
+type alerter struct {
+	f    *Figaro
+	conf AlertConfig
+	// quiet is set if there are quiet hours. The span ends on the next
+	// day if to is before from.
+	quiet *span
+	// notified are the last alerts by channel IDs
+	notified map[string]*Notification
+}
+
+// StartAlerts starts checking boards for questions which have waited too
+// long and sending alerts about them to the sinks.
+func (f *Figaro) StartAlerts(conf AlertConfig) error {
+	if len(conf.Sinks) == 0 {
+		return errors.New("no alert sinks")
+	}
+	if conf.Location == nil {
+		conf.Location = time.UTC
+	}
+	a := &alerter{f: f, conf: conf, notified: make(map[string]*Notification)}
+	if conf.Quiet != "" {
+		quiet, err := parseQuietHours(conf.Quiet)
+		if err != nil {
+			return err
+		}
+		a.quiet = &quiet
+	}
+	notifications, err := f.st.GetNotifications()
+	if err != nil {
+		return err
+	}
+	for _, n := range notifications {
+		a.notified[n.ChannelID] = n
+	}
+	go a.run()
+	log.Printf("Figaro: Alerts started with %d sinks\n", len(conf.Sinks))
+	return nil
+}
+
+// parseQuietHours parses hours like "22:00-07:00".
+func parseQuietHours(s string) (span, error) {
+	m := quietRe.FindStringSubmatch(s)
+	if m == nil {
+		return span{}, fmt.Errorf("invalid quiet hours %q, use for example 22:00-07:00", s)
+	}
+	var quiet span
+	for i, d := range []*time.Duration{&quiet.from, &quiet.to} {
+		h, _ := strconv.Atoi(m[1+2*i])
+		min, _ := strconv.Atoi(m[2+2*i])
+		if h > 23 || min > 59 {
+			return span{}, fmt.Errorf("invalid time in quiet hours %q", s)
+		}
+		*d = time.Duration(h)*time.Hour + time.Duration(min)*time.Minute
+	}
+	return quiet, nil
+}
+
+func (a *alerter) run() {
+	for now := range time.Tick(alertCheckInterval) {
+		a.check(now)
+	}
+}
+
+// isQuiet tells if t is in the quiet hours.
+func (a *alerter) isQuiet(t time.Time) bool {
+	if a.quiet == nil {
+		return false
+	}
+	t = t.In(a.conf.Location)
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if a.quiet.from <= a.quiet.to {
+		return d >= a.quiet.from && d < a.quiet.to
+	}
+	return d >= a.quiet.from || d < a.quiet.to
+}
+
+// check sends alerts about channels of the boards whose questions have
+// waited too long at now and forgets alerts about answered ones. Channels
+// marked OK or acknowledged don't wait. A channel on several boards is
+// checked as it is on the first of them by name where it waits.
+func (a *alerter) check(now time.Time) {
+	waiting := make(map[string]bool)
+	for _, sb := range a.f.servedBoards() {
+		for _, channel := range sb.state.list() {
+			if channel.WaitingSince.IsZero() || waiting[channel.ID] {
+				continue
+			}
+			if _, half := sb.state.channel(channel.ID); half == HalfOk {
+				continue
+			}
+			waiting[channel.ID] = true
+			a.checkChannel(channel, a.f.calendarOf(sb, channel), now)
+		}
+	}
+	for id := range a.notified {
+		if waiting[id] {
+			continue
+		}
+		if err := a.f.st.DeleteNotification(id); err != nil {
+			log.Println("Figaro: Cannot delete notification:", err)
+			continue
+		}
+		delete(a.notified, id)
+	}
+}
+
+// checkChannel sends the alert about the channel if it's due.
+func (a *alerter) checkChannel(channel *Channel, cal *calendar, now time.Time) {
+	after := a.conf.After
+	if after == 0 {
+		after = a.f.slaOf(channel)
+	}
+	waited := cal.elapsed(channel.WaitingSince, now)
+	if after == 0 || waited <= after {
+		return
+	}
+	n := a.notified[channel.ID]
+	if n != nil && n.AskedAt.Equal(channel.WaitingSince) {
+		if a.conf.Repeat == 0 || now.Sub(n.SentAt) < a.conf.Repeat {
+			return
+		}
+	} else {
+		n = &Notification{ChannelID: channel.ID, AskedAt: channel.WaitingSince}
+	}
+	if a.isQuiet(now) {
+		return
+	}
+	alert := &Alert{
+		ChannelID:    channel.ID,
+		ChannelName:  channel.Name,
+		TeamID:       channel.TeamID,
+		WaitingSince: channel.WaitingSince,
+		Waited:       uint(waited / time.Second),
+		Count:        n.Count + 1,
+	}
+	if len(channel.Messages) > 0 {
+		alert.UserID = channel.Messages[0].UserID
+		alert.Text = channel.Messages[0].Text
+	}
+	// The alert counts as sent if a sink delivered it, failed sinks don't
+	// repeat it to the others.
+	sent := false
+	for _, sink := range a.conf.Sinks {
+		if err := sink.Send(alert); err != nil {
+			log.Printf("Figaro: Cannot send alert about %s: %v\n", channel.ID, err)
+			continue
+		}
+		sent = true
+	}
+	if !sent {
+		return
+	}
+	n.SentAt = now
+	n.Count = alert.Count
+	if err := a.f.st.UpdateNotification(n); err != nil {
+		log.Println("Figaro: Cannot update notification:", err)
+	}
+	a.notified[channel.ID] = n
+	log.Printf("Figaro: Alert %d sent about %s\n", n.Count, channel.ID)
+}
@@ -0,0 +1,97 @@
+package figaro
+
+import (
+	"testing"
+	"time"
+)
+
+// alertRecorder is an AlertSink which keeps the alerts.
+type alertRecorder struct {
+	alerts []*Alert
+}
+
+func (r *alertRecorder) Send(alert *Alert) error {
+	r.alerts = append(r.alerts, alert)
+	return nil
+}
+
+func TestAlertBoardOrder(t *testing.T) {
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC) // Monday
+	tests := []struct {
+		name      string
+		board     string // Has C1 and is closed on Mondays
+		wantAlert bool
+	}{
+		{name: "closed board first", board: "closed"},
+		{name: "default board first", board: "weekend", wantAlert: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, _, _ := newTestFigaro(t, []*Channel{{ID: "C1", Name: "one"}},
+				[]*Message{testMessage("UGUEST", t0, "question")})
+			err := f.PutCalendar(&Calendar{Name: "saturday", TimeZone: "UTC",
+				Hours: []string{"Sat 09:00-17:00"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.PutBoard(&Board{Name: tt.board, Include: []string{"^one$"},
+				Calendar: "saturday"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Every check sees the boards in the same order
+			for i := 0; i < 10; i++ {
+				sink := &alertRecorder{}
+				a := &alerter{f: f, notified: make(map[string]*Notification),
+					conf: AlertConfig{After: time.Hour, Location: time.UTC,
+						Sinks: []AlertSink{sink}}}
+				a.check(t0.Add(2 * time.Hour))
+				if got := len(sink.alerts) > 0; got != tt.wantAlert {
+					t.Fatalf("check %d: got alert %v, want %v", i, got, tt.wantAlert)
+				}
+			}
+		})
+	}
+}
+
+func TestAlertOkChannels(t *testing.T) {
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	ts := timeToStr(t0)
+	tests := []struct {
+		name      string
+		change    func(f *Figaro) error
+		wantAlert bool
+	}{{
+		name:      "waiting",
+		change:    func(f *Figaro) error { return nil },
+		wantAlert: true,
+	}, {
+		name:   "marked OK, so no alert",
+		change: func(f *Figaro) error { return f.UpdateChannelStatus("C1", true) },
+	}, {
+		name: "acknowledged, so no alert",
+		change: func(f *Figaro) error {
+			err := f.processMessages([]*Message{{Type: "reaction_added",
+				ChannelID: "C1", TS: ts, UserID: "UINT", Name: "eyes"}})
+			f.waitBoardChannel("C1")
+			return err
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, _, _ := newTestFigaro(t, []*Channel{{ID: "C1", Name: "one"}},
+				[]*Message{testMessage("UGUEST", t0, "question")})
+			if err := tt.change(f); err != nil {
+				t.Fatal(err)
+			}
+			sink := &alertRecorder{}
+			a := &alerter{f: f, notified: make(map[string]*Notification),
+				conf: AlertConfig{After: time.Hour, Location: time.UTC,
+					Sinks: []AlertSink{sink}}}
+			a.check(t0.Add(2 * time.Hour))
+			if got := len(sink.alerts) > 0; got != tt.wantAlert {
+				t.Errorf("got alert %v, want %v", got, tt.wantAlert)
+			}
+		})
+	}
+}
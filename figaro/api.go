@@ -0,0 +1,762 @@
+package figaro
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxAPIMessageLimit = 1000
+	// defaultResponsesPeriod is how far back /channels/{id}/responses looks
+	// by default.
+	defaultResponsesPeriod = 7 * 24 * time.Hour
+	// defaultDeliveryLimit is how many deliveries
+	// /webhooks/{name}/deliveries returns by default.
+	defaultDeliveryLimit = 50
+)
+
+// Handler returns an HTTP handler which serves the WebSocket endpoint and
+// the REST API:
+//
+//	GET /                        - WebSocket with board events, see PROTOCOL.md
+//	GET /ws                      - the same as above
+//	GET /events                  - the same events as Server-Sent Events
+//	GET /channels                - current ChannelPair, can be filtered with
+//	                               ?type=, ?shared= and ?ext_shared=
+//	GET /channels/{id}           - channel with its last messages
+//	GET /channels/{id}/messages  - last messages of a channel, ?limit=N
+//	GET /channels/{id}/responses - questions of guests and first responses
+//	                               asked ?from= ?to= (RFC 3339), the last
+//	                               week by default, on the ?board=, the
+//	                               default one by default
+//	PUT /channels/{id}/sla       - sets SLA of a channel, JSON {"SLA": seconds}
+//	PUT /channels/{id}/calendar  - attaches a calendar to a channel, JSON
+//	                               {"Calendar": name}, "" detaches it
+//	POST /change_status/         - marks a channel as OK, form values ID and Ok
+//	GET /boards                  - all boards
+//	GET /boards/{name}           - the board
+//	PUT /boards/{name}           - creates or replaces the board, JSON Board
+//	DELETE /boards/{name}        - deletes the board
+//	GET /boards/{name}/ws        - WebSocket with events of the board
+//	GET /boards/{name}/events    - the same events as Server-Sent Events
+//	GET /boards/{name}/channels  - ChannelPair of the board, filtered as
+//	                               /channels
+//	GET /calendars               - all business hours calendars
+//	GET /calendars/{name}        - the calendar
+//	PUT /calendars/{name}        - creates or replaces the calendar, JSON
+//	                               Calendar
+//	DELETE /calendars/{name}     - deletes the calendar
+//	GET /webhooks                - all webhooks without secrets
+//	GET /webhooks/{name}         - the webhook without its secret
+//	PUT /webhooks/{name}         - creates or replaces the webhook, JSON
+//	                               Webhook
+//	DELETE /webhooks/{name}      - deletes the webhook
+//	GET /webhooks/{name}/deliveries
+//	                             - last deliveries of the webhook, ?limit=N
+//	GET /webhooks/{name}/deliveries/{id}
+//	                             - the delivery
+//	POST /webhooks/{name}/deliveries/{id}/redeliver
+//	                             - posts the payload of the delivery again
+//	GET /users/{id}              - the user with their role
+//	GET /reports                 - response times and volumes per channel and
+//	                               day or week, see ParseReportQuery,
+//	                               ?format=csv for CSV
+//
+// /, /ws, /events and /channels serve the default board.
+//
+// Signed in users get only channels they are members of, other channels are
+// not found. Only admins may change boards, calendars and SLAs. Webhooks and
+// their deliveries carry messages of all channels, so only admins may see
+// and manage them.
+func (f *Figaro) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", f.pu.Handler)
+	mux.HandleFunc("/events", f.pu.SSEHandler)
+	mux.HandleFunc("/channels", f.handleChannels)
+	mux.HandleFunc("/channels/", f.handleChannel)
+	mux.HandleFunc("/change_status", f.handleChangeStatus)
+	mux.HandleFunc("/change_status/", f.handleChangeStatus)
+	mux.HandleFunc("/boards", f.handleBoards)
+	mux.HandleFunc("/boards/", f.handleBoard)
+	mux.HandleFunc("/calendars", f.handleCalendars)
+	mux.HandleFunc("/calendars/", f.handleCalendar)
+	mux.HandleFunc("/webhooks", f.handleWebhooks)
+	mux.HandleFunc("/webhooks/", f.handleWebhook)
+	mux.HandleFunc("/users/", f.handleUser)
+	mux.HandleFunc("/reports", f.handleReports)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// The frontend connects to the root
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		f.pu.Handler(w, r)
+	})
+	return mux
+}
+
+func (f *Figaro) handleChannels(w http.ResponseWriter, r *http.Request) {
+	f.writeChannels(w, r, DefaultBoard)
+}
+
+func (f *Figaro) writeChannels(w http.ResponseWriter, r *http.Request, board string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := parseChannelFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	channelPair, err := f.BoardChannelPair(board, viewerID(r))
+	if err == ErrNoBoard {
+		http.Error(w, "Board not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("API: Cannot get channels:", err)
+		http.Error(w, "Cannot get channels", http.StatusInternalServerError)
+		return
+	}
+	channelPair.Bad = filter.apply(channelPair.Bad)
+	channelPair.Ok = filter.apply(channelPair.Ok)
+	writeJSON(w, channelPair)
+}
+
+// channelFilter filters channels by type and sharing flags.
+type channelFilter struct {
+	types     map[string]bool
+	shared    *bool
+	extShared *bool
+}
+
+func parseChannelFilter(r *http.Request) (*channelFilter, error) {
+	filter := &channelFilter{}
+	q := r.URL.Query()
+	if t := q.Get("type"); t != "" {
+		filter.types = make(map[string]bool)
+		for _, typ := range strings.Split(t, ",") {
+			filter.types[strings.TrimSpace(typ)] = true
+		}
+	}
+	for name, dst := range map[string]**bool{
+		"shared":     &filter.shared,
+		"ext_shared": &filter.extShared,
+	} {
+		if v := q.Get(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s value", name)
+			}
+			*dst = &b
+		}
+	}
+	return filter, nil
+}
+
+func (filter *channelFilter) apply(channels []*Channel) []*Channel {
+	var filtered []*Channel
+	for _, ch := range channels {
+		if filter.types != nil && !filter.types[ch.Type] ||
+			filter.shared != nil && *filter.shared != ch.Shared ||
+			filter.extShared != nil && *filter.extShared != ch.ExtShared {
+			continue
+		}
+		filtered = append(filtered, ch)
+	}
+	return filtered
+}
+
+func (f *Figaro) handleChannel(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/channels/"), "/")
+	method := http.MethodGet
+	if len(parts) == 2 && (parts[1] == "sla" || parts[1] == "calendar") {
+		method = http.MethodPut
+	}
+	if r.Method != method {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !f.Visible(viewerID(r), parts[0]) {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	if method == http.MethodPut && !f.requireAdmin(w, r) {
+		return
+	}
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		f.getChannel(w, parts[0])
+	case len(parts) == 2 && parts[0] != "" && parts[1] == "messages":
+		f.getMessages(w, r, parts[0])
+	case len(parts) == 2 && parts[0] != "" && parts[1] == "responses":
+		f.getResponses(w, r, parts[0])
+	case len(parts) == 2 && parts[0] != "" && parts[1] == "sla":
+		f.setSLA(w, r, parts[0])
+	case len(parts) == 2 && parts[0] != "" && parts[1] == "calendar":
+		f.setCalendar(w, r, parts[0])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *Figaro) getChannel(w http.ResponseWriter, id string) {
+	channel, err := f.st.GetChannel(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("API: Cannot get channel:", err)
+		http.Error(w, "Cannot get channel", http.StatusInternalServerError)
+		return
+	}
+	if channel.Messages, err = f.st.GetMessagesByChannel(id, f.messageLimit); err != nil {
+		log.Println("API: Cannot get messages:", err)
+		http.Error(w, "Cannot get messages", http.StatusInternalServerError)
+		return
+	}
+	if err := f.setWaiting(f.servedBoard(DefaultBoard), []*Channel{channel}); err != nil {
+		log.Println("API: Cannot get responses:", err)
+		http.Error(w, "Cannot get responses", http.StatusInternalServerError)
+		return
+	}
+	f.setClock(channel, f.calendarOf(f.servedBoard(DefaultBoard), channel), time.Now())
+	writeJSON(w, channel)
+}
+
+func (f *Figaro) getMessages(w http.ResponseWriter, r *http.Request, id string) {
+	limit := f.messageLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil || n == 0 || n > maxAPIMessageLimit {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = uint(n)
+	}
+	if _, err := f.st.GetChannel(id); err == sql.ErrNoRows {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	messages, err := f.st.GetMessagesByChannel(id, limit)
+	if err != nil {
+		log.Println("API: Cannot get messages:", err)
+		http.Error(w, "Cannot get messages", http.StatusInternalServerError)
+		return
+	}
+	if messages == nil {
+		messages = []*Message{}
+	}
+	writeJSON(w, messages)
+}
+
+func (f *Figaro) getResponses(w http.ResponseWriter, r *http.Request, id string) {
+	q := r.URL.Query()
+	to := time.Now()
+	if s := q.Get("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "Invalid to", http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultResponsesPeriod)
+	if s := q.Get("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "Invalid from", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	if _, err := f.st.GetChannel(id); err == sql.ErrNoRows {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	board := q.Get("board")
+	if board == "" {
+		board = DefaultBoard
+	}
+	responses, err := f.Responses(board, id, from, to)
+	if err == ErrNoBoard {
+		http.Error(w, "Board not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("API: Cannot get responses:", err)
+		http.Error(w, "Cannot get responses", http.StatusInternalServerError)
+		return
+	}
+	if responses == nil {
+		responses = []*Response{}
+	}
+	writeJSON(w, responses)
+}
+
+func (f *Figaro) setSLA(w http.ResponseWriter, r *http.Request, id string) {
+	var body struct {
+		SLA uint
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid SLA: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := f.st.GetChannel(id); err == sql.ErrNoRows {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	if err := f.UpdateChannelSLA(id, body.SLA); err != nil {
+		log.Println("API: Cannot change channel SLA:", err)
+		http.Error(w, "Cannot change channel SLA", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, struct {
+		ID  string
+		SLA uint
+	}{id, body.SLA})
+}
+
+func (f *Figaro) setCalendar(w http.ResponseWriter, r *http.Request, id string) {
+	var body struct {
+		Calendar string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid calendar: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := f.st.GetChannel(id); err == sql.ErrNoRows {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	err := f.UpdateChannelCalendar(id, body.Calendar)
+	if err == ErrNoCalendar {
+		http.Error(w, "Calendar not found", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("API: Cannot change channel calendar:", err)
+		http.Error(w, "Cannot change channel calendar", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, struct {
+		ID       string
+		Calendar string
+	}{id, body.Calendar})
+}
+
+func (f *Figaro) handleReports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	values := r.URL.Query()
+	format := values.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		http.Error(w, "Invalid format, use json or csv", http.StatusBadRequest)
+		return
+	}
+	q, err := ParseReportQuery(values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	viewer := viewerID(r)
+	if q.ChannelID != "" && !f.Visible(viewer, q.ChannelID) {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	report, err := f.reporter.Report(q)
+	if err == ErrNoCalendar {
+		http.Error(w, "Calendar not found", http.StatusBadRequest)
+		return
+	}
+	if err == ErrNoBoard {
+		http.Error(w, "Board not found", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("API: Cannot make report:", err)
+		http.Error(w, "Cannot make report", http.StatusInternalServerError)
+		return
+	}
+	visible := make([]*ReportRow, 0, len(report))
+	for _, row := range report {
+		if f.Visible(viewer, row.ChannelID) {
+			visible = append(visible, row)
+		}
+	}
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		if err := WriteReportCSV(w, visible); err != nil {
+			log.Println("API: Cannot write report:", err)
+		}
+		return
+	}
+	writeJSON(w, visible)
+}
+
+func (f *Figaro) handleUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/users/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	users, err := f.st.GetUsers([]string{id})
+	if err != nil {
+		log.Println("API: Cannot get user:", err)
+		http.Error(w, "Cannot get user", http.StatusInternalServerError)
+		return
+	}
+	if len(users) == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, users[0])
+}
+
+func (f *Figaro) handleChangeStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.FormValue("ID")
+	if id == "" {
+		http.Error(w, "ID is required", http.StatusBadRequest)
+		return
+	}
+	ok, err := strconv.ParseBool(r.FormValue("Ok"))
+	if err != nil {
+		http.Error(w, "Invalid Ok value", http.StatusBadRequest)
+		return
+	}
+	if _, err := f.st.GetChannel(id); err == sql.ErrNoRows || !f.Visible(viewerID(r), id) {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	if err := f.UpdateChannelStatus(id, ok); err != nil {
+		log.Println("API: Cannot change channel status:", err)
+		http.Error(w, "Cannot change channel status", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, struct {
+		ID string
+		Ok bool
+	}{id, ok})
+}
+
+func (f *Figaro) handleBoards(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, f.Boards())
+}
+
+func (f *Figaro) handleBoard(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/boards/"), "/")
+	name := parts[0]
+	if name == "" || len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) == 1 {
+		f.manageBoard(w, r, name)
+		return
+	}
+	sb := f.servedBoard(name)
+	if sb == nil {
+		http.Error(w, "Board not found", http.StatusNotFound)
+		return
+	}
+	switch parts[1] {
+	case "", "ws":
+		sb.pu.Handler(w, r)
+	case "events":
+		sb.pu.SSEHandler(w, r)
+	case "channels":
+		f.writeChannels(w, r, name)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *Figaro) manageBoard(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet && !f.requireAdmin(w, r) {
+		return
+	}
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		var board *Board
+		if board, err = f.Board(name); err == nil {
+			writeJSON(w, board)
+			return
+		}
+	case http.MethodPut:
+		board := &Board{}
+		if err := json.NewDecoder(r.Body).Decode(board); err != nil {
+			http.Error(w, "Invalid board: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		board.Name = name
+		if err = f.PutBoard(board); err == nil {
+			writeJSON(w, board)
+			return
+		}
+	case http.MethodDelete:
+		if err = f.DeleteBoard(name); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := err.(boardError); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch err {
+	case ErrNoBoard:
+		http.Error(w, "Board not found", http.StatusNotFound)
+	case ErrDefaultBoard:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		log.Println("API: Cannot change board:", err)
+		http.Error(w, "Cannot change board", http.StatusInternalServerError)
+	}
+}
+
+func (f *Figaro) handleCalendars(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, f.Calendars())
+}
+
+func (f *Figaro) handleCalendar(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/calendars/")
+	if name == "" || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && !f.requireAdmin(w, r) {
+		return
+	}
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		var calendar *Calendar
+		if calendar, err = f.Calendar(name); err == nil {
+			writeJSON(w, calendar)
+			return
+		}
+	case http.MethodPut:
+		calendar := &Calendar{}
+		if err := json.NewDecoder(r.Body).Decode(calendar); err != nil {
+			http.Error(w, "Invalid calendar: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		calendar.Name = name
+		if err = f.PutCalendar(calendar); err == nil {
+			writeJSON(w, calendar)
+			return
+		}
+	case http.MethodDelete:
+		if err = f.DeleteCalendar(name); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := err.(calendarError); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == ErrNoCalendar {
+		http.Error(w, "Calendar not found", http.StatusNotFound)
+		return
+	}
+	log.Println("API: Cannot change calendar:", err)
+	http.Error(w, "Cannot change calendar", http.StatusInternalServerError)
+}
+
+func (f *Figaro) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !f.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	webhooks := f.Webhooks()
+	for i, webhook := range webhooks {
+		webhooks[i] = withoutSecret(webhook)
+	}
+	writeJSON(w, webhooks)
+}
+
+// withoutSecret returns a copy of the webhook without its secret.
+func withoutSecret(webhook *Webhook) *Webhook {
+	copied := *webhook
+	copied.Secret = ""
+	return &copied
+}
+
+func (f *Figaro) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if !f.requireAdmin(w, r) {
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/webhooks/"), "/")
+	name := parts[0]
+	if name == "" || len(parts) > 4 || len(parts) > 1 && parts[1] != "deliveries" {
+		http.NotFound(w, r)
+		return
+	}
+	var err error
+	switch {
+	case len(parts) == 1:
+		err = f.manageWebhook(w, r, name)
+	case len(parts) == 2 || len(parts) == 3 && parts[2] == "":
+		err = f.getDeliveries(w, r, name)
+	default:
+		id, perr := strconv.ParseInt(parts[2], 10, 64)
+		if perr != nil || len(parts) == 4 && parts[3] != "redeliver" {
+			http.NotFound(w, r)
+			return
+		}
+		if len(parts) == 4 {
+			err = f.redeliver(w, r, name, id)
+		} else {
+			err = f.getDelivery(w, r, name, id)
+		}
+	}
+	if err == nil {
+		return
+	}
+	if _, ok := err.(webhookError); ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch err {
+	case ErrNoWebhook:
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+	case ErrNoDelivery:
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+	default:
+		log.Println("API: Cannot manage webhook:", err)
+		http.Error(w, "Cannot manage webhook", http.StatusInternalServerError)
+	}
+}
+
+func (f *Figaro) manageWebhook(w http.ResponseWriter, r *http.Request, name string) error {
+	switch r.Method {
+	case http.MethodGet:
+		webhook, err := f.Webhook(name)
+		if err != nil {
+			return err
+		}
+		writeJSON(w, withoutSecret(webhook))
+	case http.MethodPut:
+		webhook := &Webhook{}
+		if err := json.NewDecoder(r.Body).Decode(webhook); err != nil {
+			return webhookError("invalid webhook: " + err.Error())
+		}
+		webhook.Name = name
+		if err := f.PutWebhook(webhook); err != nil {
+			return err
+		}
+		writeJSON(w, withoutSecret(webhook))
+	case http.MethodDelete:
+		if err := f.DeleteWebhook(name); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+	return nil
+}
+
+func (f *Figaro) getDeliveries(w http.ResponseWriter, r *http.Request, name string) error {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+	limit := uint(defaultDeliveryLimit)
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil || n == 0 || n > maxAPIMessageLimit {
+			return webhookError(fmt.Sprintf("limit must be from 1 to %d", maxAPIMessageLimit))
+		}
+		limit = uint(n)
+	}
+	deliveries, err := f.Deliveries(name, limit)
+	if err != nil {
+		return err
+	}
+	if deliveries == nil {
+		deliveries = []*Delivery{}
+	}
+	writeJSON(w, deliveries)
+	return nil
+}
+
+func (f *Figaro) getDelivery(w http.ResponseWriter, r *http.Request, name string, id int64) error {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+	d, err := f.Delivery(name, id)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, d)
+	return nil
+}
+
+func (f *Figaro) redeliver(w http.ResponseWriter, r *http.Request, name string, id int64) error {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+	d, err := f.Redeliver(name, id)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, d)
+	return nil
+}
+
+// requireAdmin answers 403 Forbidden unless the user of the request is an
+// admin. It returns true for admins.
+func (f *Figaro) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if f.IsAdmin(viewerID(r)) {
+		return true
+	}
+	http.Error(w, "Only admins may manage the configuration", http.StatusForbidden)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("API: Cannot write response:", err)
+	}
+}
@@ -0,0 +1,159 @@
+package figaro
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// asUser returns the request made by the signed in user, or without signing
+// in if userID is empty.
+func asUser(r *http.Request, userID string) *http.Request {
+	if userID == "" {
+		return r
+	}
+	session := &Session{UserID: userID}
+	return r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, session))
+}
+
+func TestAdminOnly(t *testing.T) {
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	f, _, _ := newTestFigaro(t, []*Channel{{ID: "C1", Name: "one"}},
+		[]*Message{testMessage("UGUEST", t0, "question")})
+	h := f.Handler()
+	// The requests are made in order
+	tests := []struct {
+		user, method, path, body string
+		want                     int
+	}{
+		{"UDENY", "PUT", "/boards/sales", `{"Include": ["^sales-"]}`, http.StatusForbidden},
+		{"UINT", "PUT", "/boards/sales", `{"Include": ["^sales-"]}`, http.StatusOK},
+		{"UDENY", "GET", "/boards/sales", "", http.StatusOK},
+		{"UDENY", "DELETE", "/boards/sales", "", http.StatusForbidden},
+		{"UDENY", "PUT", "/calendars/berlin", `{"TimeZone": "Europe/Berlin"}`, http.StatusForbidden},
+		{"UINT", "PUT", "/calendars/berlin", `{"TimeZone": "Europe/Berlin"}`, http.StatusOK},
+		{"UDENY", "GET", "/calendars/berlin", "", http.StatusOK},
+		{"UDENY", "DELETE", "/calendars/berlin", "", http.StatusForbidden},
+		{"UDENY", "PUT", "/channels/C1/sla", `{"SLA": 60}`, http.StatusForbidden},
+		{"UDENY", "PUT", "/channels/C1/calendar", `{"Calendar": "berlin"}`, http.StatusForbidden},
+		{"UDENY", "GET", "/channels/C1", "", http.StatusOK},
+		{"UINT", "PUT", "/channels/C1/sla", `{"SLA": 60}`, http.StatusOK},
+		{"UINT", "PUT", "/channels/C1/calendar", `{"Calendar": "berlin"}`, http.StatusOK},
+		{"", "PUT", "/channels/C1/sla", `{"SLA": 0}`, http.StatusOK},
+		{"UINT", "DELETE", "/calendars/berlin", "", http.StatusNoContent},
+		{"UINT", "DELETE", "/boards/sales", "", http.StatusNoContent},
+		{"UDENY", "PUT", "/webhooks/tickets", `{"URL": "http://127.0.0.1:1/", "Secret": "s"}`, http.StatusForbidden},
+		{"UINT", "PUT", "/webhooks/tickets", `{"URL": "http://127.0.0.1:1/", "Secret": "s"}`, http.StatusOK},
+		{"UDENY", "GET", "/webhooks", "", http.StatusForbidden},
+		{"UDENY", "GET", "/webhooks/tickets", "", http.StatusForbidden},
+		{"UDENY", "GET", "/webhooks/tickets/deliveries", "", http.StatusForbidden},
+		{"UDENY", "GET", "/webhooks/tickets/deliveries/1", "", http.StatusForbidden},
+		{"UDENY", "POST", "/webhooks/tickets/deliveries/1/redeliver", "", http.StatusForbidden},
+		{"UDENY", "DELETE", "/webhooks/tickets", "", http.StatusForbidden},
+		{"UINT", "GET", "/webhooks", "", http.StatusOK},
+		{"UINT", "GET", "/webhooks/tickets/deliveries", "", http.StatusOK},
+		{"", "GET", "/webhooks/tickets", "", http.StatusOK},
+		{"UINT", "DELETE", "/webhooks/tickets", "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		h.ServeHTTP(rec, asUser(r, tt.user))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s by %q: got %d, want %d: %s", tt.method, tt.path,
+				tt.user, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+}
+
+// sseEvent is an event of a Server-Sent Events stream.
+type sseEvent struct {
+	id    string
+	event *BoardEvent
+}
+
+// streamEvents requests the events of the server with the Last-Event-ID
+// header if lastID is not empty and returns the first of them. The stream
+// is closed after the test.
+func streamEvents(t *testing.T, srv *httptest.Server, lastID string) sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	r, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID != "" {
+		r.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	var e sseEvent
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			e.event = &BoardEvent{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), e.event); err != nil {
+				t.Fatal(err)
+			}
+		case line == "" && e.event != nil:
+			return e
+		}
+	}
+	t.Fatal("the stream ended without events:", scanner.Err())
+	return e
+}
+
+func TestEventsResume(t *testing.T) {
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	f, _, _ := newTestFigaro(t, []*Channel{{ID: "C1", Name: "one"}},
+		[]*Message{testMessage("UGUEST", t0, "question")})
+	// Streams are closed before the server
+	srv := httptest.NewServer(f.Handler())
+	t.Cleanup(srv.Close)
+	first := streamEvents(t, srv, "")
+	if first.event.Type != BoardEventSnapshot ||
+		first.id != fmt.Sprintf("%s:%d", first.event.Epoch, first.event.Seq) {
+		t.Fatalf("got %s event with ID %q, want a snapshot", first.event.Type, first.id)
+	}
+	if err := f.UpdateChannelStatus("C1", true); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		lastID   string
+		wantType string
+	}{
+		{name: "resume", lastID: first.id, wantType: BoardEventStatusChange},
+		{name: "other epoch", lastID: "other:" + strconv.FormatUint(first.event.Seq, 10),
+			wantType: BoardEventSnapshot},
+		{name: "invalid ID", lastID: first.event.Epoch + ":last", wantType: BoardEventSnapshot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := streamEvents(t, srv, tt.lastID)
+			if e.event.Type != tt.wantType || e.event.Seq != first.event.Seq+1 {
+				t.Errorf("got %s event %d, want %s event %d", e.event.Type,
+					e.event.Seq, tt.wantType, first.event.Seq+1)
+			}
+			if tt.wantType == BoardEventStatusChange && (e.event.ID != "C1" || !*e.event.Ok) {
+				t.Errorf("got status change %+v, want C1 OK", e.event)
+			}
+		})
+	}
+}
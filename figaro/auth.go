@@ -0,0 +1,326 @@
+package figaro
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// SlackAuthorizeURL is the Sign in with Slack authorization endpoint.
+	SlackAuthorizeURL = "https://slack.com/openid/connect/authorize"
+	// SlackAPIURL is the Slack Web API base URL.
+	SlackAPIURL = "https://slack.com/api/"
+
+	sessionCookie   = "figaro_session"
+	stateCookie     = "figaro_state"
+	sessionLifetime = 7 * 24 * time.Hour
+	stateLifetime   = 10 * time.Minute
+)
+
+var errNoSession = errors.New("no valid session")
+
+// sessionContextKey is the request context key of the session.
+type sessionContextKey struct{}
+
+// AuthConfig configures Sign in with Slack.
+type AuthConfig struct {
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the URL of the callback endpoint registered in the
+	// Slack app, for example https://figaro.example.com/auth/callback
+	RedirectURL string
+	// HomeURL is where users go after signing in.
+	HomeURL string
+	// SessionKey signs session cookies.
+	SessionKey string
+	// Domains are organization domains. Only users with emails in them get
+	// access.
+	Domains []string
+	// AuthorizeURL and APIURL default to Slack ones.
+	AuthorizeURL string
+	APIURL       string
+}
+
+// Session is a signed in user.
+type Session struct {
+	UserID  string
+	Email   string
+	Name    string
+	Expires time.Time
+}
+
+// Auth implements Sign in with Slack (OpenID Connect) and keeps users
+// signed in with session cookies.
+type Auth struct {
+	conf   AuthConfig
+	secure bool
+	client *http.Client
+}
+
+// NewAuth creates Auth. It returns error if the configuration is incomplete.
+func NewAuth(conf AuthConfig) (*Auth, error) {
+	if conf.ClientID == "" || conf.ClientSecret == "" || conf.RedirectURL == "" {
+		return nil, errors.New("Sign in with Slack needs client ID, client secret and redirect URL")
+	}
+	if len(conf.SessionKey) < 32 {
+		return nil, errors.New("session key must be at least 32 characters long")
+	}
+	if len(conf.Domains) == 0 {
+		return nil, errors.New("no domains to allow")
+	}
+	if conf.HomeURL == "" {
+		conf.HomeURL = "/"
+	}
+	if conf.AuthorizeURL == "" {
+		conf.AuthorizeURL = SlackAuthorizeURL
+	}
+	if conf.APIURL == "" {
+		conf.APIURL = SlackAPIURL
+	} else if !strings.HasSuffix(conf.APIURL, "/") {
+		conf.APIURL += "/"
+	}
+	return &Auth{
+		conf:   conf,
+		secure: strings.HasPrefix(conf.RedirectURL, "https://"),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Handler serves the sign in endpoints:
+//
+//	GET /auth/login     - redirects to Slack
+//	GET /auth/callback  - Slack redirects here after signing in
+//	GET /auth/logout    - forgets the session
+//	GET /auth/me        - the signed in user
+func (a *Auth) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/login", a.handleLogin)
+	mux.HandleFunc("/auth/callback", a.handleCallback)
+	mux.HandleFunc("/auth/logout", a.handleLogout)
+	mux.Handle("/auth/me", a.Require(http.HandlerFunc(a.handleMe)))
+	return mux
+}
+
+// Require lets only signed in users from the organization domains through.
+// Others get 401 Unauthorized, so that clients send them to /auth/login.
+// The WebSocket upgrade is a GET request with cookies, so it's protected
+// the same way. The session is put to the request context, see
+// SessionFromContext.
+func (a *Auth) Require(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := a.Session(r)
+		if err != nil {
+			http.Error(w, "Sign in with Slack at /auth/login", http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), sessionContextKey{}, session)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// SessionFromContext returns the session Require put to the context or nil.
+func SessionFromContext(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionContextKey{}).(*Session)
+	return session
+}
+
+// viewerID returns the Slack ID of the signed in user of the request or the
+// empty string if authentication is off.
+func viewerID(r *http.Request) string {
+	if session := SessionFromContext(r.Context()); session != nil {
+		return session.UserID
+	}
+	return ""
+}
+
+// Session returns the session of the request. Sessions of users who are not
+// in the domains anymore are not valid.
+func (a *Auth) Session(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, errNoSession
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 2 {
+		return nil, errNoSession
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errNoSession
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, a.sign(payload)) {
+		return nil, errNoSession
+	}
+	session := &Session{}
+	if err := json.Unmarshal(payload, session); err != nil {
+		return nil, errNoSession
+	}
+	if time.Now().After(session.Expires) || !isInDomains(session.Email, a.conf.Domains) {
+		return nil, errNoSession
+	}
+	return session, nil
+}
+
+func (a *Auth) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(a.conf.SessionKey))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (a *Auth) setSession(w http.ResponseWriter, session *Session) {
+	payload, err := json.Marshal(session)
+	if err != nil {
+		log.Fatalln("Auth: Cannot marshal session:", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name: sessionCookie,
+		Value: base64.RawURLEncoding.EncodeToString(payload) + "." +
+			base64.RawURLEncoding.EncodeToString(a.sign(payload)),
+		Path:     "/",
+		Expires:  session.Expires,
+		HttpOnly: true,
+		Secure:   a.secure,
+		// Lax keeps other sites from posting to the API with the cookie
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (a *Auth) handleLogin(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Println("Auth: Cannot generate state:", err)
+		http.Error(w, "Cannot sign in", http.StatusInternalServerError)
+		return
+	}
+	state := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(stateLifetime / time.Second),
+		HttpOnly: true,
+		Secure:   a.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("scope", "openid email profile")
+	q.Set("client_id", a.conf.ClientID)
+	q.Set("redirect_uri", a.conf.RedirectURL)
+	q.Set("state", state)
+	http.Redirect(w, r, a.conf.AuthorizeURL+"?"+q.Encode(), http.StatusFound)
+}
+
+func (a *Auth) handleCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		http.Error(w, "Cannot sign in: "+e, http.StatusUnauthorized)
+		return
+	}
+	state, err := r.Cookie(stateCookie)
+	if err != nil || state.Value == "" || state.Value != q.Get("state") {
+		http.Error(w, "Invalid state, sign in again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/", MaxAge: -1})
+	info, err := a.userInfo(q.Get("code"))
+	if err != nil {
+		log.Println("Auth: Cannot get user info:", err)
+		http.Error(w, "Cannot sign in", http.StatusUnauthorized)
+		return
+	}
+	if !info.EmailVerified || !isInDomains(info.Email, a.conf.Domains) {
+		log.Println("Auth: Access denied to", info.Email)
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	log.Println("Auth: Signed in", info.Email)
+	a.setSession(w, &Session{
+		UserID:  info.UserID,
+		Email:   info.Email,
+		Name:    info.Name,
+		Expires: time.Now().Add(sessionLifetime),
+	})
+	http.Redirect(w, r, a.conf.HomeURL, http.StatusFound)
+}
+
+func (a *Auth) handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, a.conf.HomeURL, http.StatusFound)
+}
+
+func (a *Auth) handleMe(w http.ResponseWriter, r *http.Request) {
+	session, err := a.Session(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, session)
+}
+
+// slackUserInfo is the response of openid.connect.userInfo.
+type slackUserInfo struct {
+	OK            bool   `json:"ok"`
+	Error         string `json:"error"`
+	UserID        string `json:"https://slack.com/user_id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// userInfo exchanges the code for an access token and gets the user with it.
+// See https://api.slack.com/authentication/sign-in-with-slack
+func (a *Auth) userInfo(code string) (*slackUserInfo, error) {
+	token := struct {
+		OK          bool   `json:"ok"`
+		Error       string `json:"error"`
+		AccessToken string `json:"access_token"`
+	}{}
+	resp, err := a.client.PostForm(a.conf.APIURL+"openid.connect.token", url.Values{
+		"client_id":     {a.conf.ClientID},
+		"client_secret": {a.conf.ClientSecret},
+		"code":          {code},
+		"redirect_uri":  {a.conf.RedirectURL},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+	if !token.OK {
+		return nil, fmt.Errorf("openid.connect.token: %s", token.Error)
+	}
+	req, err := http.NewRequest(http.MethodPost, a.conf.APIURL+"openid.connect.userInfo", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	resp, err = a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	info := &slackUserInfo{}
+	if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+		return nil, err
+	}
+	if !info.OK {
+		return nil, fmt.Errorf("openid.connect.userInfo: %s", info.Error)
+	}
+	return info, nil
+}
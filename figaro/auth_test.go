@@ -0,0 +1,186 @@
+package figaro
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	nlopesslack "github.com/nlopes/slack"
+
+	"github.com/adyatlov/figaro/figaro/slacktest"
+)
+
+const testSessionKey = "0123456789abcdef0123456789abcdef"
+
+// newTestAuth returns Auth for corp.com which signs in with the stand-in
+// if it's not nil.
+func newTestAuth(t *testing.T, srv *slacktest.Server) *Auth {
+	t.Helper()
+	conf := AuthConfig{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://figaro.test/auth/callback",
+		HomeURL:      "/home",
+		SessionKey:   testSessionKey,
+		Domains:      []string{"corp.com"},
+	}
+	if srv != nil {
+		conf.AuthorizeURL = srv.AuthorizeURL
+		// The slash is added
+		conf.APIURL = strings.TrimSuffix(srv.APIURL, "/")
+	}
+	a, err := NewAuth(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// sessionCookieOf returns the session cookie Auth sets for the session.
+func sessionCookieOf(a *Auth, session *Session) *http.Cookie {
+	rec := httptest.NewRecorder()
+	a.setSession(rec, session)
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == sessionCookie {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestSessionCookie(t *testing.T) {
+	a := newTestAuth(t, nil)
+	session := &Session{UserID: "U1", Email: "ann@corp.com", Name: "Ann",
+		Expires: time.Now().Add(time.Hour)}
+	valid := sessionCookieOf(a, session).Value
+	parts := strings.Split(valid, ".")
+	// tampered returns the valid cookie with the session changed
+	tampered := func(change func(s *Session)) string {
+		s := *session
+		change(&s)
+		payload, _ := json.Marshal(&s)
+		return base64.RawURLEncoding.EncodeToString(payload) + "." + parts[1]
+	}
+	otherKey := *a
+	otherKey.conf.SessionKey = strings.Repeat("x", 32)
+	tests := []struct {
+		name   string
+		auth   *Auth
+		cookie string
+		wantOk bool
+	}{
+		{name: "valid", cookie: valid, wantOk: true},
+		{name: "other user", cookie: tampered(func(s *Session) { s.UserID = "U2" })},
+		{name: "extended", cookie: tampered(func(s *Session) { s.Expires = s.Expires.Add(time.Hour) })},
+		{name: "other signature", cookie: parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte("sig"))},
+		{name: "other key", auth: &otherKey, cookie: valid},
+		{name: "no signature", cookie: parts[0]},
+		{name: "not base64", cookie: "!." + parts[1]},
+		{name: "expired", cookie: sessionCookieOf(a, &Session{UserID: "U1",
+			Email: "ann@corp.com", Expires: time.Now().Add(-time.Second)}).Value},
+		{name: "other domain", cookie: sessionCookieOf(a, &Session{UserID: "U1",
+			Email: "bob@customer.com", Expires: time.Now().Add(time.Hour)}).Value},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := a
+			if tt.auth != nil {
+				auth = tt.auth
+			}
+			r := httptest.NewRequest("GET", "/auth/me", nil)
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: tt.cookie})
+			got, err := auth.Session(r)
+			if (err == nil) != tt.wantOk {
+				t.Fatalf("got error %v, want ok %v", err, tt.wantOk)
+			}
+			if tt.wantOk && (got.UserID != "U1" || got.Email != "ann@corp.com") {
+				t.Errorf("got session %+v", got)
+			}
+		})
+	}
+}
+
+func TestAuthCallback(t *testing.T) {
+	user := func(id, email string) nlopesslack.User {
+		u := nlopesslack.User{ID: id, RealName: id}
+		u.Profile.Email = email
+		return u
+	}
+	srv := slacktest.NewServer(&slacktest.Fixtures{Users: []nlopesslack.User{
+		user("U1", "ann@corp.com"), user("U2", "bob@customer.com")}})
+	defer srv.Close()
+	a := newTestAuth(t, srv)
+	h := a.Handler()
+	noRedirects := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	tests := []struct {
+		name       string
+		user       string // Signs in at the stand-in
+		state      string // Of the callback, the one of the login if empty
+		wantStatus int
+	}{
+		{name: "signed in", user: "U1", wantStatus: http.StatusFound},
+		{name: "other domain", user: "U2", wantStatus: http.StatusForbidden},
+		{name: "other state", user: "U1", state: "forged", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/auth/login", nil))
+			var state *http.Cookie
+			for _, cookie := range rec.Result().Cookies() {
+				if cookie.Name == stateCookie {
+					state = cookie
+				}
+			}
+			if rec.Code != http.StatusFound || state == nil {
+				t.Fatalf("got login status %d and state %v", rec.Code, state)
+			}
+			srv.SignInUser = tt.user
+			resp, err := noRedirects.Get(rec.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			callback, err := url.Parse(resp.Header.Get("Location"))
+			if err != nil || callback.Path != "/auth/callback" {
+				t.Fatalf("got redirect to %q: %v", resp.Header.Get("Location"), err)
+			}
+			if tt.state != "" {
+				q := callback.Query()
+				q.Set("state", tt.state)
+				callback.RawQuery = q.Encode()
+			}
+			r := httptest.NewRequest("GET", callback.RequestURI(), nil)
+			r.AddCookie(state)
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusFound {
+				return
+			}
+			if location := rec.Header().Get("Location"); location != "/home" {
+				t.Errorf("got redirect to %q, want /home", location)
+			}
+			r = httptest.NewRequest("GET", "/auth/me", nil)
+			for _, cookie := range rec.Result().Cookies() {
+				r.AddCookie(cookie)
+			}
+			session, err := a.Session(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if session.UserID != tt.user || session.Email != "ann@corp.com" {
+				t.Errorf("got session %+v", session)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package figaro
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// ProtocolVersion is the version of board events. It changes when events
+// change incompatibly. See PROTOCOL.md.
+const ProtocolVersion = 1
+
+// Board event types.
+const (
+	// BoardEventSnapshot carries the whole board in Board.
+	BoardEventSnapshot = "snapshot"
+	// BoardEventChannelUpsert carries a new or changed channel in Channel and
+	// the half of the board it belongs to in Half.
+	BoardEventChannelUpsert = "channel_upsert"
+	// BoardEventChannelRemove carries ID of a channel which left the board.
+	BoardEventChannelRemove = "channel_remove"
+	// BoardEventStatusChange carries ID of a channel which changed only its
+	// OK flag or the half of the board, the flag in Ok and the half in Half.
+	BoardEventStatusChange = "status_change"
+)
+
+// Halves of the board.
+const (
+	HalfBad = "Bad"
+	HalfOk  = "Ok"
+)
+
+// BoardEvent is pushed to clients when the board changes. Clients get a
+// snapshot when they connect and changes after it. Seq grows by one with
+// every change within Epoch, a snapshot has Seq of the last change it
+// includes.
+type BoardEvent struct {
+	Version int
+	Epoch   string
+	Seq     uint64
+	Type    string
+	Board   *ChannelPair `json:",omitempty"`
+	Channel *Channel     `json:",omitempty"`
+	ID      string       `json:",omitempty"`
+	Half    string       `json:",omitempty"`
+	Ok      *bool        `json:",omitempty"`
+}
+
+// board keeps channels shown to users in memory, so that an event updates
+// only the channel it belongs to.
+type board struct {
+	mu       sync.RWMutex
+	epoch    string
+	seq      uint64
+	channels map[string]*boardChannel
+}
+
+type boardChannel struct {
+	channel *Channel
+	half    string
+	// data is the channel JSON without the OK flag which tells if the
+	// channel changed or only its status did.
+	data []byte
+}
+
+func newBoard(epoch string) *board {
+	return &board{epoch: epoch, channels: make(map[string]*boardChannel)}
+}
+
+// event returns an event of the type with the next sequence number. It must
+// be called with the lock held.
+func (b *board) event(typ string) *BoardEvent {
+	b.seq++
+	return &BoardEvent{
+		Version: ProtocolVersion,
+		Epoch:   b.epoch,
+		Seq:     b.seq,
+		Type:    typ,
+	}
+}
+
+// set puts the channel to the half of the board. It returns the change
+// event or nil if the channel is there already and hasn't changed.
+func (b *board) set(channel *Channel, half string) *BoardEvent {
+	noStatus := *channel
+	noStatus.Ok = false
+	data, err := json.Marshal(&noStatus)
+	if err != nil {
+		log.Fatalln("Figaro: Cannot marshal channel:", err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	old, ok := b.channels[channel.ID]
+	b.channels[channel.ID] = &boardChannel{channel: channel, half: half, data: data}
+	if ok && string(old.data) == string(data) {
+		if old.half == half && old.channel.Ok == channel.Ok {
+			return nil
+		}
+		event := b.event(BoardEventStatusChange)
+		event.ID = channel.ID
+		event.Half = half
+		event.Ok = &channel.Ok
+		return event
+	}
+	event := b.event(BoardEventChannelUpsert)
+	event.Channel = channel
+	event.Half = half
+	return event
+}
+
+// remove removes the channel from the board. It returns the change event or
+// nil if the channel isn't there.
+func (b *board) remove(id string) *BoardEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.channels[id]; !ok {
+		return nil
+	}
+	delete(b.channels, id)
+	event := b.event(BoardEventChannelRemove)
+	event.ID = id
+	return event
+}
+
+// get returns an upsert event with the channel as it is on the board or nil
+// if the channel isn't there. It's for users who have just got to see the
+// channel.
+func (b *board) get(id string) *BoardEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bc, ok := b.channels[id]
+	if !ok {
+		return nil
+	}
+	event := b.event(BoardEventChannelUpsert)
+	event.Channel = bc.channel
+	event.Half = bc.half
+	return event
+}
+
+// hide returns a remove event for the channel which stays on the board or
+// nil if the channel isn't there. It's for users who can't see the channel
+// anymore.
+func (b *board) hide(id string) *BoardEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.channels[id]; !ok {
+		return nil
+	}
+	event := b.event(BoardEventChannelRemove)
+	event.ID = id
+	return event
+}
+
+// ids returns IDs of channels on the board.
+func (b *board) ids() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	ids := make([]string, 0, len(b.channels))
+	for id := range b.channels {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// channel returns the channel and its half or nil if the channel isn't on
+// the board.
+func (b *board) channel(id string) (*Channel, string) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	bc, ok := b.channels[id]
+	if !ok {
+		return nil, ""
+	}
+	return bc.channel, bc.half
+}
+
+// list returns channels on the board.
+func (b *board) list() []*Channel {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	channels := make([]*Channel, 0, len(b.channels))
+	for _, bc := range b.channels {
+		channels = append(channels, bc.channel)
+	}
+	return channels
+}
+
+// snapshot returns the board with channels which pass visible and the
+// sequence number of the last change.
+func (b *board) snapshot(visible func(chID string) bool) *BoardEvent {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return &BoardEvent{
+		Version: ProtocolVersion,
+		Epoch:   b.epoch,
+		Seq:     b.seq,
+		Type:    BoardEventSnapshot,
+		Board:   b.pairLocked(visible),
+	}
+}
+
+// pair returns channels of the board which pass visible as a ChannelPair
+// sorted by the last message time.
+func (b *board) pair(visible func(chID string) bool) *ChannelPair {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.pairLocked(visible)
+}
+
+func (b *board) pairLocked(visible func(chID string) bool) *ChannelPair {
+	channelPair := &ChannelPair{}
+	for id, bc := range b.channels {
+		if !visible(id) {
+			continue
+		}
+		if bc.half == HalfOk {
+			channelPair.Ok = append(channelPair.Ok, bc.channel)
+		} else {
+			channelPair.Bad = append(channelPair.Bad, bc.channel)
+		}
+	}
+	sortChannelsByLastMessageTime(channelPair.Ok)
+	sortChannelsByLastMessageTime(channelPair.Bad)
+	return channelPair
+}
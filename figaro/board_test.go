@@ -0,0 +1,89 @@
+package figaro
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBoardDeltas(t *testing.T) {
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	channel := func(name string, ok bool) *Channel {
+		return &Channel{ID: "C1", Name: name, Ok: ok,
+			Messages: []*Message{testMessage("UGUEST", t0, "question")}}
+	}
+	tests := []struct {
+		name       string
+		before     *Channel // Not on the board if nil
+		beforeHalf string
+		after      *Channel // Removed if nil
+		afterHalf  string
+		wantType   string // No event if empty
+	}{
+		{name: "new channel", after: channel("one", false), afterHalf: HalfBad,
+			wantType: BoardEventChannelUpsert},
+		{name: "unchanged", before: channel("one", false), beforeHalf: HalfBad,
+			after: channel("one", false), afterHalf: HalfBad},
+		{name: "marked OK", before: channel("one", false), beforeHalf: HalfBad,
+			after: channel("one", true), afterHalf: HalfOk, wantType: BoardEventStatusChange},
+		{name: "half only", before: channel("one", false), beforeHalf: HalfBad,
+			after: channel("one", false), afterHalf: HalfOk, wantType: BoardEventStatusChange},
+		{name: "flag only", before: channel("one", true), beforeHalf: HalfOk,
+			after: channel("one", false), afterHalf: HalfOk, wantType: BoardEventStatusChange},
+		{name: "renamed", before: channel("one", false), beforeHalf: HalfBad,
+			after: channel("renamed", false), afterHalf: HalfBad, wantType: BoardEventChannelUpsert},
+		{name: "renamed and marked OK", before: channel("one", false), beforeHalf: HalfBad,
+			after: channel("renamed", true), afterHalf: HalfOk, wantType: BoardEventChannelUpsert},
+		{name: "removed", before: channel("one", false), beforeHalf: HalfBad,
+			wantType: BoardEventChannelRemove},
+		{name: "removed twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBoard("epoch")
+			if tt.before != nil {
+				b.set(tt.before, tt.beforeHalf)
+			}
+			seq := b.seq
+			var event *BoardEvent
+			if tt.after != nil {
+				event = b.set(tt.after, tt.afterHalf)
+			} else {
+				event = b.remove("C1")
+			}
+			if tt.wantType == "" {
+				if event != nil {
+					t.Fatalf("got %s event, want none", event.Type)
+				}
+				if b.seq != seq {
+					t.Errorf("got seq %d without events, want %d", b.seq, seq)
+				}
+				return
+			}
+			if event == nil {
+				t.Fatalf("got no event, want %s", tt.wantType)
+			}
+			if event.Type != tt.wantType || event.Seq != seq+1 || event.Epoch != "epoch" {
+				t.Errorf("got %s event %s:%d, want %s event epoch:%d", event.Type,
+					event.Epoch, event.Seq, tt.wantType, seq+1)
+			}
+			switch event.Type {
+			case BoardEventChannelUpsert:
+				if event.Channel != tt.after || event.Half != tt.afterHalf {
+					t.Errorf("got upsert of %+v to %s", event.Channel, event.Half)
+				}
+			case BoardEventStatusChange:
+				if event.ID != "C1" || event.Half != tt.afterHalf || event.Ok == nil ||
+					*event.Ok != tt.after.Ok || event.Channel != nil {
+					t.Errorf("got status change %+v", event)
+				}
+			case BoardEventChannelRemove:
+				if event.ID != "C1" {
+					t.Errorf("got removal of %s", event.ID)
+				}
+				if channel, _ := b.channel("C1"); channel != nil {
+					t.Error("the removed channel is on the board")
+				}
+			}
+		})
+	}
+}
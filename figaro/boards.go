@@ -0,0 +1,327 @@
+package figaro
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultBoard is the name of the board Figaro is created with. It's served
+// at / and /ws as well as at /boards/default/ and can't be changed with the
+// API.
+const DefaultBoard = "default"
+
+var (
+	// ErrNoBoard is returned for boards which don't exist.
+	ErrNoBoard = errors.New("board not found")
+	// ErrDefaultBoard is returned on attempts to change the default board.
+	ErrDefaultBoard = errors.New("the default board is configured on start and can't be changed")
+
+	boardNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+)
+
+// boardError tells what's wrong with a board configuration.
+type boardError string
+
+func (e boardError) Error() string {
+	return string(e)
+}
+
+// servedBoard is a board with its current state and clients. It's replaced
+// as a whole when the board changes, the state and the push service stay.
+type servedBoard struct {
+	conf     *Board
+	pattern  string // Include patterns joined into one
+	include  []*regexp.Regexp
+	exclude  []*regexp.Regexp
+	channels map[string]bool
+	teams    map[string]bool
+	state    *board
+	pu       *PushService
+}
+
+// compileBoard validates the board and compiles its patterns.
+func compileBoard(conf *Board) (*servedBoard, error) {
+	if !boardNameRe.MatchString(conf.Name) {
+		return nil, boardError(fmt.Sprintf("invalid board name %q, use lowercase letters, digits, - and _", conf.Name))
+	}
+	if conf.MessageLimit == 0 || conf.MessageLimit > maxAPIMessageLimit {
+		return nil, boardError(fmt.Sprintf("message limit must be from 1 to %d", maxAPIMessageLimit))
+	}
+	sb := &servedBoard{
+		conf:     conf,
+		channels: make(map[string]bool),
+		teams:    make(map[string]bool),
+	}
+	var patterns []string
+	for _, pattern := range conf.Include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, boardError(fmt.Sprintf("invalid include pattern %q: %v", pattern, err))
+		}
+		sb.include = append(sb.include, re)
+		patterns = append(patterns, "(?:"+pattern+")")
+	}
+	sb.pattern = strings.Join(patterns, "|")
+	for _, pattern := range conf.Exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, boardError(fmt.Sprintf("invalid exclude pattern %q: %v", pattern, err))
+		}
+		sb.exclude = append(sb.exclude, re)
+	}
+	for _, id := range conf.Channels {
+		sb.channels[id] = true
+	}
+	for _, id := range conf.Teams {
+		sb.teams[id] = true
+	}
+	return sb, nil
+}
+
+// matches tells if the channel belongs to the board.
+func (sb *servedBoard) matches(channel *Channel) bool {
+	if sb.channels[channel.ID] {
+		return true
+	}
+	if len(sb.teams) > 0 && !sb.teams[channel.TeamID] {
+		return false
+	}
+	included := false
+	for _, re := range sb.include {
+		if re.MatchString(channel.Name) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, re := range sb.exclude {
+		if re.MatchString(channel.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// boardChange asks to create, replace or delete (if conf is nil) the board
+// with the name. err is set and done is closed when the change is applied.
+type boardChange struct {
+	name string
+	conf *Board
+	err  error
+	done chan struct{}
+}
+
+// Boards returns all boards sorted by name, the default one included.
+func (f *Figaro) Boards() []*Board {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	boards := make([]*Board, 0, len(f.boards))
+	for _, sb := range f.boards {
+		boards = append(boards, sb.conf)
+	}
+	sort.Slice(boards, func(i, j int) bool {
+		return boards[i].Name < boards[j].Name
+	})
+	return boards
+}
+
+// Board returns the board by its name or ErrNoBoard.
+func (f *Figaro) Board(name string) (*Board, error) {
+	sb := f.servedBoard(name)
+	if sb == nil {
+		return nil, ErrNoBoard
+	}
+	return sb.conf, nil
+}
+
+func (f *Figaro) servedBoard(name string) *servedBoard {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.boards[name]
+}
+
+// servedBoards returns all boards sorted by name.
+func (f *Figaro) servedBoards() []*servedBoard {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	boards := make([]*servedBoard, 0, len(f.boards))
+	for _, sb := range f.boards {
+		boards = append(boards, sb)
+	}
+	sort.Slice(boards, func(i, j int) bool {
+		return boards[i].conf.Name < boards[j].conf.Name
+	})
+	return boards
+}
+
+// PutBoard creates the board or replaces the one with the same name. The
+// message limit of Figaro is used if the board has none. It returns when
+// clients of the board see the change.
+func (f *Figaro) PutBoard(conf *Board) error {
+	if conf.Name == DefaultBoard {
+		return ErrDefaultBoard
+	}
+	if conf.MessageLimit == 0 {
+		conf.MessageLimit = f.messageLimit
+	}
+	if _, err := compileBoard(conf); err != nil {
+		return err
+	}
+	if conf.Calendar != "" && f.calendar(conf.Calendar) == nil {
+		return boardError(fmt.Sprintf("unknown calendar %q", conf.Calendar))
+	}
+	if err := f.st.UpdateBoard(conf); err != nil {
+		return err
+	}
+	return f.changeBoard(conf.Name, conf)
+}
+
+// DeleteBoard deletes the board and disconnects its clients.
+func (f *Figaro) DeleteBoard(name string) error {
+	if name == DefaultBoard {
+		return ErrDefaultBoard
+	}
+	if f.servedBoard(name) == nil {
+		return ErrNoBoard
+	}
+	if err := f.st.DeleteBoard(name); err != nil {
+		return err
+	}
+	return f.changeBoard(name, nil)
+}
+
+func (f *Figaro) changeBoard(name string, conf *Board) error {
+	change := &boardChange{name: name, conf: conf, done: make(chan struct{})}
+	f.boardCh <- change
+	<-change.done
+	return change.err
+}
+
+// applyBoardChange must be called by the serve goroutine only, because it
+// pushes events.
+func (f *Figaro) applyBoardChange(change *boardChange) {
+	defer close(change.done)
+	old := f.servedBoard(change.name)
+	if change.conf == nil {
+		if old == nil {
+			return
+		}
+		f.mu.Lock()
+		delete(f.boards, change.name)
+		f.mu.Unlock()
+		old.pu.Close()
+		log.Println("Figaro: Board deleted:", change.name)
+		return
+	}
+	sb, err := compileBoard(change.conf)
+	if err != nil {
+		change.err = err
+		return
+	}
+	if old != nil {
+		sb.state = old.state
+		sb.pu = old.pu
+	} else {
+		sb.pu = f.pu.sibling()
+		sb.state = newBoard(sb.pu.Epoch())
+		f.servePushes(sb)
+	}
+	f.mu.Lock()
+	f.boards[change.name] = sb
+	f.mu.Unlock()
+	if err := f.updateMembers([]*servedBoard{sb}); err != nil {
+		log.Println("Figaro: Cannot update members of board:", err)
+	}
+	// Other domains tell other guests, so questions are derived again. New
+	// boards may have questions left by a deleted board with the same name.
+	rederive := old == nil ||
+		strings.Join(old.conf.Domains, ",") != strings.Join(sb.conf.Domains, ",")
+	if change.err = f.backfillBoardResponses(sb, rederive); change.err != nil {
+		return
+	}
+	if change.err = f.updateBoard(sb); change.err != nil {
+		return
+	}
+	log.Println("Figaro: Board updated:", change.name)
+}
+
+// loadBoards adds the boards kept in the storage.
+func (f *Figaro) loadBoards() error {
+	boards, err := f.st.GetBoards()
+	if err != nil {
+		return err
+	}
+	for _, conf := range boards {
+		if conf.Name == DefaultBoard {
+			log.Println("Figaro: Stored board has the name of the default one, skipping it")
+			continue
+		}
+		sb, err := compileBoard(conf)
+		if err != nil {
+			log.Printf("Figaro: Skipping invalid board %s: %v\n", conf.Name, err)
+			continue
+		}
+		sb.pu = f.pu.sibling()
+		sb.state = newBoard(sb.pu.Epoch())
+		f.servePushes(sb)
+		f.boards[conf.Name] = sb
+	}
+	return nil
+}
+
+// servePushes lets the push service of the board serve snapshots of the
+// channels visible to users.
+func (f *Figaro) servePushes(sb *servedBoard) {
+	sb.pu.SetSnapshot(func(userID string) *Push {
+		return f.snapshot(sb.state, userID)
+	})
+	sb.pu.SetVisible(f.Visible)
+}
+
+// boardChannels loads channels of the board with the last messages.
+func (f *Figaro) boardChannels(sb *servedBoard) ([]*Channel, error) {
+	var channels []*Channel
+	if sb.pattern != "" {
+		matched, err := f.st.GetChannelsByRegex(sb.pattern, sb.conf.MessageLimit)
+		if err != nil {
+			return nil, err
+		}
+		for _, channel := range matched {
+			if sb.matches(channel) {
+				channels = append(channels, channel)
+			}
+		}
+	}
+	loaded := make(map[string]bool)
+	for _, channel := range channels {
+		loaded[channel.ID] = true
+	}
+	for _, id := range sb.conf.Channels {
+		if loaded[id] {
+			continue
+		}
+		channel, err := f.st.GetChannel(id)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if channel.Messages, err = f.st.GetMessagesByChannel(id, sb.conf.MessageLimit); err != nil {
+			return nil, err
+		}
+		if len(channel.Messages) == 0 {
+			continue
+		}
+		loaded[id] = true
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
@@ -0,0 +1,52 @@
+package figaro
+
+import "testing"
+
+func TestBoardMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		board   Board
+		channel Channel
+		want    bool
+	}{
+		{name: "included", board: Board{Include: []string{"^support-"}},
+			channel: Channel{ID: "C1", Name: "support-acme"}, want: true},
+		{name: "any include", board: Board{Include: []string{"^support-", "^help-"}},
+			channel: Channel{ID: "C1", Name: "help-acme"}, want: true},
+		{name: "not included", board: Board{Include: []string{"^support-"}},
+			channel: Channel{ID: "C1", Name: "random"}},
+		{name: "no include", board: Board{},
+			channel: Channel{ID: "C1", Name: "support-acme"}},
+		{name: "excluded",
+			board:   Board{Include: []string{"^support-"}, Exclude: []string{"-old$"}},
+			channel: Channel{ID: "C1", Name: "support-acme-old"}},
+		{name: "listed", board: Board{Channels: []string{"C1"}},
+			channel: Channel{ID: "C1", Name: "random"}, want: true},
+		{name: "listed and excluded",
+			board: Board{Include: []string{"^support-"}, Exclude: []string{"-old$"},
+				Channels: []string{"C1"}},
+			channel: Channel{ID: "C1", Name: "support-acme-old"}, want: true},
+		{name: "team",
+			board:   Board{Include: []string{"^support-"}, Teams: []string{"T1"}},
+			channel: Channel{ID: "C1", Name: "support-acme", TeamID: "T1"}, want: true},
+		{name: "other team",
+			board:   Board{Include: []string{"^support-"}, Teams: []string{"T1"}},
+			channel: Channel{ID: "C1", Name: "support-acme", TeamID: "T2"}},
+		{name: "listed of other team",
+			board:   Board{Teams: []string{"T1"}, Channels: []string{"C1"}},
+			channel: Channel{ID: "C1", Name: "support-acme", TeamID: "T2"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.board.Name = "support"
+			tt.board.MessageLimit = 10
+			sb, err := compileBoard(&tt.board)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sb.matches(&tt.channel); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,406 @@
+package figaro
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoCalendar is returned for calendars which don't exist.
+var ErrNoCalendar = errors.New("calendar not found")
+
+// calendarError tells what's wrong with a calendar.
+type calendarError string
+
+func (e calendarError) Error() string {
+	return string(e)
+}
+
+// maxCalendarDays limits how far calendars look for business hours.
+const maxCalendarDays = 2 * 366
+
+// hoursRe matches business hours like "Mon-Fri,Sun 09:00-17:30"
+var hoursRe = regexp.MustCompile(`^([A-Za-z,-]+)\s+(\d\d):(\d\d)-(\d\d):(\d\d)$`)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// span is a part of a day from and to wall clock times as durations since
+// midnight, see calendar.at.
+type span struct {
+	from, to time.Duration
+}
+
+// calendar is a compiled Calendar. A nil calendar is always open.
+type calendar struct {
+	conf  *Calendar
+	loc   *time.Location
+	hours [7][]span // By time.Weekday
+	// holidays are dates like 2006-01-02, yearly ones are like 01-02
+	holidays map[string]bool
+	yearly   map[string]bool
+}
+
+// compileCalendar validates the calendar and parses its hours and holidays.
+func compileCalendar(conf *Calendar) (*calendar, error) {
+	if !boardNameRe.MatchString(conf.Name) {
+		return nil, calendarError(fmt.Sprintf("invalid calendar name %q, use lowercase letters, digits, - and _", conf.Name))
+	}
+	loc, err := time.LoadLocation(conf.TimeZone)
+	if err != nil {
+		return nil, calendarError(fmt.Sprintf("invalid time zone %q: %v", conf.TimeZone, err))
+	}
+	c := &calendar{
+		conf:     conf,
+		loc:      loc,
+		holidays: make(map[string]bool),
+		yearly:   make(map[string]bool),
+	}
+	if len(conf.Hours) == 0 {
+		for day := range c.hours {
+			c.hours[day] = []span{{0, 24 * time.Hour}}
+		}
+	}
+	for _, hours := range conf.Hours {
+		if err := c.addHours(hours); err != nil {
+			return nil, err
+		}
+	}
+	for day := range c.hours {
+		sort.Slice(c.hours[day], func(i, j int) bool {
+			return c.hours[day][i].from < c.hours[day][j].from
+		})
+	}
+	if err := c.addHolidays(conf.Holidays); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// addHours adds business hours like "Mon-Fri 09:00-17:00".
+func (c *calendar) addHours(hours string) error {
+	m := hoursRe.FindStringSubmatch(strings.TrimSpace(hours))
+	if m == nil {
+		return calendarError(fmt.Sprintf("invalid hours %q, use for example Mon-Fri 09:00-17:00", hours))
+	}
+	var s span
+	for i, d := range []*time.Duration{&s.from, &s.to} {
+		h, _ := strconv.Atoi(m[2+2*i])
+		min, _ := strconv.Atoi(m[3+2*i])
+		if h > 24 || min > 59 || h == 24 && min > 0 {
+			return calendarError(fmt.Sprintf("invalid time in hours %q", hours))
+		}
+		*d = time.Duration(h)*time.Hour + time.Duration(min)*time.Minute
+	}
+	if s.from >= s.to {
+		return calendarError(fmt.Sprintf("hours %q end before they start", hours))
+	}
+	for _, days := range strings.Split(m[1], ",") {
+		bounds := strings.SplitN(days, "-", 2)
+		first, ok := weekdays[strings.ToLower(bounds[0])]
+		if !ok {
+			return calendarError(fmt.Sprintf("invalid weekday %q in hours %q", bounds[0], hours))
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[strings.ToLower(bounds[1])]; !ok {
+				return calendarError(fmt.Sprintf("invalid weekday %q in hours %q", bounds[1], hours))
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			c.hours[day] = append(c.hours[day], s)
+			if day == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// addHolidays adds all-day events of an iCalendar (RFC 5545) as holidays.
+// Events which repeat with RRULE:FREQ=YEARLY are holidays every year.
+func (c *calendar) addHolidays(ical string) error {
+	if strings.TrimSpace(ical) == "" {
+		return nil
+	}
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(ical))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// Long lines are folded with a leading space or tab
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	var start, end string
+	var yearly, inEvent bool
+	for _, line := range lines {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		name, value := strings.ToUpper(line[:i]), line[i+1:]
+		if j := strings.Index(name, ";"); j >= 0 {
+			name = name[:j]
+		}
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			inEvent, start, end, yearly = true, "", "", false
+		case !inEvent:
+		case name == "DTSTART":
+			start = value
+		case name == "DTEND":
+			end = value
+		case name == "RRULE":
+			yearly = strings.Contains(strings.ToUpper(value), "FREQ=YEARLY")
+		case name == "END" && value == "VEVENT":
+			inEvent = false
+			if err := c.addHoliday(start, end, yearly); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addHoliday adds days from start till end, which is excluded, or only the
+// start day if end is empty.
+func (c *calendar) addHoliday(start, end string, yearly bool) error {
+	from, err := parseICalDate(start)
+	if err != nil {
+		return err
+	}
+	to := from.AddDate(0, 0, 1)
+	if end != "" {
+		if to, err = parseICalDate(end); err != nil {
+			return err
+		}
+	}
+	for day, n := from, 0; day.Before(to) && n <= 366; day, n = day.AddDate(0, 0, 1), n+1 {
+		if yearly {
+			c.yearly[day.Format("01-02")] = true
+		} else {
+			c.holidays[day.Format("2006-01-02")] = true
+		}
+	}
+	return nil
+}
+
+// parseICalDate parses the date of DTSTART or DTEND values like 20261225 or
+// 20261225T000000Z.
+func parseICalDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, calendarError(fmt.Sprintf("invalid holiday date %q", value))
+	}
+	t, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return time.Time{}, calendarError(fmt.Sprintf("invalid holiday date %q", value))
+	}
+	return t, nil
+}
+
+// isHoliday tells if the day starting at midnight is a holiday.
+func (c *calendar) isHoliday(day time.Time) bool {
+	return c.holidays[day.Format("2006-01-02")] || c.yearly[day.Format("01-02")]
+}
+
+// dayStart returns the midnight of the day of t in the calendar time zone.
+func (c *calendar) dayStart(t time.Time) time.Time {
+	t = t.In(c.loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.loc)
+}
+
+// at returns the time of the day when the wall clock shows d since midnight.
+// Adding d to midnight is off by an hour on days when clocks change.
+func (c *calendar) at(day time.Time, d time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), int(d/time.Hour),
+		int(d%time.Hour/time.Minute), 0, 0, c.loc)
+}
+
+// openSpans calls fn with business hours of days from the day of t on till
+// fn returns false or maxCalendarDays pass.
+func (c *calendar) openSpans(t time.Time, fn func(from, to time.Time) bool) {
+	day := c.dayStart(t)
+	for n := 0; n < maxCalendarDays; n++ {
+		if !c.isHoliday(day) {
+			for _, s := range c.hours[day.Weekday()] {
+				if !fn(c.at(day, s.from), c.at(day, s.to)) {
+					return
+				}
+			}
+		}
+		day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, c.loc)
+	}
+}
+
+// next returns t if it's in business hours or the start of the next ones.
+// It returns t if the calendar has no business hours.
+func (c *calendar) next(t time.Time) time.Time {
+	if c == nil {
+		return t
+	}
+	next := t
+	c.openSpans(t, func(from, to time.Time) bool {
+		if !to.After(t) {
+			return true
+		}
+		if from.After(t) {
+			next = from
+		}
+		return false
+	})
+	return next
+}
+
+// elapsed returns business time between from and to.
+func (c *calendar) elapsed(from, to time.Time) time.Duration {
+	if c == nil {
+		return to.Sub(from)
+	}
+	var d time.Duration
+	c.openSpans(from, func(start, end time.Time) bool {
+		if !start.Before(to) {
+			return false
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			d += end.Sub(start)
+		}
+		return true
+	})
+	return d
+}
+
+// Calendars returns all calendars sorted by name.
+func (f *Figaro) Calendars() []*Calendar {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	calendars := make([]*Calendar, 0, len(f.calendars))
+	for _, c := range f.calendars {
+		calendars = append(calendars, c.conf)
+	}
+	sort.Slice(calendars, func(i, j int) bool {
+		return calendars[i].Name < calendars[j].Name
+	})
+	return calendars
+}
+
+// Calendar returns the calendar by its name or ErrNoCalendar.
+func (f *Figaro) Calendar(name string) (*Calendar, error) {
+	c := f.calendar(name)
+	if c == nil {
+		return nil, ErrNoCalendar
+	}
+	return c.conf, nil
+}
+
+func (f *Figaro) calendar(name string) *calendar {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.calendars[name]
+}
+
+// calendarOf returns the calendar of the channel on the board: the one of
+// the channel, or of the board if the channel has none. sb may be nil.
+func (f *Figaro) calendarOf(sb *servedBoard, channel *Channel) *calendar {
+	name := channel.Calendar
+	if name == "" && sb != nil {
+		name = sb.conf.Calendar
+	}
+	if name == "" {
+		return nil
+	}
+	return f.calendar(name)
+}
+
+// PutCalendar creates the calendar or replaces the one with the same name.
+// It returns when boards use it.
+func (f *Figaro) PutCalendar(conf *Calendar) error {
+	c, err := compileCalendar(conf)
+	if err != nil {
+		return err
+	}
+	if err := f.st.UpdateCalendar(conf); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.calendars[conf.Name] = c
+	f.mu.Unlock()
+	log.Println("Figaro: Calendar updated:", conf.Name)
+	f.waitBoards()
+	return nil
+}
+
+// DeleteCalendar deletes the calendar. Channels and boards which use it are
+// always open then.
+func (f *Figaro) DeleteCalendar(name string) error {
+	if f.calendar(name) == nil {
+		return ErrNoCalendar
+	}
+	if err := f.st.DeleteCalendar(name); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	delete(f.calendars, name)
+	f.mu.Unlock()
+	log.Println("Figaro: Calendar deleted:", name)
+	f.waitBoards()
+	return nil
+}
+
+// UpdateChannelCalendar attaches the calendar to the channel, the empty
+// name detaches it. It returns when the board is updated.
+func (f *Figaro) UpdateChannelCalendar(id, name string) error {
+	if name != "" && f.calendar(name) == nil {
+		return ErrNoCalendar
+	}
+	if err := f.st.UpdateChannelCalendar(id, name); err != nil {
+		return err
+	}
+	f.waitBoardChannel(id)
+	return nil
+}
+
+// loadCalendars loads the calendars kept in the storage.
+func (f *Figaro) loadCalendars() error {
+	calendars, err := f.st.GetCalendars()
+	if err != nil {
+		return err
+	}
+	for _, conf := range calendars {
+		c, err := compileCalendar(conf)
+		if err != nil {
+			log.Printf("Figaro: Skipping invalid calendar %s: %v\n", conf.Name, err)
+			continue
+		}
+		f.calendars[conf.Name] = c
+	}
+	return nil
+}
+
+// waitBoards asks the serve goroutine to update all boards and waits for it.
+func (f *Figaro) waitBoards() {
+	done := make(chan struct{})
+	f.updateCh <- done
+	<-done
+}
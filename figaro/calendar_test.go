@@ -0,0 +1,49 @@
+package figaro
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalendarClockChanges(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	office, err := compileCalendar(&Calendar{Name: "office",
+		TimeZone: "Europe/Berlin", Hours: []string{"Mon-Sun 09:00-17:00"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	always, err := compileCalendar(&Calendar{Name: "always", TimeZone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2026, month, day, hour, 0, 0, 0, berlin)
+	}
+	// Clocks go forward on March 29 and back on October 25 in 2026
+	tests := []struct {
+		name        string
+		cal         *calendar
+		from, to    time.Time
+		wantNext    time.Time // next of from
+		wantElapsed time.Duration
+	}{
+		{"spring forward", office, at(3, 29, 8), at(3, 29, 18), at(3, 29, 9), 8 * time.Hour},
+		{"fall back", office, at(10, 25, 8), at(10, 25, 18), at(10, 25, 9), 8 * time.Hour},
+		{"day before spring forward", office, at(3, 28, 8), at(3, 28, 18), at(3, 28, 9), 8 * time.Hour},
+		{"short day", always, at(3, 29, 0), at(3, 30, 0), at(3, 29, 0), 23 * time.Hour},
+		{"long day", always, at(10, 25, 0), at(10, 26, 0), at(10, 25, 0), 25 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if next := tt.cal.next(tt.from); !next.Equal(tt.wantNext) {
+				t.Errorf("next: got %v, want %v", next, tt.wantNext)
+			}
+			if elapsed := tt.cal.elapsed(tt.from, tt.to); elapsed != tt.wantElapsed {
+				t.Errorf("elapsed: got %v, want %v", elapsed, tt.wantElapsed)
+			}
+		})
+	}
+}
@@ -2,26 +2,112 @@ package main
 
 import (
 	"log"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/adyatlov/figaro/figaro"
 	"github.com/kelseyhightower/envconfig"
 )
 
 type configuration struct {
-	Dbaddr      string `desc:"DB connection string" required:"true"`
-	Wsaddr      string `desc:"web socket service address" default:"localhost:8080"`
-	Slacktoken  string `desc:"slack token" required:"true"`
-	Slackteam   string `desc:"slack team" required:"true"`
-	Domains     string `desc:"comma-separated organization domains" required:"true"`
-	Delay       uint   `desc:"delay between db updates in seconds" default:"30"`
-	Nmessages   uint   `desc:"max number of last messages to show" default:"3"`
-	Ncharacters uint   `desc:"max number of first characters to show for each message" default:"256"`
-	Pattern     string `desc:"channel name regex pattern" default:".*"`
+	Dbaddr            string `desc:"DB connection URL, postgres://... or sqlite:///path/to/figaro.db" required:"true"`
+	Wsaddr            string `desc:"web socket service address" default:"localhost:8080"`
+	Slacktoken        string `desc:"comma-separated slack tokens, one per workspace" required:"true"`
+	Slackapiurl       string `desc:"slack Web API base URL, for example of a local stand-in" default:""`
+	Slackmode         string `desc:"how to receive slack messages: events (Events API) or rtm (legacy RTM), events if a signing secret is set and rtm otherwise"`
+	Slacksecret       string `desc:"slack signing secret, required for Events API, or comma-separated secrets in the order of tokens"`
+	Slacktypes        string `desc:"comma-separated conversation types: public_channel, private_channel, mpim, im" default:"public_channel,private_channel"`
+	Domains           string `desc:"comma-separated organization domains" required:"true"`
+	Internalteams     string `desc:"comma-separated IDs of workspaces whose full members are internal, the workspaces of the tokens by default"`
+	Allowusers        string `desc:"comma-separated IDs of users who are always internal"`
+	Denyusers         string `desc:"comma-separated IDs of users who are never internal"`
+	Admins            string `desc:"comma-separated IDs of users who may change boards, calendars and SLAs and manage webhooks"`
+	Sla               uint   `desc:"seconds guests may wait for the first response, 0 for no limit; channels may have their own" default:"3600"`
+	Ackreactions      string `desc:"comma-separated reactions which acknowledge the last message" default:"eyes,white_check_mark"`
+	Delay             uint   `desc:"delay between db updates in seconds" default:"30"`
+	Nmessages         uint   `desc:"max number of last messages to show" default:"3"`
+	Ncharacters       uint   `desc:"max number of first characters to show for each message" default:"256"`
+	Pattern           string `desc:"channel name regex pattern" default:".*"`
+	Origins           string `desc:"comma-separated origins allowed to open WebSockets besides the same origin, for example https://figaro.example.com"`
+	Noauth            bool   `desc:"serve the board and API without signing in, only for development" default:"false"`
+	Slackclientid     string `desc:"client ID of the Slack app for Sign in with Slack"`
+	Slackclientsecret string `desc:"client secret of the Slack app for Sign in with Slack"`
+	Slackauthurl      string `desc:"Sign in with Slack authorization URL, for example of a local stand-in" default:"https://slack.com/openid/connect/authorize"`
+	Authredirecturl   string `desc:"URL of /auth/callback registered in the Slack app, for example https://figaro.example.com/auth/callback"`
+	Homeurl           string `desc:"where users go after signing in" default:"/"`
+	Sessionkey        string `desc:"secret of at least 32 characters which signs session cookies"`
+	Alertafter        uint   `desc:"seconds a question may wait in business hours before alerts, 0 for the SLA of the channel" default:"0"`
+	Alertrepeat       uint   `desc:"seconds between repeated alerts while a question waits, 0 to alert once" default:"3600"`
+	Alertquiet        string `desc:"hours when alerts are held, for example 22:00-07:00"`
+	Alerttz           string `desc:"time zone of the quiet hours, for example Europe/Berlin" default:"UTC"`
+	Alertslack        string `desc:"Slack channel or user ID to post alerts to with the first token"`
+	Alertwebhook      string `desc:"URL to post alerts to as JSON"`
+	Alertsmtpaddr     string `desc:"host:port of the SMTP server to email alerts with"`
+	Alertsmtpfrom     string `desc:"sender of alert emails"`
+	Alertsmtpto       string `desc:"comma-separated recipients of alert emails"`
+	Alertsmtpuser     string `desc:"SMTP user name, no authentication if empty"`
+	Alertsmtppassword string `desc:"SMTP password"`
+}
+
+// splitList splits a comma-separated list and drops empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// startAlerts starts alerts if a sink is configured.
+func startAlerts(f *figaro.Figaro, conf *configuration, slackToken string) error {
+	var sinks []figaro.AlertSink
+	if conf.Alertslack != "" {
+		sinks = append(sinks, figaro.NewSlackSink(slackToken, conf.Slackapiurl, conf.Alertslack))
+	}
+	if conf.Alertwebhook != "" {
+		sinks = append(sinks, figaro.NewWebhookSink(conf.Alertwebhook))
+	}
+	if conf.Alertsmtpaddr != "" {
+		sinks = append(sinks, figaro.NewSMTPSink(figaro.SMTPConfig{
+			Addr:     conf.Alertsmtpaddr,
+			From:     conf.Alertsmtpfrom,
+			To:       splitList(conf.Alertsmtpto),
+			Username: conf.Alertsmtpuser,
+			Password: conf.Alertsmtppassword,
+		}))
+	}
+	if len(sinks) == 0 {
+		log.Println("Alerts are off, no alert sinks configured")
+		return nil
+	}
+	loc, err := time.LoadLocation(conf.Alerttz)
+	if err != nil {
+		return err
+	}
+	return f.StartAlerts(figaro.AlertConfig{
+		After:    time.Duration(conf.Alertafter) * time.Second,
+		Repeat:   time.Duration(conf.Alertrepeat) * time.Second,
+		Quiet:    conf.Alertquiet,
+		Location: loc,
+		Sinks:    sinks,
+	})
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			migrateMain(os.Args[2:])
+			return
+		case "report":
+			reportMain(os.Args[2:])
+			return
+		}
+	}
 	log.Println("Starting Figaro")
 	var conf configuration
 	if err := envconfig.Process("FIGARO", &conf); err != nil {
@@ -29,26 +115,108 @@ func main() {
 		envconfig.Usage("FIGARO", &conf)
 		os.Exit(1)
 	}
-	domains := strings.Split(conf.Domains, ",")
-	for i, domain := range domains {
-		domains[i] = strings.TrimSpace(domain)
-	}
+	domains := splitList(conf.Domains)
 	log.Println("Domains:", domains)
+	types := splitList(conf.Slacktypes)
+	ackReactions := splitList(conf.Ackreactions)
+	var auth *figaro.Auth
+	if conf.Noauth {
+		log.Println("WARNING: the board and API are served without signing in")
+	} else {
+		var err error
+		auth, err = figaro.NewAuth(figaro.AuthConfig{
+			ClientID:     conf.Slackclientid,
+			ClientSecret: conf.Slackclientsecret,
+			RedirectURL:  conf.Authredirecturl,
+			HomeURL:      conf.Homeurl,
+			SessionKey:   conf.Sessionkey,
+			Domains:      domains,
+			AuthorizeURL: conf.Slackauthurl,
+			APIURL:       conf.Slackapiurl,
+		})
+		if err != nil {
+			log.Fatalln("Cannot create Auth service:", err)
+		}
+	}
 	st, err := figaro.NewStorage(conf.Dbaddr)
 	if err != nil {
 		log.Fatalln("Cannot create Storage service", err)
 	}
 	defer st.Close()
-	sl := figaro.NewSlack(conf.Slacktoken)
-	if err != nil {
-		log.Fatalln("Cannot create Slack service", err)
+	tokens := splitList(conf.Slacktoken)
+	secrets := splitList(conf.Slacksecret)
+	if len(tokens) == 0 {
+		log.Fatalln("No Slack tokens")
+	}
+	if len(secrets) > 1 && len(secrets) != len(tokens) {
+		log.Fatalln("Number of Slack signing secrets doesn't match number of tokens")
+	}
+	// Tokens without signing secrets keep working with RTM
+	mode := conf.Slackmode
+	if mode == "" {
+		mode = figaro.SlackModeRTM
+		if conf.Slacksecret != "" {
+			mode = figaro.SlackModeEvents
+		}
+	}
+	log.Println("Slack mode:", mode)
+	// Every workspace is ingested by its own Slack
+	var slacks []*figaro.Slack
+	var sources []figaro.Source
+	for i, token := range tokens {
+		secret := conf.Slacksecret
+		if len(secrets) > 1 {
+			secret = secrets[i]
+		}
+		sl, err := figaro.NewSlack(figaro.SlackConfig{
+			Token:         token,
+			APIURL:        conf.Slackapiurl,
+			Mode:          mode,
+			SigningSecret: secret,
+			Types:         types,
+		})
+		if err != nil {
+			log.Fatalln("Cannot create Slack service", err)
+		}
+		slacks = append(slacks, sl)
+		sources = append(sources, sl)
 	}
-	pu := figaro.NewPushService()
-	f, err := figaro.NewFigaro(sl, st, pu, conf.Pattern, conf.Nmessages, domains)
+	var source figaro.Source = slacks[0]
+	if len(sources) > 1 {
+		source = figaro.NewMultiSource(sources...)
+	}
+	rolesConf := figaro.RoleConfig{
+		Teams:  splitList(conf.Internalteams),
+		Allow:  splitList(conf.Allowusers),
+		Deny:   splitList(conf.Denyusers),
+		Admins: splitList(conf.Admins),
+	}
+	if len(rolesConf.Teams) == 0 {
+		for _, sl := range slacks {
+			rolesConf.Teams = append(rolesConf.Teams, sl.TeamID())
+		}
+	}
+	log.Println("Internal workspaces:", rolesConf.Teams)
+	pu := figaro.NewPushService(splitList(conf.Origins))
+	f, err := figaro.NewFigaro(source, st, pu, conf.Pattern, conf.Nmessages, domains,
+		ackReactions, rolesConf, time.Duration(conf.Sla)*time.Second)
 	if err != nil {
-		log.Println("Cannot create Figaro service:", err)
+		log.Fatalln("Cannot create Figaro service:", err)
 	}
 	defer f.Close()
-	wait := make(chan struct{})
-	<-wait
+	if err := startAlerts(f, &conf, tokens[0]); err != nil {
+		log.Fatalln("Cannot start alerts:", err)
+	}
+	mux := http.NewServeMux()
+	if auth != nil {
+		mux.Handle("/", auth.Require(f.Handler()))
+		mux.Handle("/auth/", auth.Handler())
+	} else {
+		mux.Handle("/", f.Handler())
+	}
+	mux.HandleFunc("/slack/events", figaro.EventsRouter(slacks...))
+	log.Println("Listening on", conf.Wsaddr)
+	if err := http.ListenAndServe(conf.Wsaddr, mux); err != nil {
+		log.Fatalln("Cannot serve HTTP:", err)
+	}
 }
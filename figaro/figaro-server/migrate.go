@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/adyatlov/figaro/figaro"
+	"github.com/kelseyhightower/envconfig"
+)
+
+type migrateConfiguration struct {
+	Dbaddr string `desc:"DB connection URL, postgres://... or sqlite:///path/to/figaro.db" required:"true"`
+}
+
+// migrateMain runs "figaro-server migrate [version]". It migrates the schema
+// up or down to the version, to the latest one by default.
+func migrateMain(args []string) {
+	var conf migrateConfiguration
+	if err := envconfig.Process("FIGARO", &conf); err != nil {
+		log.Println(err.Error())
+		envconfig.Usage("FIGARO", &conf)
+		os.Exit(1)
+	}
+	version := figaro.LatestSchemaVersion()
+	switch len(args) {
+	case 0:
+	case 1:
+		v, err := strconv.Atoi(args[0])
+		if err != nil {
+			log.Fatalln("Invalid schema version:", args[0])
+		}
+		version = v
+	default:
+		log.Fatalln("Usage: figaro-server migrate [version]")
+	}
+	from, err := figaro.MigrateStorage(conf.Dbaddr, version)
+	if err != nil {
+		log.Fatalln("Cannot migrate schema:", err)
+	}
+	log.Printf("Migrated schema from version %d to %d\n", from, version)
+}
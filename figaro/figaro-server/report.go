@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/url"
+	"os"
+
+	"github.com/adyatlov/figaro/figaro"
+	"github.com/kelseyhightower/envconfig"
+)
+
+type reportConfiguration struct {
+	Dbaddr    string `desc:"DB connection URL, postgres://... or sqlite:///path/to/figaro.db" required:"true"`
+	Domains   string `desc:"comma-separated organization domains" required:"true"`
+	Denyusers string `desc:"comma-separated IDs of users who are never internal"`
+}
+
+// reportMain runs "figaro-server report [flags]". It writes a report of
+// response times and volumes per channel and day or week to stdout.
+func reportMain(args []string) {
+	var conf reportConfiguration
+	if err := envconfig.Process("FIGARO", &conf); err != nil {
+		log.Println(err.Error())
+		envconfig.Usage("FIGARO", &conf)
+		os.Exit(1)
+	}
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	board := flags.String("board", "", "board whose domains tell guests, the default one by default")
+	channel := flags.String("channel", "", "channel ID, all channels by default")
+	from := flags.String("from", "", "the start, 2006-01-02 or RFC 3339, 30 days before -to by default")
+	to := flags.String("to", "", "the end, 2006-01-02 or RFC 3339, now by default")
+	period := flags.String("period", figaro.ReportDay, "day or week")
+	tz := flags.String("tz", "", "time zone of days, for example Europe/Berlin, UTC by default")
+	calendar := flags.String("calendar", "", "business hours calendar of channels which have none")
+	format := flags.String("format", "csv", "csv or json")
+	flags.Parse(args)
+	if *format != "csv" && *format != "json" {
+		log.Fatalln("Invalid format, use csv or json:", *format)
+	}
+	values := url.Values{}
+	for name, value := range map[string]string{
+		"board":    *board,
+		"channel":  *channel,
+		"from":     *from,
+		"to":       *to,
+		"period":   *period,
+		"tz":       *tz,
+		"calendar": *calendar,
+	} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	q, err := figaro.ParseReportQuery(values)
+	if err != nil {
+		log.Fatalln("Invalid report:", err)
+	}
+	// Reports only read, so the schema isn't migrated
+	st, err := figaro.OpenStorage(conf.Dbaddr)
+	if err != nil {
+		log.Fatalln("Cannot open Storage:", err)
+	}
+	defer st.Close()
+	reporter := figaro.NewReporter(st, splitList(conf.Domains), figaro.RoleConfig{
+		Deny: splitList(conf.Denyusers),
+	})
+	report, err := reporter.Report(q)
+	if err != nil {
+		log.Fatalln("Cannot make report:", err)
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = figaro.WriteReportCSV(os.Stdout, report)
+	}
+	if err != nil {
+		log.Fatalln("Cannot write report:", err)
+	}
+}
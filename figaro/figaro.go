@@ -1,46 +1,137 @@
 package figaro
 
 import (
-	"bytes"
+	"database/sql"
 	"encoding/json"
 	"log"
+	"net/http"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	// statusQueueSize is a number of channel status changes waiting to
+	// update the board.
+	statusQueueSize = 16
+	// breachCheckInterval is how often channels are checked for questions
+	// which have just breached their SLA.
+	breachCheckInterval = time.Minute
+)
+
 // Figaro is a main component. It
 // * Updates the storage with data from slack.
+// * Keeps boards of channels and pushes their changes to clients.
 // * Exposes data from storage to clients via HTTP and WebSocket.
 type Figaro struct {
-	sl                   *Slack
-	st                   *Storage
-	pu                   *PushService
-	channelPattern       string
-	messageLimit         uint
-	domains              []string
-	lastChannelPairBytes []byte
+	sl Source
+	st Store
+	// pu serves the default board
+	pu           *PushService
+	messageLimit uint
+	domains      []string
+	roles        *roles
+	sla          time.Duration
+	reporter     *Reporter
+	ackReactions map[string]bool
+	members      *members
+	statusCh     chan statusChange
+	boardCh      chan *boardChange
+	// updateCh asks to update all boards, the channel is closed when they
+	// are updated.
+	updateCh chan chan struct{}
+	// deliverCh wakes the goroutine which delivers webhook events.
+	deliverCh     chan struct{}
+	webhookClient *http.Client
+
+	mu        sync.RWMutex
+	boards    map[string]*servedBoard
+	calendars map[string]*calendar
+	webhooks  map[string]*Webhook
+}
+
+// statusChange asks to update the board after a channel status change.
+// done is closed when the board is updated.
+type statusChange struct {
+	id   string
+	done chan struct{}
 }
 
 // NewFigaro creates main component.
 // It updates data from Slack to the storage. It returns error if it fails
-// to update.
-func NewFigaro(sl *Slack, st *Storage, pu *PushService, channelPattern string,
-	messageLimit uint, domains []string) (*Figaro, error) {
+// to update. The default board shows channels matching channelPattern with
+// messageLimit last messages, users with emails in domains are internal.
+// Other boards are loaded from the storage. ackReactions are names of
+// reactions which acknowledge the last message of a channel when an internal
+// user adds them. rolesConf tells which other users are internal. sla is how
+// long guests may wait for the first response in channels without their own
+// SLA, 0 for no limit.
+func NewFigaro(sl Source, st Store, pu *PushService, channelPattern string,
+	messageLimit uint, domains []string, ackReactions []string,
+	rolesConf RoleConfig, sla time.Duration) (*Figaro, error) {
 	log.Println("Figaro: starting Figaro...")
+	defaultBoard, err := compileBoard(&Board{
+		Name:         DefaultBoard,
+		Include:      []string{channelPattern},
+		MessageLimit: messageLimit,
+		Domains:      domains,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defaultBoard.pu = pu
+	defaultBoard.state = newBoard(pu.Epoch())
 	f := &Figaro{
-		sl:             sl,
-		st:             st,
-		pu:             pu,
-		channelPattern: channelPattern,
-		messageLimit:   messageLimit,
-		domains:        domains,
+		sl:            sl,
+		st:            st,
+		pu:            pu,
+		messageLimit:  messageLimit,
+		domains:       domains,
+		roles:         newRoles(rolesConf, domains),
+		sla:           sla,
+		ackReactions:  make(map[string]bool),
+		members:       newMembers(),
+		statusCh:      make(chan statusChange, statusQueueSize),
+		boardCh:       make(chan *boardChange),
+		updateCh:      make(chan chan struct{}),
+		boards:        map[string]*servedBoard{DefaultBoard: defaultBoard},
+		calendars:     make(map[string]*calendar),
+		webhooks:      make(map[string]*Webhook),
+		deliverCh:     make(chan struct{}, 1),
+		webhookClient: &http.Client{Timeout: webhookTimeout},
+	}
+	for _, name := range ackReactions {
+		f.ackReactions[reactionName(name)] = true
+	}
+	f.reporter = &Reporter{st: st, domains: domains, roles: f.roles}
+	if err := f.loadCalendars(); err != nil {
+		log.Println("Figaro: Cannot load calendars:", err)
+		return nil, err
+	}
+	if err := f.loadBoards(); err != nil {
+		log.Println("Figaro: Cannot load boards:", err)
+		return nil, err
 	}
 	if err := f.updateStorage(); err != nil {
 		log.Println("Figaro: Cannot update Storage during startup:", err)
 		return nil, err
 	}
+	if err := f.backfillResponses(); err != nil {
+		log.Println("Figaro: Cannot backfill responses during startup:", err)
+		return nil, err
+	}
+	if err := f.updateBoards(); err != nil {
+		log.Println("Figaro: Cannot update board during startup:", err)
+		return nil, err
+	}
+	// Webhooks get changes after the startup only
+	if err := f.loadWebhooks(); err != nil {
+		log.Println("Figaro: Cannot load webhooks:", err)
+		return nil, err
+	}
+	go f.deliverWebhooks()
+	f.servePushes(defaultBoard)
 	go f.serve()
 	log.Println("Figaro: Figaro started.")
 	return f, nil
@@ -48,69 +139,291 @@ func NewFigaro(sl *Slack, st *Storage, pu *PushService, channelPattern string,
 
 func (f *Figaro) serve() {
 	tickCh := time.Tick(time.Hour)
+	breachCh := time.Tick(breachCheckInterval)
 	for {
 		select {
+		case <-breachCh:
+			f.updateBreaches()
 		case <-tickCh:
 			if err := f.updateStorage(); err != nil {
 				log.Println("Figaro: Cannot update Storage during periodical update:", err)
 			}
+			if err := f.updateBoards(); err != nil {
+				log.Println("Figaro: Cannot update board:", err)
+			}
 		case msg := <-f.sl.MessageCh():
-			f.processMessages([]*Message{msg})
+			switch msg.Type {
+			case "member_joined_channel", "member_left_channel":
+				f.updateMembership(msg)
+			default:
+				f.processMessages([]*Message{msg})
+				f.updateBoardChannel(msg.ChannelID)
+			}
+		case change := <-f.statusCh:
+			f.updateBoardChannel(change.id)
+			close(change.done)
+		case change := <-f.boardCh:
+			f.applyBoardChange(change)
+		case done := <-f.updateCh:
+			if err := f.updateBoards(); err != nil {
+				log.Println("Figaro: Cannot update board:", err)
+			}
+			close(done)
+		}
+	}
+}
+
+// updateBoards updates all boards.
+func (f *Figaro) updateBoards() error {
+	for _, sb := range f.servedBoards() {
+		if err := f.updateBoard(sb); err != nil {
+			return err
 		}
-		f.notifyUsers()
 	}
+	return nil
+}
+
+// updateBoard loads all channels of the board and pushes the ones which
+// changed since the last update.
+func (f *Figaro) updateBoard(sb *servedBoard) error {
+	channels, err := f.boardChannels(sb)
+	if err != nil {
+		return err
+	}
+	if err := f.setWaiting(sb, channels); err != nil {
+		return err
+	}
+	halves, err := f.halves(channels, sb.conf.Domains)
+	if err != nil {
+		return err
+	}
+	ids := make(map[string]bool)
+	now := time.Now()
+	for _, channel := range channels {
+		ids[channel.ID] = true
+		f.setClock(channel, f.calendarOf(sb, channel), now)
+		f.setBoardChannel(sb, channel, halves[channel.ID])
+	}
+	for _, id := range sb.state.ids() {
+		if !ids[id] {
+			f.removeBoardChannel(sb, id)
+		}
+	}
+	return nil
+}
+
+// updateBoardChannel loads a single channel and pushes it to the boards it
+// changed on.
+func (f *Figaro) updateBoardChannel(id string) {
+	if id == "" {
+		return
+	}
+	boards := f.servedBoards()
+	channel, err := f.st.GetChannel(id)
+	if err == sql.ErrNoRows {
+		for _, sb := range boards {
+			f.removeBoardChannel(sb, id)
+		}
+		return
+	}
+	if err != nil {
+		log.Println("Figaro: Cannot get channel:", err)
+		return
+	}
+	var limit uint
+	for _, sb := range boards {
+		if sb.conf.MessageLimit > limit {
+			limit = sb.conf.MessageLimit
+		}
+	}
+	messages, err := f.st.GetMessagesByChannel(id, limit)
+	if err != nil {
+		log.Println("Figaro: Cannot get messages:", err)
+		return
+	}
+	for _, sb := range boards {
+		if !sb.matches(channel) || len(messages) == 0 {
+			f.removeBoardChannel(sb, id)
+			continue
+		}
+		// Boards keep their own copies with their number of messages
+		boardChannel := *channel
+		boardChannel.Messages = messages
+		if uint(len(messages)) > sb.conf.MessageLimit {
+			boardChannel.Messages = messages[:sb.conf.MessageLimit]
+		}
+		if err := f.setWaiting(sb, []*Channel{&boardChannel}); err != nil {
+			log.Println("Figaro: Cannot get responses:", err)
+			return
+		}
+		halves, err := f.halves([]*Channel{&boardChannel}, sb.conf.Domains)
+		if err != nil {
+			log.Println("Figaro: Cannot get users:", err)
+			return
+		}
+		f.setClock(&boardChannel, f.calendarOf(sb, &boardChannel), time.Now())
+		f.setBoardChannel(sb, &boardChannel, halves[id])
+	}
+}
+
+func (f *Figaro) setBoardChannel(sb *servedBoard, channel *Channel, half string) {
+	old, oldHalf := sb.state.channel(channel.ID)
+	if event := sb.state.set(channel, half); event != nil {
+		f.push(sb, event)
+	}
+	f.emitChange(sb, old, oldHalf, channel, half)
+}
+
+func (f *Figaro) removeBoardChannel(sb *servedBoard, id string) {
+	old, oldHalf := sb.state.channel(id)
+	if event := sb.state.remove(id); event != nil {
+		f.push(sb, event)
+	}
+	f.emitChange(sb, old, oldHalf, nil, "")
+}
+
+// push pushes the event to clients of the board whose users see its
+// channel.
+func (f *Figaro) push(sb *servedBoard, event *BoardEvent) {
+	f.pushTo(sb, "", event)
+}
+
+// pushTo pushes the event only to the user if userID is not empty.
+func (f *Figaro) pushTo(sb *servedBoard, userID string, event *BoardEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Fatalln("Figaro: Cannot marshal board event:", err)
+	}
+	chID := event.ID
+	if event.Channel != nil {
+		chID = event.Channel.ID
+	}
+	sb.pu.In() <- &Push{Seq: event.Seq, ChannelID: chID, UserID: userID, Data: data}
 }
 
-func (f *Figaro) notifyUsers() {
-	channels, err := f.st.GetChannelsByRegex(f.channelPattern, f.messageLimit)
+// snapshot returns the snapshot event of the board for a newly connected
+// client of the user.
+func (f *Figaro) snapshot(state *board, userID string) *Push {
+	event := state.snapshot(func(chID string) bool {
+		return f.Visible(userID, chID)
+	})
+	data, err := json.Marshal(event)
 	if err != nil {
-		log.Println("Figaro: Cannot notify users:", err)
+		log.Fatalln("Figaro: Cannot marshal board:", err)
+	}
+	return &Push{Seq: event.Seq, Data: data}
+}
+
+// ChannelPair returns channels of the default board which the user sees
+// split into bad and good ones. Channels in both halves are sorted by the
+// last message time.
+func (f *Figaro) ChannelPair(userID string) (*ChannelPair, error) {
+	return f.BoardChannelPair(DefaultBoard, userID)
+}
+
+// BoardChannelPair returns channels of the board which the user sees split
+// into bad and good ones or ErrNoBoard.
+func (f *Figaro) BoardChannelPair(name, userID string) (*ChannelPair, error) {
+	sb := f.servedBoard(name)
+	if sb == nil {
+		return nil, ErrNoBoard
 	}
+	return sb.state.pair(func(chID string) bool {
+		return f.Visible(userID, chID)
+	}), nil
+}
+
+// halves returns the half of the board for every channel by its ID. Users
+// with emails in domains are internal as well. Channels must have messages.
+func (f *Figaro) halves(channels []*Channel, domains []string) (map[string]string, error) {
 	ids := make([]string, 0, len(channels))
 	for _, channel := range channels {
 		ids = append(ids, channel.Messages[0].UserID)
+		for _, r := range channel.Messages[0].Reactions {
+			ids = append(ids, r.UserIDs...)
+		}
 	}
 	users, err := f.st.GetUsers(ids)
 	if err != nil {
-		log.Println("Figaro: Cannot notify users:", err)
+		return nil, err
 	}
-	idToEmail := make(map[string]string)
+	idToUser := make(map[string]*User)
 	for _, user := range users {
-		idToEmail[user.ID] = user.Email
+		idToUser[user.ID] = user
 	}
-	channelPair := ChannelPair{}
+	halves := make(map[string]string)
 	for _, channel := range channels {
-		id := channel.Messages[0].UserID
-		email := idToEmail[id]
-		if isInDomains(email, f.domains) {
-			channelPair.Ok = append(channelPair.Ok, channel)
+		author := idToUser[channel.Messages[0].UserID]
+		if channel.Ok || f.roles.isInternal(author, domains) ||
+			f.isAcknowledged(channel.Messages[0], idToUser, domains) {
+			halves[channel.ID] = HalfOk
 		} else {
-			channelPair.Bad = append(channelPair.Bad, channel)
+			halves[channel.ID] = HalfBad
 		}
 	}
-	sortChannelsByLastMessageTime(channelPair.Ok)
-	sortChannelsByLastMessageTime(channelPair.Bad)
-	channelPairBytes, err := json.Marshal(channelPair)
-	if err != nil {
-		log.Fatalln("Figaro: Cannot marshal channel pair:", err)
-	}
-	if !bytes.Equal(channelPairBytes, f.lastChannelPairBytes) {
-		f.lastChannelPairBytes = channelPairBytes
-		f.pu.In() <- channelPairBytes
+	return halves, nil
+}
+
+// UpdateChannelStatus marks a channel as OK or not OK and notifies users.
+// It returns when the board is updated.
+func (f *Figaro) UpdateChannelStatus(id string, ok bool) error {
+	if err := f.st.UpdateChannelStatus(id, ok); err != nil {
+		return err
 	}
+	f.waitBoardChannel(id)
+	return nil
+}
+
+// waitBoardChannel asks the serve goroutine to update the channel on the
+// boards and waits for it.
+func (f *Figaro) waitBoardChannel(id string) {
+	change := statusChange{id: id, done: make(chan struct{})}
+	f.statusCh <- change
+	<-change.done
 }
 
-func isInDomains(email string, domains []string) bool {
-	for _, domain := range domains {
-		if strings.HasSuffix(email, "@"+domain) {
-			return true
+// isAcknowledged returns true if an internal user reacted to the message
+// with one of the acknowledgement reactions.
+func (f *Figaro) isAcknowledged(m *Message, idToUser map[string]*User,
+	domains []string) bool {
+	for _, r := range m.Reactions {
+		if !f.ackReactions[reactionName(r.Name)] {
+			continue
+		}
+		for _, id := range r.UserIDs {
+			if f.roles.isInternal(idToUser[id], domains) {
+				return true
+			}
 		}
 	}
 	return false
 }
 
+// reactionName strips colons and skin tone modifiers from a reaction name,
+// so that ":+1::skin-tone-2:" becomes "+1".
+func reactionName(name string) string {
+	name = strings.Trim(name, ":")
+	if i := strings.Index(name, "::skin-tone-"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// sortChannelsByLastMessageTime sorts channels by the time their last
+// messages started to wait in business hours, then by the time of the last
+// messages.
 func sortChannelsByLastMessageTime(channels []*Channel) {
+	waitStart := func(channel *Channel) time.Time {
+		if channel.WaitStart.IsZero() {
+			return channel.Messages[0].CreatedAt
+		}
+		return channel.WaitStart
+	}
 	sort.Slice(channels, func(i, j int) bool {
+		wi, wj := waitStart(channels[i]), waitStart(channels[j])
+		if !wi.Equal(wj) {
+			return wi.Before(wj)
+		}
 		return channels[i].Messages[0].CreatedAt.UnixNano() <
 			channels[j].Messages[0].CreatedAt.UnixNano()
 	})
@@ -130,6 +443,10 @@ func (f *Figaro) updateStorage() error {
 		log.Println("Figaro: Error occurred when update messages:", err)
 		return err
 	}
+	if err := f.updateMembers(f.servedBoards()); err != nil {
+		log.Println("Figaro: Error occurred when update members:", err)
+		return err
+	}
 	log.Println("Figaro: Storage updated.")
 	return nil
 }
@@ -141,7 +458,9 @@ func (f *Figaro) updateUsers() error {
 		log.Println("Figaro: Cannot get users from Slack:", err)
 		return err
 	}
-
+	for _, user := range users {
+		user.Role = f.roles.classify(user)
+	}
 	err = f.st.UpdateUsers(users)
 	if err != nil {
 		log.Println("Figaro: Cannot update users in Storage:", err)
@@ -170,30 +489,119 @@ func (f *Figaro) updateChannels() error {
 func (f *Figaro) processMessages(messages []*Message) error {
 	// Collect text messages here to update them all in once
 	txtMessages := make([]*Message, 0, len(messages))
+	// Responses are derived again from the earliest changed message
+	changed := make(map[string]time.Time)
 	for _, m := range messages {
 		// See the full list of message subtypes here:
 		// https://api.slack.com/events/message
 		switch m.Type {
-		case "":
+		case "", "thread_broadcast":
 			txtMessages = append(txtMessages, m)
-		case "channel_archive":
+			if t, ok := changed[m.ChannelID]; !ok || m.CreatedAt.Before(t) {
+				changed[m.ChannelID] = m.CreatedAt
+			}
+		case "channel_archive", "group_archive":
 			if err := f.st.UpdateChannelArch(m.ChannelID, true); err != nil {
 				log.Println("Cannot archive channel:", err)
 			}
 			log.Println("Channel archived:", m.ChannelID)
-		case "channel_unarchive":
+		case "channel_unarchive", "group_unarchive":
 			if err := f.st.UpdateChannelArch(m.ChannelID, false); err != nil {
 				log.Println("Cannot unarchive channel:", err)
 			}
 			log.Println("Channel unarchived:", m.ChannelID)
-		case "channel_name":
+		case "channel_name", "group_name":
 			if err := f.st.UpdateChannelName(m.ChannelID, m.Name); err != nil {
 				log.Println("Cannot rename channel:", err)
 			}
 			log.Printf("Channel %s renamed to %s\n", m.ChannelID, m.Name)
+		case "message_changed":
+			if err := f.st.EditMessage(m.ChannelID, m.TS, m.Text, m.EditedAt); err != nil {
+				log.Println("Cannot edit message:", err)
+			}
+		case "message_deleted":
+			if err := f.st.DeleteMessage(m.ChannelID, m.TS); err != nil {
+				log.Println("Cannot delete message:", err)
+			}
+			if len(m.TS) >= 17 {
+				t := strToTime(m.TS)
+				if since, ok := changed[m.ChannelID]; !ok || t.Before(since) {
+					changed[m.ChannelID] = t
+				}
+			}
+			log.Printf("Message %s deleted from %s\n", m.TS, m.ChannelID)
+		case "reaction_added":
+			if err := f.st.AddReaction(m.ChannelID, m.TS, m.UserID, m.Name); err != nil {
+				log.Println("Cannot add reaction:", err)
+			}
+		case "reaction_removed":
+			if err := f.st.RemoveReaction(m.ChannelID, m.TS, m.UserID, m.Name); err != nil {
+				log.Println("Cannot remove reaction:", err)
+			}
+		}
+	}
+	created, err := f.st.UpdateMessages(txtMessages)
+	if err != nil {
+		return err
+	}
+	f.updateChannelResponses(changed)
+	if err := f.resetChannelStatuses(txtMessages); err != nil {
+		return err
+	}
+	// Retries and backfills bring stored messages again, they aren't new
+	return f.emitGuestMessages(created)
+}
+
+// resetChannelStatuses clears the OK flag of channels which received
+// messages from guests. Messages are of guests if any board of the channel
+// tells so by its domains.
+func (f *Figaro) resetChannelStatuses(messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.UserID)
+	}
+	users, err := f.st.GetUsers(ids)
+	if err != nil {
+		return err
+	}
+	idToUser := make(map[string]*User)
+	for _, user := range users {
+		idToUser[user.ID] = user
+	}
+	idToChannel := make(map[string]*Channel)
+	boards := f.servedBoards()
+	channelIDs := make(map[string]struct{})
+	for _, m := range messages {
+		channel, ok := idToChannel[m.ChannelID]
+		if !ok {
+			channel, err = f.st.GetChannel(m.ChannelID)
+			if err == sql.ErrNoRows {
+				channel, err = nil, nil
+			}
+			if err != nil {
+				return err
+			}
+			idToChannel[m.ChannelID] = channel
+		}
+		if channel == nil {
+			continue
+		}
+		for _, sb := range boards {
+			if sb.matches(channel) && !f.roles.isInternal(idToUser[m.UserID], sb.conf.Domains) {
+				channelIDs[m.ChannelID] = struct{}{}
+				break
+			}
+		}
+	}
+	for id := range channelIDs {
+		if err := f.st.UpdateChannelStatus(id, false); err != nil {
+			return err
 		}
 	}
-	return f.st.UpdateMessages(txtMessages)
+	return nil
 }
 
 func (f *Figaro) updateMessages() error {
@@ -0,0 +1,336 @@
+package figaro
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testUsers returns an internal user, a guest from another domain, a partner
+// allowed to answer, a denied user from the internal domain, a bot and a
+// Slack guest account with an internal email.
+func testUsers() []*User {
+	return []*User{
+		{ID: "UINT", Email: "ann@corp.com"},
+		{ID: "UGUEST", Email: "bob@customer.com"},
+		{ID: "UALLOW", Email: "cid@partner.com"},
+		{ID: "UDENY", Email: "dan@corp.com"},
+		{ID: "UBOT", IsBot: true},
+		{ID: "URESTR", Email: "eve@corp.com", IsRestricted: true},
+	}
+}
+
+// newTestFigaro starts Figaro with testUsers, the channels and the history
+// on a scripted source and an in-memory storage. All users are members of
+// all channels, UINT is an admin.
+func newTestFigaro(t *testing.T, channels []*Channel, history []*Message) (*Figaro, *ScriptedSource, *MemStorage) {
+	src := NewScriptedSource(testUsers(), channels, history)
+	for _, channel := range channels {
+		var ids []string
+		for _, user := range testUsers() {
+			ids = append(ids, user.ID)
+		}
+		src.SetMembers(channel.ID, ids)
+	}
+	st := NewMemStorage()
+	rolesConf := RoleConfig{
+		Allow:  []string{"UALLOW"},
+		Deny:   []string{"UDENY"},
+		Admins: []string{"UINT"},
+	}
+	f, err := NewFigaro(src, st, NewPushService(nil), ".*", 3,
+		[]string{"corp.com"}, []string{"eyes"}, rolesConf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, src, st
+}
+
+// testMessage returns a message of the user in C1 with the Slack timestamp
+// of t.
+func testMessage(userID string, t time.Time, text string) *Message {
+	return &Message{
+		TS:        timeToStr(t),
+		UserID:    userID,
+		ChannelID: "C1",
+		CreatedAt: t,
+		Text:      text,
+	}
+}
+
+func TestProcessMessages(t *testing.T) {
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	ts := timeToStr(t0)
+	broadcast := testMessage("UGUEST", t1, "broadcast")
+	broadcast.Type = "thread_broadcast"
+	tests := []struct {
+		name     string
+		ok       bool // The OK flag of C1 before
+		messages []*Message
+		// Expected state of C1
+		wantOk        bool
+		wantArchived  bool
+		wantName      string
+		wantTexts     []string // Newest first
+		wantReactions []string // Reactions to the question
+	}{{
+		name:      "guest message resets OK",
+		ok:        true,
+		messages:  []*Message{testMessage("UGUEST", t1, "more")},
+		wantName:  "one",
+		wantTexts: []string{"more", "question"},
+	}, {
+		name:      "internal message keeps OK",
+		ok:        true,
+		messages:  []*Message{testMessage("UINT", t1, "answer")},
+		wantOk:    true,
+		wantName:  "one",
+		wantTexts: []string{"answer", "question"},
+	}, {
+		name:      "bot message keeps OK",
+		ok:        true,
+		messages:  []*Message{testMessage("UBOT", t1, "beep")},
+		wantOk:    true,
+		wantName:  "one",
+		wantTexts: []string{"beep", "question"},
+	}, {
+		name:      "thread broadcast is a message",
+		messages:  []*Message{broadcast},
+		wantName:  "one",
+		wantTexts: []string{"broadcast", "question"},
+	}, {
+		name: "edit",
+		messages: []*Message{{Type: "message_changed", ChannelID: "C1", TS: ts,
+			Text: "edited", EditedAt: t1}},
+		wantName:  "one",
+		wantTexts: []string{"edited"},
+	}, {
+		name:     "delete",
+		messages: []*Message{{Type: "message_deleted", ChannelID: "C1", TS: ts}},
+		wantName: "one",
+	}, {
+		name:         "archive",
+		messages:     []*Message{{Type: "channel_archive", ChannelID: "C1"}},
+		wantArchived: true,
+		wantName:     "one",
+		wantTexts:    []string{"question"},
+	}, {
+		name: "unarchive",
+		messages: []*Message{
+			{Type: "channel_archive", ChannelID: "C1"},
+			{Type: "channel_unarchive", ChannelID: "C1"},
+		},
+		wantName:  "one",
+		wantTexts: []string{"question"},
+	}, {
+		name:      "rename",
+		messages:  []*Message{{Type: "channel_name", ChannelID: "C1", Name: "renamed"}},
+		wantName:  "renamed",
+		wantTexts: []string{"question"},
+	}, {
+		name: "reactions",
+		messages: []*Message{
+			{Type: "reaction_added", ChannelID: "C1", TS: ts, UserID: "UINT", Name: "eyes"},
+			{Type: "reaction_added", ChannelID: "C1", TS: ts, UserID: "UGUEST", Name: "+1"},
+			{Type: "reaction_removed", ChannelID: "C1", TS: ts, UserID: "UGUEST", Name: "+1"},
+		},
+		wantName:      "one",
+		wantTexts:     []string{"question"},
+		wantReactions: []string{"eyes"},
+	}, {
+		name:      "other subtypes are ignored",
+		ok:        true,
+		messages:  []*Message{{Type: "channel_join", ChannelID: "C1", UserID: "UGUEST"}},
+		wantOk:    true,
+		wantName:  "one",
+		wantTexts: []string{"question"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, _, st := newTestFigaro(t, []*Channel{{ID: "C1", Name: "one"}},
+				[]*Message{testMessage("UGUEST", t0, "question")})
+			if err := st.UpdateChannelStatus("C1", tt.ok); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.processMessages(tt.messages); err != nil {
+				t.Fatal(err)
+			}
+			channel, err := st.GetChannel("C1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if channel.Ok != tt.wantOk || channel.Archived != tt.wantArchived ||
+				channel.Name != tt.wantName {
+				t.Errorf("got Ok %v, Archived %v, Name %q, want %v, %v, %q",
+					channel.Ok, channel.Archived, channel.Name,
+					tt.wantOk, tt.wantArchived, tt.wantName)
+			}
+			messages, err := st.GetMessagesByChannel("C1", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var texts, reactions []string
+			for _, m := range messages {
+				texts = append(texts, m.Text)
+				if m.TS != ts {
+					continue
+				}
+				for _, r := range m.Reactions {
+					reactions = append(reactions, r.Name)
+				}
+			}
+			if !reflect.DeepEqual(texts, tt.wantTexts) {
+				t.Errorf("got messages %q, want %q", texts, tt.wantTexts)
+			}
+			if !reflect.DeepEqual(reactions, tt.wantReactions) {
+				t.Errorf("got reactions %q, want %q", reactions, tt.wantReactions)
+			}
+		})
+	}
+}
+
+func TestResetChannelStatusByBoardDomains(t *testing.T) {
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	channels := []*Channel{{ID: "C1", Name: "one"}, {ID: "C2", Name: "two"}}
+	src := NewScriptedSource(testUsers(), channels, nil)
+	st := NewMemStorage()
+	// #two is on the board of customer.com only
+	f, err := NewFigaro(src, st, NewPushService(nil), "^one$", 3,
+		[]string{"corp.com"}, nil, RoleConfig{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.PutBoard(&Board{Name: "customer", Include: []string{"^two$"},
+		Domains: []string{"customer.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		channelID string
+		wantOk    bool
+	}{
+		{channelID: "C1", wantOk: false},
+		{channelID: "C2", wantOk: true},
+	} {
+		if err := st.UpdateChannelStatus(tt.channelID, true); err != nil {
+			t.Fatal(err)
+		}
+		m := testMessage("UGUEST", t0, "question")
+		m.ChannelID = tt.channelID
+		if err := f.processMessages([]*Message{m}); err != nil {
+			t.Fatal(err)
+		}
+		channel, err := st.GetChannel(tt.channelID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if channel.Ok != tt.wantOk {
+			t.Errorf("%s: got Ok %v, want %v", tt.channelID, channel.Ok, tt.wantOk)
+		}
+	}
+}
+
+func TestHalves(t *testing.T) {
+	f, _, _ := newTestFigaro(t, nil, nil)
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		author    string
+		ok        bool
+		reactions []*Reaction
+		domains   []string // corp.com if nil
+		want      string
+	}{
+		{name: "internal", author: "UINT", want: HalfOk},
+		{name: "guest", author: "UGUEST", want: HalfBad},
+		{name: "guest marked OK", author: "UGUEST", ok: true, want: HalfOk},
+		{name: "allowed partner", author: "UALLOW", want: HalfOk},
+		{name: "denied user in domain", author: "UDENY", want: HalfBad},
+		{name: "bot", author: "UBOT", want: HalfOk},
+		{name: "Slack guest in domain", author: "URESTR", want: HalfBad},
+		{name: "unknown user", author: "UNOBODY", want: HalfBad},
+		{name: "internal in board domains", author: "UGUEST",
+			domains: []string{"customer.com"}, want: HalfOk},
+		{name: "internal with other board domains", author: "UINT",
+			domains: []string{"customer.com"}, want: HalfOk},
+		{name: "acknowledged", author: "UGUEST",
+			reactions: []*Reaction{{Name: "eyes", UserIDs: []string{"UINT"}}},
+			want:      HalfOk},
+		{name: "acknowledged with skin tone", author: "UGUEST",
+			reactions: []*Reaction{{Name: ":eyes::skin-tone-2:", UserIDs: []string{"UINT"}}},
+			want:      HalfOk},
+		{name: "acknowledged by guest", author: "UGUEST",
+			reactions: []*Reaction{{Name: "eyes", UserIDs: []string{"UGUEST"}}},
+			want:      HalfBad},
+		{name: "other reaction", author: "UGUEST",
+			reactions: []*Reaction{{Name: "+1", UserIDs: []string{"UINT"}}},
+			want:      HalfBad},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMessage(tt.author, t0, "text")
+			m.Reactions = tt.reactions
+			channel := &Channel{ID: "C1", Ok: tt.ok, Messages: []*Message{m}}
+			domains := tt.domains
+			if domains == nil {
+				domains = []string{"corp.com"}
+			}
+			halves, err := f.halves([]*Channel{channel}, domains)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if halves["C1"] != tt.want {
+				t.Errorf("got %q, want %q", halves["C1"], tt.want)
+			}
+		})
+	}
+}
+
+func TestSortChannelsByLastMessageTime(t *testing.T) {
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	// channel returns a channel whose last message was created and started
+	// to wait the given minutes after t0. Zero wait start is not set.
+	channel := func(id string, created, waitStart int) *Channel {
+		c := &Channel{ID: id, Messages: []*Message{
+			{CreatedAt: t0.Add(time.Duration(created) * time.Minute)},
+		}}
+		if waitStart != 0 {
+			c.WaitStart = t0.Add(time.Duration(waitStart) * time.Minute)
+		}
+		return c
+	}
+	tests := []struct {
+		name     string
+		channels []*Channel
+		want     []string
+	}{{
+		name:     "by last message time",
+		channels: []*Channel{channel("C1", 2, 0), channel("C2", 1, 0), channel("C3", 3, 0)},
+		want:     []string{"C2", "C1", "C3"},
+	}, {
+		name:     "by wait start",
+		channels: []*Channel{channel("C1", 1, 600), channel("C2", 2, 2)},
+		want:     []string{"C2", "C1"},
+	}, {
+		name:     "same wait start by last message time",
+		channels: []*Channel{channel("C1", 2, 600), channel("C2", 1, 600)},
+		want:     []string{"C2", "C1"},
+	}, {
+		name:     "wait start and message time",
+		channels: []*Channel{channel("C1", 5, 0), channel("C2", 1, 4)},
+		want:     []string{"C2", "C1"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sortChannelsByLastMessageTime(tt.channels)
+			var got []string
+			for _, c := range tt.channels {
+				got = append(got, c.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package figaro
+
+import (
+	"log"
+	"sync"
+)
+
+// members keeps members of channels on the board in memory, so that every
+// connection sees only the channels its user is a member of.
+type members struct {
+	mu       sync.RWMutex
+	channels map[string]map[string]bool
+}
+
+func newMembers() *members {
+	return &members{channels: make(map[string]map[string]bool)}
+}
+
+func (m *members) set(chID string, userIDs []string) {
+	set := make(map[string]bool)
+	for _, userID := range userIDs {
+		set[userID] = true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.channels[chID] = set
+}
+
+func (m *members) add(chID, userID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.channels[chID] == nil {
+		m.channels[chID] = make(map[string]bool)
+	}
+	m.channels[chID][userID] = true
+}
+
+func (m *members) remove(chID, userID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.channels[chID], userID)
+}
+
+func (m *members) isMember(chID, userID string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.channels[chID][userID]
+}
+
+// Visible tells if the user sees the channel. Users see channels they are
+// members of. Everything is visible to anonymous users with the empty ID,
+// they exist only when authentication is off.
+func (f *Figaro) Visible(userID, chID string) bool {
+	return userID == "" || f.members.isMember(chID, userID)
+}
+
+// updateMembers loads members of channels on the boards from Slack. If
+// Slack fails, the stored members are used.
+func (f *Figaro) updateMembers(boards []*servedBoard) error {
+	log.Println("Figaro: Updating members...")
+	channels, err := f.sl.GetChannels()
+	if err != nil {
+		log.Println("Figaro: Cannot get channels from Slack:", err)
+		return err
+	}
+	for _, channel := range channels {
+		if !isOnBoards(channel, boards) {
+			continue
+		}
+		userIDs, err := f.sl.GetMembers(channel.ID)
+		if err == nil {
+			err = f.st.UpdateMembers(channel.ID, userIDs)
+			if err != nil {
+				log.Println("Figaro: Cannot update members in Storage:", err)
+				return err
+			}
+		} else {
+			log.Println("Figaro: Cannot get members from Slack:", err)
+			if userIDs, err = f.st.GetMembers(channel.ID); err != nil {
+				log.Println("Figaro: Cannot get members from Storage:", err)
+				return err
+			}
+		}
+		f.members.set(channel.ID, userIDs)
+	}
+	log.Println("Figaro: Members updated")
+	return nil
+}
+
+// updateMembership stores a membership change and shows the channel to the
+// user who joined it or hides it from the user who left.
+func (f *Figaro) updateMembership(m *Message) {
+	switch m.Type {
+	case "member_joined_channel":
+		if err := f.st.AddMember(m.ChannelID, m.UserID); err != nil {
+			log.Println("Cannot add member:", err)
+		}
+		f.members.add(m.ChannelID, m.UserID)
+		log.Printf("User %s joined %s\n", m.UserID, m.ChannelID)
+		for _, sb := range f.servedBoards() {
+			if event := sb.state.get(m.ChannelID); event != nil {
+				f.pushTo(sb, m.UserID, event)
+			}
+		}
+	case "member_left_channel":
+		if err := f.st.RemoveMember(m.ChannelID, m.UserID); err != nil {
+			log.Println("Cannot remove member:", err)
+		}
+		f.members.remove(m.ChannelID, m.UserID)
+		log.Printf("User %s left %s\n", m.UserID, m.ChannelID)
+		for _, sb := range f.servedBoards() {
+			if event := sb.state.hide(m.ChannelID); event != nil {
+				f.pushTo(sb, m.UserID, event)
+			}
+		}
+	}
+}
+
+func isOnBoards(channel *Channel, boards []*servedBoard) bool {
+	for _, sb := range boards {
+		if sb.matches(channel) {
+			return true
+		}
+	}
+	return false
+}
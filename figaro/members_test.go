@@ -0,0 +1,80 @@
+package figaro
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// channelIDs returns IDs of channels in both halves sorted.
+func channelIDs(pair *ChannelPair) []string {
+	var ids []string
+	for _, channel := range append(append([]*Channel(nil), pair.Bad...), pair.Ok...) {
+		ids = append(ids, channel.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestMembersOnly(t *testing.T) {
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	other := testMessage("UGUEST", t0, "other question")
+	other.ChannelID = "C2"
+	f, _, _ := newTestFigaro(t, []*Channel{{ID: "C1", Name: "one"}, {ID: "C2", Name: "two"}},
+		[]*Message{testMessage("UGUEST", t0, "question"), other})
+	f.updateMembership(&Message{Type: "member_left_channel", ChannelID: "C2", UserID: "UDENY"})
+	// UDENY is signed in to the server
+	h := f.Handler()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, asUser(r, "UDENY"))
+	}))
+	t.Cleanup(srv.Close)
+	want := []string{"C1"}
+
+	for _, user := range []string{"UDENY", "UINT"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, asUser(httptest.NewRequest("GET", "/channels", nil), user))
+		pair := &ChannelPair{}
+		if err := json.Unmarshal(rec.Body.Bytes(), pair); err != nil {
+			t.Fatal(err)
+		}
+		wantIDs := want
+		if user == "UINT" {
+			wantIDs = []string{"C1", "C2"}
+		}
+		if ids := channelIDs(pair); !reflect.DeepEqual(ids, wantIDs) {
+			t.Errorf("/channels of %s: got %q, want %q", user, ids, wantIDs)
+		}
+	}
+
+	conn := dialPushes(t, srv, "")
+	snapshot := &BoardEvent{}
+	if err := json.Unmarshal([]byte(readPush(t, conn)), snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if ids := channelIDs(snapshot.Board); !reflect.DeepEqual(ids, want) {
+		t.Errorf("/ws: got %q, want %q", ids, want)
+	}
+	e := streamEvents(t, srv, "")
+	if ids := channelIDs(e.event.Board); !reflect.DeepEqual(ids, want) {
+		t.Errorf("/events: got %q, want %q", ids, want)
+	}
+
+	// Changes of the hidden channel don't come either
+	for _, id := range []string{"C2", "C1"} {
+		if err := f.UpdateChannelStatus(id, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	change := &BoardEvent{}
+	if err := json.Unmarshal([]byte(readPush(t, conn)), change); err != nil {
+		t.Fatal(err)
+	}
+	if change.ID != "C1" {
+		t.Errorf("/ws: got %s event about %q, want C1", change.Type, change.ID)
+	}
+}
@@ -0,0 +1,765 @@
+package figaro
+
+import (
+	"database/sql"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemStorage is an in-memory Store for tests. It behaves like Storage.
+type MemStorage struct {
+	mu       sync.Mutex
+	users    map[string]User
+	channels map[string]Channel
+	// messages by channel ID including deleted ones
+	messages map[string][]Message
+	deleted  map[string]map[string]bool
+	// reactions by channel ID and Slack timestamp of messages
+	reactions map[string]map[string][]Reaction
+	// members by channel ID
+	members map[string]map[string]bool
+	boards  map[string]Board
+	// calendars by name
+	calendars map[string]Calendar
+	// notifications by channel ID
+	notifications map[string]Notification
+	// webhooks by name
+	webhooks map[string]Webhook
+	// deliveries sorted by ID
+	deliveries     []Delivery
+	lastDeliveryID int64
+	// responses by board and channel ID sorted by the time they were asked
+	responses map[string]map[string][]Response
+}
+
+var _ Store = (*MemStorage)(nil)
+
+// NewMemStorage creates an empty in-memory storage.
+func NewMemStorage() *MemStorage {
+	return &MemStorage{
+		users:         make(map[string]User),
+		channels:      make(map[string]Channel),
+		messages:      make(map[string][]Message),
+		deleted:       make(map[string]map[string]bool),
+		reactions:     make(map[string]map[string][]Reaction),
+		members:       make(map[string]map[string]bool),
+		boards:        make(map[string]Board),
+		calendars:     make(map[string]Calendar),
+		notifications: make(map[string]Notification),
+		webhooks:      make(map[string]Webhook),
+		responses:     make(map[string]map[string][]Response),
+	}
+}
+
+// UpdateUsers updates users in bulk.
+// If the user doesn't exist, then create a new one.
+func (s *MemStorage) UpdateUsers(users []*User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range users {
+		s.users[user.ID] = *user
+	}
+	return nil
+}
+
+// GetUsers Gets users by IDs
+func (s *MemStorage) GetUsers(ids []string) ([]*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []*User
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		user, ok := s.users[id]
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+		users = append(users, &user)
+	}
+	return users, nil
+}
+
+// UpdateMessages updates or creates messages in bulk.
+// If message with the same channel ID and Slack timestamp exists,
+// then update it, otherwise creates a new message. It returns the created
+// messages.
+func (s *MemStorage) UpdateMessages(messages []*Message) ([]*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var created []*Message
+	for _, m := range messages {
+		if s.updateMessage(m) {
+			created = append(created, m)
+		}
+		if m.Reactions != nil {
+			s.messageReactions(m.ChannelID)[m.TS] = nil
+			for _, r := range m.Reactions {
+				for _, userID := range r.UserIDs {
+					s.addReaction(m.ChannelID, m.TS, userID, r.Name)
+				}
+			}
+		}
+	}
+	return created, nil
+}
+
+func (s *MemStorage) messageReactions(chID string) map[string][]Reaction {
+	if s.reactions[chID] == nil {
+		s.reactions[chID] = make(map[string][]Reaction)
+	}
+	return s.reactions[chID]
+}
+
+// AddReaction adds a reaction of a user to a message.
+func (s *MemStorage) AddReaction(chID, ts, userID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addReaction(chID, ts, userID, name)
+	return nil
+}
+
+func (s *MemStorage) addReaction(chID, ts, userID, name string) {
+	reactions := s.messageReactions(chID)
+	for i, r := range reactions[ts] {
+		if r.Name != name {
+			continue
+		}
+		for _, id := range r.UserIDs {
+			if id == userID {
+				return
+			}
+		}
+		userIDs := append(append([]string(nil), r.UserIDs...), userID)
+		sort.Strings(userIDs)
+		reactions[ts][i].UserIDs = userIDs
+		return
+	}
+	reactions[ts] = append(reactions[ts], Reaction{Name: name, UserIDs: []string{userID}})
+	sort.Slice(reactions[ts], func(i, j int) bool {
+		return reactions[ts][i].Name < reactions[ts][j].Name
+	})
+}
+
+// RemoveReaction removes a reaction of a user from a message.
+func (s *MemStorage) RemoveReaction(chID, ts, userID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reactions := s.messageReactions(chID)
+	var kept []Reaction
+	for _, r := range reactions[ts] {
+		if r.Name == name {
+			var userIDs []string
+			for _, id := range r.UserIDs {
+				if id != userID {
+					userIDs = append(userIDs, id)
+				}
+			}
+			if len(userIDs) == 0 {
+				continue
+			}
+			r.UserIDs = userIDs
+		}
+		kept = append(kept, r)
+	}
+	reactions[ts] = kept
+	return nil
+}
+
+// updateMessage tells if the message is created.
+func (s *MemStorage) updateMessage(m *Message) bool {
+	messages := s.messages[m.ChannelID]
+	for i := range messages {
+		if messages[i].TS == m.TS {
+			messages[i].UserID = m.UserID
+			messages[i].CreatedAt = m.CreatedAt.UTC()
+			messages[i].Text = m.Text
+			messages[i].EditedAt = m.EditedAt.UTC()
+			messages[i].ThreadTS = m.ThreadTS
+			messages[i].ParentUserID = m.ParentUserID
+			messages[i].IsReply = m.IsReply
+			messages[i].TeamID = m.TeamID
+			return false
+		}
+	}
+	s.messages[m.ChannelID] = append(messages, Message{
+		TS:           m.TS,
+		UserID:       m.UserID,
+		ChannelID:    m.ChannelID,
+		CreatedAt:    m.CreatedAt.UTC(),
+		EditedAt:     m.EditedAt.UTC(),
+		Text:         m.Text,
+		ThreadTS:     m.ThreadTS,
+		ParentUserID: m.ParentUserID,
+		IsReply:      m.IsReply,
+		TeamID:       m.TeamID,
+	})
+	return true
+}
+
+// GetMessagesByChannel returns limited amount of messages by channel
+// sorted descendingly by creation time.
+func (s *MemStorage) GetMessagesByChannel(channelID string,
+	limit uint) ([]*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastMessages(channelID, limit), nil
+}
+
+// EditMessage updates text of a message identified by its channel ID and
+// Slack timestamp.
+func (s *MemStorage) EditMessage(chID, ts, text string, editedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messages := s.messages[chID]
+	for i := range messages {
+		if messages[i].TS == ts {
+			messages[i].Text = text
+			if !editedAt.IsZero() {
+				messages[i].EditedAt = editedAt.UTC()
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteMessage marks a message identified by its channel ID and Slack
+// timestamp as deleted. Deleted messages are not returned anymore.
+func (s *MemStorage) DeleteMessage(chID, ts string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.deleted[chID] == nil {
+		s.deleted[chID] = make(map[string]bool)
+	}
+	s.deleted[chID][ts] = true
+	return nil
+}
+
+func (s *MemStorage) lastMessages(channelID string, limit uint) []*Message {
+	all := s.messages[channelID]
+	messages := make([]*Message, 0, len(all))
+	for i := range all {
+		m := all[i]
+		if s.deleted[channelID][m.TS] {
+			continue
+		}
+		for _, r := range s.reactions[channelID][m.TS] {
+			reaction := &Reaction{
+				Name:    r.Name,
+				UserIDs: append([]string(nil), r.UserIDs...),
+			}
+			m.Reactions = append(m.Reactions, reaction)
+		}
+		messages = append(messages, &m)
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.After(messages[j].CreatedAt)
+	})
+	if uint(len(messages)) > limit {
+		messages = messages[:limit]
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return messages
+}
+
+// GetMessagesSince returns messages of the channel created at or after since
+// sorted ascendingly by creation time, without reactions.
+func (s *MemStorage) GetMessagesSince(chID string, since time.Time) ([]*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []*Message
+	for _, m := range s.messages[chID] {
+		if s.deleted[chID][m.TS] || m.CreatedAt.Before(since) {
+			continue
+		}
+		m := m
+		messages = append(messages, &m)
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+	return messages, nil
+}
+
+// GetMessagesBetween returns messages of the channel, or of all channels if
+// chID is empty, created from from till to sorted ascendingly by creation
+// time, without reactions.
+func (s *MemStorage) GetMessagesBetween(chID string, from, to time.Time) ([]*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []*Message
+	for id, channelMessages := range s.messages {
+		if chID != "" && id != chID {
+			continue
+		}
+		for _, m := range channelMessages {
+			if s.deleted[id][m.TS] || m.CreatedAt.Before(from) || !m.CreatedAt.Before(to) {
+				continue
+			}
+			m := m
+			messages = append(messages, &m)
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		if !messages[i].CreatedAt.Equal(messages[j].CreatedAt) {
+			return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+		}
+		return messages[i].ChannelID < messages[j].ChannelID
+	})
+	return messages, nil
+}
+
+// GetLastMessageTS returns a timestamp of a last message in a channel and
+// zero time if channel is empty.
+func (s *MemStorage) GetLastMessageTS(chID string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var t time.Time
+	// Deleted messages count as well
+	for _, m := range s.messages[chID] {
+		if m.CreatedAt.After(t) {
+			t = m.CreatedAt
+		}
+	}
+	return t, nil
+}
+
+// UpdateChannels updates channels in bulk.
+// If the channel doesn't exist, then creates a new one.
+func (s *MemStorage) UpdateChannels(channels []*Channel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ch := range channels {
+		stored := s.channels[ch.ID]
+		stored.ID = ch.ID
+		stored.Name = ch.Name
+		stored.Archived = ch.Archived
+		stored.Type = ch.Type
+		stored.Shared = ch.Shared
+		stored.ExtShared = ch.ExtShared
+		stored.TeamID = ch.TeamID
+		s.channels[ch.ID] = stored
+	}
+	return nil
+}
+
+// UpdateChannelStatus updates a channel status.
+func (s *MemStorage) UpdateChannelStatus(id string, ok bool) error {
+	return s.updateChannel(id, func(ch *Channel) { ch.Ok = ok })
+}
+
+// UpdateChannelSLA updates SLA of a channel in seconds, 0 for the default
+// one.
+func (s *MemStorage) UpdateChannelSLA(id string, sla uint) error {
+	return s.updateChannel(id, func(ch *Channel) { ch.SLA = sla })
+}
+
+// UpdateChannelCalendar attaches a calendar to a channel by its name, the
+// empty name detaches it.
+func (s *MemStorage) UpdateChannelCalendar(id, calendar string) error {
+	return s.updateChannel(id, func(ch *Channel) { ch.Calendar = calendar })
+}
+
+// UpdateChannelArch archives or unarchives a channel.
+func (s *MemStorage) UpdateChannelArch(id string, archived bool) error {
+	return s.updateChannel(id, func(ch *Channel) { ch.Archived = archived })
+}
+
+// UpdateChannelName renames a channel.
+func (s *MemStorage) UpdateChannelName(id string, name string) error {
+	return s.updateChannel(id, func(ch *Channel) { ch.Name = name })
+}
+
+func (s *MemStorage) updateChannel(id string, update func(ch *Channel)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.channels[id]
+	if !ok {
+		// The same as UPDATE of a missing row
+		return nil
+	}
+	update(&ch)
+	s.channels[id] = ch
+	return nil
+}
+
+// UpdateMembers replaces members of a channel with users by IDs.
+func (s *MemStorage) UpdateMembers(chID string, userIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members := make(map[string]bool)
+	for _, userID := range userIDs {
+		members[userID] = true
+	}
+	s.members[chID] = members
+	return nil
+}
+
+// AddMember adds a user to members of a channel.
+func (s *MemStorage) AddMember(chID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.members[chID] == nil {
+		s.members[chID] = make(map[string]bool)
+	}
+	s.members[chID][userID] = true
+	return nil
+}
+
+// RemoveMember removes a user from members of a channel.
+func (s *MemStorage) RemoveMember(chID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.members[chID], userID)
+	return nil
+}
+
+// GetMembers returns IDs of members of a channel.
+func (s *MemStorage) GetMembers(chID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var userIDs []string
+	for userID := range s.members[chID] {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+	return userIDs, nil
+}
+
+// GetChannel returns channel by its ID.
+func (s *MemStorage) GetChannel(chID string) (*Channel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.channels[chID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &ch, nil
+}
+
+// GetChannelsByRegex returns channels which names match the given regex with
+// the last lim messages. It doesn't return channels which don't have messages.
+func (s *MemStorage) GetChannelsByRegex(pattern string, lim uint) ([]*Channel, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var channels []*Channel
+	for _, ch := range s.channels {
+		if !re.MatchString(ch.Name) {
+			continue
+		}
+		channel := ch
+		channel.Messages = s.lastMessages(ch.ID, lim)
+		if len(channel.Messages) == 0 {
+			continue
+		}
+		channels = append(channels, &channel)
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].ID < channels[j].ID
+	})
+	return channels, nil
+}
+
+// UpdateBoard creates or replaces the board with the same name.
+func (s *MemStorage) UpdateBoard(board *Board) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.boards[board.Name] = *board
+	return nil
+}
+
+// DeleteBoard deletes the board by its name.
+func (s *MemStorage) DeleteBoard(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.boards, name)
+	delete(s.responses, name)
+	return nil
+}
+
+// GetBoards returns all boards sorted by name.
+func (s *MemStorage) GetBoards() ([]*Board, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var boards []*Board
+	for _, board := range s.boards {
+		board := board
+		boards = append(boards, &board)
+	}
+	sort.Slice(boards, func(i, j int) bool {
+		return boards[i].Name < boards[j].Name
+	})
+	return boards, nil
+}
+
+// UpdateCalendar creates or replaces the calendar with the same name.
+func (s *MemStorage) UpdateCalendar(calendar *Calendar) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calendars[calendar.Name] = *calendar
+	return nil
+}
+
+// DeleteCalendar deletes the calendar by its name.
+func (s *MemStorage) DeleteCalendar(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.calendars, name)
+	return nil
+}
+
+// GetCalendars returns all calendars sorted by name.
+func (s *MemStorage) GetCalendars() ([]*Calendar, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var calendars []*Calendar
+	for _, calendar := range s.calendars {
+		calendar := calendar
+		calendars = append(calendars, &calendar)
+	}
+	sort.Slice(calendars, func(i, j int) bool {
+		return calendars[i].Name < calendars[j].Name
+	})
+	return calendars, nil
+}
+
+// UpdateNotification creates or replaces the notification of the channel.
+func (s *MemStorage) UpdateNotification(n *Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifications[n.ChannelID] = *n
+	return nil
+}
+
+// DeleteNotification deletes the notification of the channel.
+func (s *MemStorage) DeleteNotification(chID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.notifications, chID)
+	return nil
+}
+
+// GetNotifications returns notifications of all channels.
+func (s *MemStorage) GetNotifications() ([]*Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var notifications []*Notification
+	for _, n := range s.notifications {
+		n := n
+		notifications = append(notifications, &n)
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].ChannelID < notifications[j].ChannelID
+	})
+	return notifications, nil
+}
+
+// UpdateWebhook creates or replaces the webhook with the same name.
+func (s *MemStorage) UpdateWebhook(webhook *Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhooks[webhook.Name] = *webhook
+	return nil
+}
+
+// DeleteWebhook deletes the webhook by its name. Its deliveries stay in the
+// log.
+func (s *MemStorage) DeleteWebhook(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.webhooks, name)
+	return nil
+}
+
+// GetWebhooks returns all webhooks sorted by name.
+func (s *MemStorage) GetWebhooks() ([]*Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var webhooks []*Webhook
+	for _, webhook := range s.webhooks {
+		webhook := webhook
+		webhooks = append(webhooks, &webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].Name < webhooks[j].Name
+	})
+	return webhooks, nil
+}
+
+// AddDelivery adds the delivery and sets its ID.
+func (s *MemStorage) AddDelivery(d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastDeliveryID++
+	d.ID = s.lastDeliveryID
+	s.deliveries = append(s.deliveries, *d)
+	return nil
+}
+
+// UpdateDelivery updates the outcome of the delivery.
+func (s *MemStorage) UpdateDelivery(d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.deliveries {
+		if s.deliveries[i].ID == d.ID {
+			s.deliveries[i].Status = d.Status
+			s.deliveries[i].Attempts = d.Attempts
+			s.deliveries[i].ResponseCode = d.ResponseCode
+			s.deliveries[i].Error = d.Error
+			s.deliveries[i].NextAttemptAt = d.NextAttemptAt
+			s.deliveries[i].DeliveredAt = d.DeliveredAt
+		}
+	}
+	return nil
+}
+
+// GetDelivery returns the delivery by its ID or sql.ErrNoRows.
+func (s *MemStorage) GetDelivery(id int64) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.deliveries {
+		if d.ID == id {
+			return &d, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// GetDeliveries returns lim last deliveries of the webhook, the latest
+// first.
+func (s *MemStorage) GetDeliveries(webhook string, lim uint) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deliveries []*Delivery
+	for i := len(s.deliveries) - 1; i >= 0 && uint(len(deliveries)) < lim; i-- {
+		if d := s.deliveries[i]; d.Webhook == webhook {
+			deliveries = append(deliveries, &d)
+		}
+	}
+	return deliveries, nil
+}
+
+// GetDueDeliveries returns pending deliveries due at t, up to lim for every
+// webhook, the oldest first.
+func (s *MemStorage) GetDueDeliveries(t time.Time, lim uint) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deliveries []*Delivery
+	n := make(map[string]uint)
+	for _, d := range s.deliveries {
+		if d.Status == DeliveryPending && !d.NextAttemptAt.After(t) && n[d.Webhook] < lim {
+			n[d.Webhook]++
+			d := d
+			deliveries = append(deliveries, &d)
+		}
+	}
+	return deliveries, nil
+}
+
+// RemoveDeliveries removes deliveries which are not pending created before
+// the time.
+func (s *MemStorage) RemoveDeliveries(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.deliveries[:0]
+	for _, d := range s.deliveries {
+		if d.Status == DeliveryPending || !d.CreatedAt.Before(before) {
+			kept = append(kept, d)
+		}
+	}
+	s.deliveries = kept
+	return nil
+}
+
+// GetResponseAt returns the last question of the channel on the board asked
+// at or before t or sql.ErrNoRows.
+func (s *MemStorage) GetResponseAt(board, chID string, t time.Time) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	responses := s.responses[board][chID]
+	for i := len(responses) - 1; i >= 0; i-- {
+		if !responses[i].AskedAt.After(t) {
+			r := responses[i]
+			return &r, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// ReplaceResponses replaces questions of the channel on the board asked at or
+// after since with the responses.
+func (s *MemStorage) ReplaceResponses(board, chID string, since time.Time,
+	responses []*Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kept []Response
+	for _, r := range s.responses[board][chID] {
+		if r.AskedAt.Before(since) {
+			kept = append(kept, r)
+		}
+	}
+	for _, r := range responses {
+		r := *r
+		r.Board = board
+		kept = append(kept, r)
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].AskedAt.Before(kept[j].AskedAt)
+	})
+	if s.responses[board] == nil {
+		s.responses[board] = make(map[string][]Response)
+	}
+	s.responses[board][chID] = kept
+	return nil
+}
+
+// GetOpenResponses returns unanswered questions of the channels on the
+// board.
+func (s *MemStorage) GetOpenResponses(board string, chIDs []string) ([]*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var open []*Response
+	for _, chID := range chIDs {
+		for _, r := range s.responses[board][chID] {
+			if r.RespondedAt.IsZero() {
+				r := r
+				open = append(open, &r)
+			}
+		}
+	}
+	return open, nil
+}
+
+// GetResponses returns questions of the channel, or of all channels if chID
+// is empty, on the board asked from from till to sorted by the time they
+// were asked.
+func (s *MemStorage) GetResponses(board, chID string, from, to time.Time) ([]*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var responses []*Response
+	for id, channelResponses := range s.responses[board] {
+		if chID != "" && id != chID {
+			continue
+		}
+		for _, r := range channelResponses {
+			if !r.AskedAt.Before(from) && r.AskedAt.Before(to) {
+				r := r
+				responses = append(responses, &r)
+			}
+		}
+	}
+	sort.SliceStable(responses, func(i, j int) bool {
+		if !responses[i].AskedAt.Equal(responses[j].AskedAt) {
+			return responses[i].AskedAt.Before(responses[j].AskedAt)
+		}
+		return responses[i].ChannelID < responses[j].ChannelID
+	})
+	return responses, nil
+}
@@ -0,0 +1,145 @@
+package figaro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// migrationLockKey is the key of the advisory lock which keeps replicas
+// from migrating the schema at the same time.
+const migrationLockKey = 0x46494741524f // "FIGARO"
+
+// migration changes the schema from version-1 to version with up and back
+// with down.
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// postgresMigrations are applied in order. Never change an applied
+// migration, add a new one instead.
+var postgresMigrations = []migration{
+	{1, "initial schema", queryMigrate1Up, queryMigrate1Down},
+	{2, "channel types", queryMigrate2Up, queryMigrate2Down},
+	{3, "thread replies", queryMigrate3Up, queryMigrate3Down},
+	{4, "message timestamps, edits and deletions", queryMigrate4Up, queryMigrate4Down},
+	{5, "reactions", queryMigrate5Up, queryMigrate5Down},
+	{6, "channel members", queryMigrate6Up, queryMigrate6Down},
+	{7, "boards", queryMigrate7Up, queryMigrate7Down},
+	{8, "Slack workspaces", queryMigrate8Up, queryMigrate8Down},
+	{9, "user roles", queryMigrate9Up, queryMigrate9Down},
+	{10, "responses and SLA", queryMigrate10Up, queryMigrate10Down},
+	{11, "business hours calendars", queryMigrate11Up, queryMigrate11Down},
+	{12, "alert notifications", queryMigrate12Up, queryMigrate12Down},
+	{13, "webhooks", queryMigrate13Up, queryMigrate13Down},
+	{14, "responses per board", queryMigrate14Up, queryMigrate14Down},
+}
+
+// LatestSchemaVersion returns the schema version Figaro works with.
+func LatestSchemaVersion() int {
+	return len(postgresMigrations)
+}
+
+// MigrateStorage migrates the schema of the database with the URL connection
+// up or down to the version. It returns the version before migration.
+func MigrateStorage(connURL string, version int) (int, error) {
+	db, d, err := openDB(connURL)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	return migrate(db, d, version)
+}
+
+// migrate applies or reverts migrations of the driver under its lock. Every
+// migration runs in its own transaction.
+func migrate(db *sql.DB, d *storageDriver, version int) (int, error) {
+	migrations := d.migrations
+	if version < 0 || version > len(migrations) {
+		return 0, fmt.Errorf("unknown schema version %d, latest is %d",
+			version, len(migrations))
+	}
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if d.lock != "" {
+		log.Println("Storage: waiting for the migration lock...")
+		if _, err := conn.ExecContext(ctx, d.lock, migrationLockKey); err != nil {
+			return 0, err
+		}
+		defer func() {
+			if _, err := conn.ExecContext(ctx, d.unlock, migrationLockKey); err != nil {
+				log.Println("Storage: cannot release the migration lock:", err)
+			}
+		}()
+	}
+	// Replicas creating the table together may fail in PostgreSQL, so it's
+	// created under the lock
+	if _, err := conn.ExecContext(ctx, d.createMigrationsTable); err != nil {
+		return 0, err
+	}
+	var current int
+	if err := conn.QueryRowContext(ctx, d.rebind(queryGetSchemaVersion)).Scan(&current); err != nil {
+		return 0, err
+	}
+	if current > len(migrations) {
+		if version < len(migrations) {
+			return current, fmt.Errorf("schema version %d is unknown, upgrade Figaro to revert it",
+				current)
+		}
+		// A newer replica has migrated the schema already
+		log.Printf("Storage: schema version %d is newer than %d\n", current, version)
+		return current, nil
+	}
+	for v := current; v < version; v++ {
+		m := migrations[v]
+		log.Printf("Storage: applying migration %d (%s)...\n", m.version, m.name)
+		if err := runMigration(ctx, conn, m.up, d.rebind(queryAddMigration), m.version, m.name); err != nil {
+			return current, fmt.Errorf("migration %d: %v", m.version, err)
+		}
+	}
+	for v := current; v > version; v-- {
+		m := migrations[v-1]
+		log.Printf("Storage: reverting migration %d (%s)...\n", m.version, m.name)
+		if err := runMigration(ctx, conn, m.down, d.rebind(queryRemoveMigration), m.version); err != nil {
+			return current, fmt.Errorf("migration %d: %v", m.version, err)
+		}
+	}
+	log.Println("Storage: schema version", version)
+	return current, nil
+}
+
+// storedSchemaVersion returns the version of the schema of the database.
+func storedSchemaVersion(db *sql.DB, d *storageDriver) (int, error) {
+	var version int
+	if err := db.QueryRow(d.rebind(queryGetSchemaVersion)).Scan(&version); err != nil {
+		return 0, fmt.Errorf("cannot get schema version: %v", err)
+	}
+	return version, nil
+}
+
+// runMigration runs the migration query and records it with the record
+// query in one transaction.
+func runMigration(ctx context.Context, conn *sql.Conn, query, record string,
+	args ...interface{}) error {
+	txn, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := txn.ExecContext(ctx, query); err != nil {
+		txn.Rollback()
+		return err
+	}
+	if _, err := txn.ExecContext(ctx, record, args...); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
@@ -0,0 +1,51 @@
+package figaro
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// schemaVersion returns the version stored in the database.
+func schemaVersion(t *testing.T, connURL string) int {
+	t.Helper()
+	db, d, err := openDB(connURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	version, err := storedSchemaVersion(db, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	connURL := "sqlite://" + filepath.Join(t.TempDir(), "figaro.db")
+	latest := LatestSchemaVersion()
+	steps := []struct {
+		name     string
+		version  int
+		wantFrom int
+	}{
+		{name: "up", version: latest, wantFrom: 0},
+		{name: "up again", version: latest, wantFrom: latest},
+		{name: "down", version: 0, wantFrom: latest},
+		{name: "up after down", version: latest, wantFrom: 0},
+	}
+	for _, step := range steps {
+		from, err := MigrateStorage(connURL, step.version)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if from != step.wantFrom {
+			t.Errorf("%s: migrated from %d, want %d", step.name, from, step.wantFrom)
+		}
+		if v := schemaVersion(t, connURL); v != step.version {
+			t.Errorf("%s: got version %d, want %d", step.name, v, step.version)
+		}
+	}
+	if _, err := MigrateStorage(connURL, latest+1); err == nil {
+		t.Error("got no error for an unknown version")
+	}
+}
@@ -4,31 +4,140 @@ import (
 	"time"
 )
 
+// Roles of users. Internal users and bots answer guests, messages of guests
+// and external users wait for an answer.
+const (
+	RoleInternal = "internal" // Full member of the organization
+	RoleBot      = "bot"
+	RoleGuest    = "guest"    // Single or multi-channel guest
+	RoleExternal = "external" // Member of another organization
+)
+
 // User represents Slack user
 type User struct {
 	ID       string
 	Name     string
 	FullName string
 	Email    string
+	TeamID   string // Slack workspace
+	Role     string // One of Role* constants, empty if not classified yet
+	// Account kinds Slack reports. They are used to classify the user and
+	// are not stored.
+	IsBot             bool `json:"-"`
+	IsRestricted      bool `json:"-"` // Multi-channel guest
+	IsUltraRestricted bool `json:"-"` // Single-channel guest
+	IsStranger        bool `json:"-"` // Member of another workspace of a shared channel
 }
 
 // Message represents Slack message
 type Message struct {
+	// TS is the Slack timestamp, it identifies the message within a channel
+	TS        string
 	UserID    string
 	ChannelID string
 	CreatedAt time.Time
+	EditedAt  time.Time // Zero if the message has never been edited
 	Text      string
 	Type      string
 	Name      string // For example for a new channel name
+	// ThreadTS is a timestamp of the thread parent message. It's empty for
+	// messages which are not in a thread.
+	ThreadTS     string
+	ParentUserID string // Author of the thread parent message
+	IsReply      bool   // The message is a reply in a thread
+	Reactions    []*Reaction
+	TeamID       string // Slack workspace
+}
+
+// Reaction represents an emoji reaction to a message
+type Reaction struct {
+	Name    string // Emoji name without colons, for example "eyes"
+	UserIDs []string
 }
 
+// Conversation types of Slack channels
+const (
+	ChannelTypePublic  = "public_channel"
+	ChannelTypePrivate = "private_channel"
+	ChannelTypeMPIM    = "mpim"
+	ChannelTypeIM      = "im"
+)
+
 // Channel represents Slack channel
 type Channel struct {
-	ID       string
-	Name     string
-	Ok       bool
-	Archived bool
-	Messages []*Message
+	ID        string
+	Name      string
+	Type      string // One of ChannelType* constants
+	Shared    bool   // Shared with other workspaces
+	ExtShared bool   // Shared with other organizations (Slack Connect)
+	Ok        bool
+	Archived  bool
+	TeamID    string // Slack workspace
+	// Calendar is the name of the business hours calendar of the channel.
+	// The calendar of the board applies if it's empty.
+	Calendar string
+	// SLA is how many seconds guests may wait for the first response, the
+	// default SLA applies if it's 0.
+	SLA uint
+	// WaitingSince is when the unanswered question of the channel was
+	// asked. It's zero if nobody waits.
+	WaitingSince time.Time
+	Breached     bool // The question has waited longer than SLA
+	// WaitStart is the time of the last message moved to the start of the
+	// next business hours. Boards are sorted by it.
+	WaitStart time.Time
+	Messages  []*Message
+}
+
+// Response is a question of a guest and the first response of an internal
+// user to it. A question is asked with a guest message when nobody waits for
+// an answer in the channel, later guest messages belong to it until an
+// internal user writes to the channel. Boards tell guests from internal
+// users by their own domains, so every board has its own questions.
+type Response struct {
+	Board       string
+	ChannelID   string
+	TS          string // Slack timestamp of the guest message
+	UserID      string // The guest
+	AskedAt     time.Time
+	ResponseTS  string // Slack timestamp of the response, empty if unanswered
+	ResponderID string
+	RespondedAt time.Time // Zero if unanswered
+}
+
+// Notification is the last alert sent about the unanswered question of a
+// channel. A channel has one at most.
+type Notification struct {
+	ChannelID string
+	AskedAt   time.Time // When the question was asked
+	SentAt    time.Time
+	Count     uint // How many alerts were sent about the question
+}
+
+// Webhook posts events of a board as JSON signed with HMAC-SHA256 of the
+// secret to a URL.
+type Webhook struct {
+	Name   string
+	URL    string
+	Secret string
+	Board  string   // The default board if empty
+	Events []string // WebhookEvent* types, all of them if empty
+}
+
+// Delivery is an event posted, or to be posted, to a webhook.
+type Delivery struct {
+	ID           int64
+	Webhook      string
+	Event        string // The event type
+	Payload      string // The event JSON
+	Status       string // Delivery* constants
+	Attempts     uint
+	ResponseCode int // The HTTP status of the last attempt, 0 if none
+	Error        string
+	CreatedAt    time.Time
+	// NextAttemptAt is when pending deliveries are attempted next
+	NextAttemptAt time.Time
+	DeliveredAt   time.Time // Zero if not delivered
 }
 
 // ChannelPair Contains bad and good channels
@@ -36,3 +145,34 @@ type ChannelPair struct {
 	Bad []*Channel
 	Ok  []*Channel
 }
+
+// Board is a named set of channels shown together. Channels with names
+// matching one of Include patterns and none of Exclude ones are on the
+// board as well as channels listed in Channels.
+type Board struct {
+	Name         string
+	Include      []string // Regular expressions of channel names
+	Exclude      []string
+	Channels     []string // IDs of channels which are always on the board
+	MessageLimit uint     // Number of last messages shown for every channel
+	Domains      []string // Email domains of internal users
+	// Teams are IDs of Slack workspaces. Channels of other workspaces are
+	// not on the board unless listed in Channels. The board spans all
+	// workspaces if it's empty.
+	Teams []string
+	// Calendar is the name of the business hours calendar of channels which
+	// have none. Channels are always open if it's empty.
+	Calendar string
+}
+
+// Calendar is business hours. Guests wait and SLA runs only during them.
+type Calendar struct {
+	Name     string
+	TimeZone string // IANA time zone, for example Europe/Berlin, UTC if empty
+	// Hours are like "Mon-Fri 09:00-17:00" or "Sat,Sun 10:00-14:00". The
+	// calendar is open all day every day if it's empty.
+	Hours []string
+	// Holidays is an iCalendar (RFC 5545) with all-day events, for example
+	// exported from a calendar app. Yearly events repeat every year.
+	Holidays string
+}
@@ -0,0 +1,108 @@
+package figaro
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MultiSource is a Source which combines several ones, for example Slacks
+// of several workspaces. Messages of a channel are fetched from the source
+// which returned the channel.
+type MultiSource struct {
+	sources   []Source
+	messageCh chan *Message
+
+	mu sync.Mutex
+	// owners contains sources of known channels by channel IDs
+	owners map[string]Source
+}
+
+var _ Source = (*MultiSource)(nil)
+
+// NewMultiSource combines the sources.
+func NewMultiSource(sources ...Source) *MultiSource {
+	s := &MultiSource{
+		sources:   sources,
+		messageCh: make(chan *Message),
+		owners:    make(map[string]Source),
+	}
+	for _, source := range sources {
+		go func(source Source) {
+			for msg := range source.MessageCh() {
+				s.messageCh <- msg
+			}
+		}(source)
+	}
+	return s
+}
+
+// MessageCh returns messages of all sources.
+func (s *MultiSource) MessageCh() <-chan *Message {
+	return s.messageCh
+}
+
+// GetUsers returns users of all sources.
+func (s *MultiSource) GetUsers() ([]*User, error) {
+	var users []*User
+	for _, source := range s.sources {
+		sourceUsers, err := source.GetUsers()
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, sourceUsers...)
+	}
+	return users, nil
+}
+
+// GetChannels returns channels of all sources. A channel shared between
+// workspaces belongs to the first source which returned it.
+func (s *MultiSource) GetChannels() ([]*Channel, error) {
+	var channels []*Channel
+	owners := make(map[string]Source)
+	for _, source := range s.sources {
+		sourceChannels, err := source.GetChannels()
+		if err != nil {
+			return nil, err
+		}
+		for _, channel := range sourceChannels {
+			if _, ok := owners[channel.ID]; ok {
+				continue
+			}
+			owners[channel.ID] = source
+			channels = append(channels, channel)
+		}
+	}
+	s.mu.Lock()
+	s.owners = owners
+	s.mu.Unlock()
+	return channels, nil
+}
+
+// GetMessages gets messages of the channel from its source.
+func (s *MultiSource) GetMessages(chID string, ts time.Time, process ProcMsgs) error {
+	source, err := s.owner(chID)
+	if err != nil {
+		return err
+	}
+	return source.GetMessages(chID, ts, process)
+}
+
+// GetMembers gets members of the channel from its source.
+func (s *MultiSource) GetMembers(chID string) ([]string, error) {
+	source, err := s.owner(chID)
+	if err != nil {
+		return nil, err
+	}
+	return source.GetMembers(chID)
+}
+
+func (s *MultiSource) owner(chID string) (Source, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	source, ok := s.owners[chID]
+	if !ok {
+		return nil, fmt.Errorf("unknown channel %s", chID)
+	}
+	return source, nil
+}
@@ -0,0 +1,114 @@
+package figaro
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMultiSource(t *testing.T) {
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	// workspaceSource returns a source of the workspace with a channel of
+	// its own and a channel shared with the other workspace.
+	workspaceSource := func(teamID, userID, chID string) *ScriptedSource {
+		own := &Message{ChannelID: chID, UserID: userID, CreatedAt: t0,
+			Text: "own", TeamID: teamID}
+		shared := &Message{ChannelID: "CSHARED", UserID: userID, CreatedAt: t0,
+			Text: "shared", TeamID: teamID}
+		s := NewScriptedSource(
+			[]*User{{ID: userID, Name: userID, TeamID: teamID}},
+			[]*Channel{{ID: chID, Name: chID, TeamID: teamID},
+				{ID: "CSHARED", Name: "shared", TeamID: teamID}},
+			[]*Message{own, shared})
+		s.SetMembers(chID, []string{userID})
+		return s
+	}
+	s1 := workspaceSource("T1", "U1", "C1")
+	s2 := workspaceSource("T2", "U2", "C2")
+	s := NewMultiSource(s1, s2)
+
+	users, err := s.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userTeams []string
+	for _, user := range users {
+		userTeams = append(userTeams, user.ID+"@"+user.TeamID)
+	}
+	if want := []string{"U1@T1", "U2@T2"}; !reflect.DeepEqual(userTeams, want) {
+		t.Errorf("users: got %q, want %q", userTeams, want)
+	}
+
+	channels, err := s.GetChannels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var channelTeams []string
+	for _, channel := range channels {
+		channelTeams = append(channelTeams, channel.ID+"@"+channel.TeamID)
+	}
+	sort.Strings(channelTeams)
+	if want := []string{"C1@T1", "C2@T2", "CSHARED@T1"}; !reflect.DeepEqual(channelTeams, want) {
+		t.Errorf("channels: got %q, want %q", channelTeams, want)
+	}
+
+	// History comes from the source which owns the channel
+	tests := []struct {
+		chID        string
+		wantTeamID  string
+		wantMembers []string
+	}{
+		{chID: "C1", wantTeamID: "T1", wantMembers: []string{"U1"}},
+		{chID: "C2", wantTeamID: "T2", wantMembers: []string{"U2"}},
+		{chID: "CSHARED", wantTeamID: "T1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.chID, func(t *testing.T) {
+			var history []*Message
+			err := s.GetMessages(tt.chID, t0.Add(-time.Hour), func(messages []*Message) error {
+				history = append(history, messages...)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history) != 1 {
+				t.Fatalf("got %d messages, want 1", len(history))
+			}
+			if history[0].ChannelID != tt.chID || history[0].TeamID != tt.wantTeamID {
+				t.Errorf("got message of %s@%s, want %s@%s", history[0].ChannelID,
+					history[0].TeamID, tt.chID, tt.wantTeamID)
+			}
+			members, err := s.GetMembers(tt.chID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(members, tt.wantMembers) {
+				t.Errorf("got members %q, want %q", members, tt.wantMembers)
+			}
+		})
+	}
+	if _, err := s.GetMembers("CUNKNOWN"); err == nil {
+		t.Error("got no error for an unknown channel")
+	}
+
+	// Events of both workspaces come on the same channel
+	go s2.Emit(&Message{ChannelID: "C2", UserID: "U2", CreatedAt: t0.Add(time.Minute),
+		Text: "event", TeamID: "T2"})
+	go s1.Emit(&Message{ChannelID: "C1", UserID: "U1", CreatedAt: t0.Add(time.Minute),
+		Text: "event", TeamID: "T1"})
+	var eventTeams []string
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-s.MessageCh():
+			eventTeams = append(eventTeams, m.ChannelID+"@"+m.TeamID)
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+		}
+	}
+	sort.Strings(eventTeams)
+	if want := []string{"C1@T1", "C2@T2"}; !reflect.DeepEqual(eventTeams, want) {
+		t.Errorf("events: got %q, want %q", eventTeams, want)
+	}
+}
@@ -1,67 +1,268 @@
 package figaro
 
 import (
-	"context"
-	"github.com/gorilla/websocket"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// clientQueueSize is a number of payloads buffered for every client.
+	clientQueueSize = 64
+	// pushLogSize is a number of the last payloads kept for clients which
+	// reconnect.
+	pushLogSize  = 1024
+	writeTimeout = 30 * time.Second
+	// heartbeatInterval is how often SSE clients get a comment which keeps
+	// proxies from closing idle connections.
+	heartbeatInterval = 15 * time.Second
 )
 
+// Push is a payload with its sequence number. Sequence numbers of pushes
+// grow by one.
+type Push struct {
+	Seq uint64
+	// ChannelID is the channel the payload is about. Only users who see it
+	// get the payload.
+	ChannelID string
+	// UserID is the only user who gets the payload if it's not empty.
+	UserID string
+	Data   []byte
+}
+
+// client is a queue of a connected client of the user. If resume is set,
+// the client has seen payloads up to since.
+type client struct {
+	ch     chan *Push
+	userID string
+	resume bool
+	since  uint64
+}
+
 // PushService responsible for push notifications
 type PushService struct {
 	upgrader websocket.Upgrader
-	in       chan []byte
-	outs     map[chan []byte]struct{}
-	addCh    chan chan []byte
-	removeCh chan chan []byte
+	epoch    string
+	in       chan *Push
+	log      []*Push
+	lastSeq  uint64
+	outs     map[*client]struct{}
+	addCh    chan *client
+	removeCh chan *client
+	done     chan struct{}
+
+	mu       sync.Mutex
+	snapshot func(userID string) *Push
+	visible  func(userID, chID string) bool
 }
 
-// NewPushService creates and launches push service on the specified address
-func NewPushService() *PushService {
+// NewPushService creates and launches push service on the specified address.
+// WebSocket connections are accepted from the same origin and the origins,
+// for example https://figaro.example.com.
+func NewPushService(origins []string) *PushService {
 	p := &PushService{}
-	p.upgrader.CheckOrigin = func(r *http.Request) bool { return true }
-	p.in = make(chan []byte)
-	p.outs = make(map[chan []byte]struct{})
-	p.addCh = make(chan chan []byte)
-	p.removeCh = make(chan chan []byte)
+	p.upgrader.CheckOrigin = checkOrigin(origins)
+	p.epoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+	p.in = make(chan *Push)
+	p.outs = make(map[*client]struct{})
+	p.addCh = make(chan *client)
+	p.removeCh = make(chan *client)
+	p.done = make(chan struct{})
 	go p.serve()
 	log.Println("Push service started")
 	return p
 }
 
+// checkOrigin returns a function which accepts requests from the same
+// origin, from the origins and from non-browser clients which don't send
+// Origin. Browsers send cookies with WebSocket upgrades from any site, so
+// other origins must be rejected.
+func checkOrigin(origins []string) func(r *http.Request) bool {
+	allowed := make(map[string]bool)
+	for _, origin := range origins {
+		allowed[strings.TrimSuffix(origin, "/")] = true
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || allowed[origin] {
+			return true
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(u.Host, r.Host)
+	}
+}
+
+// sibling creates another push service which accepts the same origins.
+func (p *PushService) sibling() *PushService {
+	sibling := NewPushService(nil)
+	sibling.upgrader.CheckOrigin = p.upgrader.CheckOrigin
+	return sibling
+}
+
+// Close disconnects all clients and stops the service. Nothing may be sent
+// to In after it.
+func (p *PushService) Close() {
+	close(p.done)
+}
+
+// Epoch identifies sequence numbers of this push service. Sequence numbers
+// of different epochs are unrelated.
+func (p *PushService) Epoch() string {
+	return p.epoch
+}
+
 // In channel
-func (p *PushService) In() chan<- []byte {
+func (p *PushService) In() chan<- *Push {
 	return p.in
 }
 
+// SetSnapshot sets the function which returns the current state the user
+// sees as a payload with the sequence number of the last push it includes.
+// Clients get it when they connect and when they fall behind.
+func (p *PushService) SetSnapshot(snapshot func(userID string) *Push) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.snapshot = snapshot
+}
+
+// SetVisible sets the function which tells if the user sees the channel.
+// Clients get only pushes about channels their users see. All of them are
+// visible by default.
+func (p *PushService) SetVisible(visible func(userID, chID string) bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.visible = visible
+}
+
+func (p *PushService) currentSnapshot(userID string) *Push {
+	p.mu.Lock()
+	snapshot := p.snapshot
+	p.mu.Unlock()
+	if snapshot == nil {
+		return nil
+	}
+	return snapshot(userID)
+}
+
+// isFor tells if the push should be sent to the client.
+func (p *PushService) isFor(push *Push, c *client) bool {
+	if push.UserID != "" {
+		return push.UserID == c.userID
+	}
+	p.mu.Lock()
+	visible := p.visible
+	p.mu.Unlock()
+	return push.ChannelID == "" || visible == nil || visible(c.userID, push.ChannelID)
+}
+
 func (p *PushService) serve() {
-	var cancel context.CancelFunc = func() {}
 	for {
 		select {
-		case data := <-p.in:
-			cancel()
-			var ctx context.Context
-			ctx, cancel = context.WithCancel(context.Background())
-			for ch := range p.outs {
-				go func(ch chan []byte) {
-					select {
-					case ch <- data:
-					case <-ctx.Done():
-						return
-					}
-				}(ch)
+		case push := <-p.in:
+			p.lastSeq = push.Seq
+			p.log = append(p.log, push)
+			if len(p.log) > pushLogSize {
+				p.log = p.log[len(p.log)-pushLogSize:]
 			}
-		case ch := <-p.addCh:
-			p.outs[ch] = struct{}{}
-		case ch := <-p.removeCh:
-			delete(p.outs, ch)
+			for c := range p.outs {
+				if p.isFor(push, c) {
+					p.enqueue(c, push)
+				}
+			}
+		case c := <-p.addCh:
+			p.outs[c] = struct{}{}
+			p.catchUp(c)
+		case c := <-p.removeCh:
+			delete(p.outs, c)
+		case <-p.done:
+			return
 		}
 	}
 }
 
+// catchUp sends a newly connected client the payloads it missed or the
+// current snapshot if they aren't in the log anymore. Sequence numbers of
+// the payloads a client gets may have gaps, the skipped ones are for other
+// users.
+func (p *PushService) catchUp(c *client) {
+	if c.resume && c.since == p.lastSeq {
+		return
+	}
+	if c.resume && c.since < p.lastSeq && len(p.log) > 0 && c.since+1 >= p.log[0].Seq {
+		for _, push := range p.log {
+			if push.Seq > c.since && p.isFor(push, c) {
+				p.enqueue(c, push)
+			}
+		}
+		return
+	}
+	if snapshot := p.currentSnapshot(c.userID); snapshot != nil {
+		p.enqueue(c, snapshot)
+	}
+}
+
+// enqueue puts a push to the client queue without blocking. Pushes are
+// changes which can't be dropped, so if the queue is full, enqueue replaces
+// its content with the current snapshot which includes them.
+func (p *PushService) enqueue(c *client, push *Push) {
+	select {
+	case c.ch <- push:
+		return
+	default:
+	}
+	for len(c.ch) > 0 {
+		select {
+		case <-c.ch:
+		default:
+		}
+	}
+	if snapshot := p.currentSnapshot(c.userID); snapshot != nil {
+		c.ch <- snapshot
+	} else {
+		c.ch <- push
+	}
+}
+
+// subscribe adds a client of the user which resumes from the since sequence
+// number of the epoch if since is not empty. The client must be
+// unsubscribed.
+func (p *PushService) subscribe(userID, epoch, since string) *client {
+	c := &client{ch: make(chan *Push, clientQueueSize), userID: userID}
+	if epoch == p.epoch && since != "" {
+		if seq, err := strconv.ParseUint(since, 10, 64); err == nil {
+			c.resume = true
+			c.since = seq
+		}
+	}
+	select {
+	case p.addCh <- c:
+	case <-p.done:
+	}
+	return c
+}
+
+func (p *PushService) unsubscribe(c *client) {
+	select {
+	case p.removeCh <- c:
+	case <-p.done:
+	}
+}
+
 // Handler handels http requests. It upgrades HTTP request to WS connection and
-// serves it.
+// serves it. Clients which reconnect pass ?epoch=E&since=N to get only
+// payloads after N. The signed in user gets only payloads about channels
+// they see.
 func (p *PushService) Handler(w http.ResponseWriter, r *http.Request) {
 	conn, err := p.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -69,16 +270,80 @@ func (p *PushService) Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer conn.Close()
-	conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
-	in := make(chan []byte)
-	p.addCh <- in
-	defer func() { p.removeCh <- in }()
+	q := r.URL.Query()
+	c := p.subscribe(viewerID(r), q.Get("epoch"), q.Get("since"))
+	defer p.unsubscribe(c)
+	// Read and discard everything to notice when the client goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
 	for {
-		msg := <-in
-		err = conn.WriteMessage(websocket.TextMessage, msg)
+		select {
+		case push := <-c.ch:
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			err = conn.WriteMessage(websocket.TextMessage, push.Data)
+			if err != nil {
+				log.Println("Cannot write to the WS:", err)
+				return
+			}
+		case <-closed:
+			return
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// SSEHandler serves the same payloads as Handler as Server-Sent Events for
+// clients behind proxies which don't pass WebSocket. Event IDs look like
+// E:N, clients which reconnect pass the last one in the Last-Event-ID header
+// or ?epoch=E&since=N to get only payloads after N.
+func (p *PushService) SSEHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	q := r.URL.Query()
+	epoch, since := q.Get("epoch"), q.Get("since")
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		if i := strings.LastIndex(id, ":"); i >= 0 {
+			epoch, since = id[:i], id[i+1:]
+		}
+	}
+	c := p.subscribe(viewerID(r), epoch, since)
+	defer p.unsubscribe(c)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Keeps nginx from buffering events
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case push := <-c.ch:
+			_, err = fmt.Fprintf(w, "id: %s:%d\ndata: %s\n\n", p.epoch, push.Seq, push.Data)
+		case <-heartbeat.C:
+			_, err = io.WriteString(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		case <-p.done:
+			return
+		}
 		if err != nil {
-			log.Println("Cannot write to the WS:", err)
+			log.Println("Cannot write to the SSE stream:", err)
 			return
 		}
+		flusher.Flush()
 	}
 }
@@ -0,0 +1,178 @@
+package figaro
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestPushService returns a push service whose snapshot is "snapshot" and
+// its WebSocket server.
+func newTestPushService(t *testing.T) (*PushService, *httptest.Server) {
+	p := NewPushService(nil)
+	p.SetSnapshot(func(userID string) *Push {
+		return &Push{Data: []byte("snapshot")}
+	})
+	srv := httptest.NewServer(http.HandlerFunc(p.Handler))
+	t.Cleanup(func() {
+		srv.Close()
+		p.Close()
+	})
+	return p, srv
+}
+
+// dialPushes connects to the push service server with the query.
+func dialPushes(t *testing.T, srv *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/?" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readPush returns the next payload the connection gets.
+func readPush(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// seqs returns payloads of pushes from..to.
+func seqs(from, to int) []string {
+	var payloads []string
+	for seq := from; seq <= to; seq++ {
+		payloads = append(payloads, strconv.Itoa(seq))
+	}
+	return payloads
+}
+
+func TestPushServiceCatchUp(t *testing.T) {
+	rolled := pushLogSize + 10 // The log starts at 11
+	tests := []struct {
+		name   string
+		pushes int // Sent before the client connects
+		query  string
+		want   []string
+	}{
+		{name: "new client", pushes: 5, want: []string{"snapshot"}},
+		{name: "resume", pushes: 5, query: "since=3", want: seqs(4, 5)},
+		{name: "up to date", pushes: 5, query: "since=5"},
+		{name: "other epoch", pushes: 5, query: "epoch=other&since=3", want: []string{"snapshot"}},
+		{name: "resume after the log rolled over", pushes: rolled,
+			query: "since=" + strconv.Itoa(rolled-3), want: seqs(rolled-2, rolled)},
+		{name: "missed pushes rolled over", pushes: rolled, query: "since=9",
+			want: []string{"snapshot"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, srv := newTestPushService(t)
+			for seq := 1; seq <= tt.pushes; seq++ {
+				p.In() <- &Push{Seq: uint64(seq), Data: []byte(strconv.Itoa(seq))}
+			}
+			query := tt.query
+			if strings.HasPrefix(query, "since=") {
+				query = "epoch=" + p.Epoch() + "&" + query
+			}
+			conn := dialPushes(t, srv, query)
+			for _, want := range tt.want {
+				if got := readPush(t, conn); got != want {
+					t.Fatalf("got %q, want %q", got, want)
+				}
+			}
+			// Nothing else comes before the next push
+			next := strconv.Itoa(tt.pushes + 1)
+			p.In() <- &Push{Seq: uint64(tt.pushes + 1), Data: []byte(next)}
+			if got := readPush(t, conn); got != next {
+				t.Errorf("got %q, want %q", got, next)
+			}
+		})
+	}
+}
+
+func TestPushServiceSlowClient(t *testing.T) {
+	p, srv := newTestPushService(t)
+	snapshots := make(chan struct{}, 1)
+	p.SetSnapshot(func(userID string) *Push {
+		select {
+		case snapshots <- struct{}{}:
+		default:
+		}
+		return &Push{Data: []byte("snapshot")}
+	})
+	conn := dialPushes(t, srv, "epoch="+p.Epoch()+"&since=0")
+	// The client doesn't read, so large pushes fill the socket buffers and
+	// then its queue
+	data := bytes.Repeat([]byte("x"), 256<<10)
+	seq := 0
+	for sent := false; !sent; {
+		seq++
+		if seq > 10000 {
+			t.Fatal("the queue of the client never filled up")
+		}
+		p.In() <- &Push{Seq: uint64(seq), Data: data}
+		select {
+		case <-snapshots:
+			sent = true
+		default:
+		}
+	}
+	seq++
+	p.In() <- &Push{Seq: uint64(seq), Data: []byte("last")}
+	// The client gets what the sockets kept, the snapshot which replaced
+	// its queue and the pushes after it
+	large, snapshot := 0, false
+	for {
+		data := readPush(t, conn)
+		if data == "last" {
+			break
+		}
+		if data == "snapshot" {
+			snapshot = true
+		} else {
+			large++
+		}
+	}
+	if !snapshot || large >= seq-1 {
+		t.Errorf("got %d of %d pushes and snapshot %v, want the snapshot instead of some",
+			large, seq-1, snapshot)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	check := checkOrigin([]string{"https://app.corp.com/"})
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "", want: true},
+		{origin: "https://figaro.corp.com", want: true},
+		{origin: "https://FIGARO.corp.com", want: true},
+		{origin: "https://app.corp.com", want: true},
+		{origin: "https://evil.com"},
+		{origin: "https://figaro.corp.com.evil.com"},
+		{origin: "http://app.corp.com"},
+		{origin: "::"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "https://figaro.corp.com/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := check(r); got != tt.want {
+			t.Errorf("origin %q: got %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package figaro
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Report periods
+const (
+	ReportDay  = "day"
+	ReportWeek = "week" // Weeks start on Monday
+)
+
+// defaultReportDays is how many days back a report goes by default.
+const defaultReportDays = 30
+
+// ReportQuery selects what a report covers.
+type ReportQuery struct {
+	// Board tells guests from internal users with its domains and has the
+	// questions, the default board if empty.
+	Board     string
+	ChannelID string // All channels if empty
+	From, To  time.Time
+	Period    string         // ReportDay or ReportWeek
+	Location  *time.Location // Where days start, UTC if nil
+	// Calendar is the name of the calendar of channels which have none.
+	// Response times count only business hours of calendars.
+	Calendar string
+}
+
+// ReportRow is statistics of a channel for a day or a week.
+type ReportRow struct {
+	ChannelID        string
+	ChannelName      string
+	Start            time.Time // Start of the period
+	GuestMessages    uint
+	InternalMessages uint
+	Questions        uint // Questions of guests asked in the period
+	Answered         uint
+	// MedianResponse and P90Response are first response times of the
+	// answered questions in seconds of business hours. They are 0 if none
+	// was answered.
+	MedianResponse float64
+	P90Response    float64
+	// Unanswered are questions asked in the period which were not answered
+	// by the end of the report.
+	Unanswered uint
+}
+
+// Reporter computes reports of the history kept in the storage. Messages of
+// users are counted by their stored roles.
+type Reporter struct {
+	st      Store
+	domains []string // Domains of the default board
+	roles   *roles
+}
+
+// NewReporter creates a reporter which treats users with emails in domains
+// of the board, domains for the default one, and users classified as
+// internal as internal ones. Only Deny of rolesConf matters, roles are
+// classified when users are stored.
+func NewReporter(st Store, domains []string, rolesConf RoleConfig) *Reporter {
+	return &Reporter{st: st, domains: domains, roles: newRoles(rolesConf, domains)}
+}
+
+// ParseReportQuery parses a query from the values:
+//
+//	board    - board, the default one by default
+//	channel  - channel ID, all channels by default
+//	from     - the start, 2006-01-02 or RFC 3339, 30 days before to by default
+//	to       - the end, now by default
+//	period   - day (default) or week
+//	tz       - time zone of days, for example Europe/Berlin, UTC by default
+//	calendar - calendar of channels which have none, always open by default
+func ParseReportQuery(values url.Values) (ReportQuery, error) {
+	q := ReportQuery{
+		Board:     values.Get("board"),
+		ChannelID: values.Get("channel"),
+		Period:    values.Get("period"),
+		Location:  time.UTC,
+		Calendar:  values.Get("calendar"),
+	}
+	if q.Period == "" {
+		q.Period = ReportDay
+	}
+	if q.Period != ReportDay && q.Period != ReportWeek {
+		return q, fmt.Errorf("invalid period %q, use day or week", q.Period)
+	}
+	if tz := values.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return q, fmt.Errorf("invalid tz: %v", err)
+		}
+		q.Location = loc
+	}
+	q.To = time.Now()
+	if s := values.Get("to"); s != "" {
+		t, err := parseReportTime(s, q.Location)
+		if err != nil {
+			return q, fmt.Errorf("invalid to: %v", err)
+		}
+		q.To = t
+	}
+	q.From = q.To.AddDate(0, 0, -defaultReportDays)
+	if s := values.Get("from"); s != "" {
+		t, err := parseReportTime(s, q.Location)
+		if err != nil {
+			return q, fmt.Errorf("invalid from: %v", err)
+		}
+		q.From = t
+	}
+	if !q.From.Before(q.To) {
+		return q, fmt.Errorf("from must be before to")
+	}
+	return q, nil
+}
+
+// parseReportTime parses a date, which starts in loc, or an RFC 3339 time.
+func parseReportTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, loc); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+type reportKey struct {
+	chID  string
+	start time.Time
+}
+
+// Report returns rows of channels which had messages or questions in the
+// query period sorted by channel name and period start.
+func (r *Reporter) Report(q ReportQuery) ([]*ReportRow, error) {
+	if q.Period != ReportDay && q.Period != ReportWeek {
+		return nil, fmt.Errorf("unknown report period %q", q.Period)
+	}
+	if q.Location == nil {
+		q.Location = time.UTC
+	}
+	if q.Board == "" {
+		q.Board = DefaultBoard
+	}
+	domains, err := r.boardDomains(q.Board)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := r.st.GetMessagesBetween(q.ChannelID, q.From, q.To)
+	if err != nil {
+		return nil, err
+	}
+	responses, err := r.st.GetResponses(q.Board, q.ChannelID, q.From, q.To)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.UserID)
+	}
+	users, err := r.st.GetUsers(ids)
+	if err != nil {
+		return nil, err
+	}
+	idToUser := make(map[string]*User)
+	for _, user := range users {
+		idToUser[user.ID] = user
+	}
+	calendars, err := r.calendars()
+	if err != nil {
+		return nil, err
+	}
+	if q.Calendar != "" && calendars[q.Calendar] == nil {
+		return nil, ErrNoCalendar
+	}
+	idToChannel := make(map[string]*Channel)
+	channel := func(chID string) (*Channel, error) {
+		if ch, ok := idToChannel[chID]; ok {
+			return ch, nil
+		}
+		ch, err := r.st.GetChannel(chID)
+		if err == sql.ErrNoRows {
+			ch, err = &Channel{ID: chID}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		idToChannel[chID] = ch
+		return ch, nil
+	}
+	rows := make(map[reportKey]*ReportRow)
+	times := make(map[reportKey][]float64)
+	row := func(chID string, t time.Time) *ReportRow {
+		key := reportKey{chID, periodStart(t, q.Period, q.Location)}
+		if rows[key] == nil {
+			rows[key] = &ReportRow{ChannelID: chID, Start: key.start}
+		}
+		return rows[key]
+	}
+	for _, m := range messages {
+		user := idToUser[m.UserID]
+		if m.UserID == "" || user != nil && user.Role == RoleBot {
+			continue
+		}
+		if r.roles.isInternal(user, domains) {
+			row(m.ChannelID, m.CreatedAt).InternalMessages++
+		} else {
+			row(m.ChannelID, m.CreatedAt).GuestMessages++
+		}
+	}
+	for _, resp := range responses {
+		rr := row(resp.ChannelID, resp.AskedAt)
+		rr.Questions++
+		if resp.RespondedAt.IsZero() || resp.RespondedAt.After(q.To) {
+			rr.Unanswered++
+			continue
+		}
+		rr.Answered++
+		ch, err := channel(resp.ChannelID)
+		if err != nil {
+			return nil, err
+		}
+		name := ch.Calendar
+		if name == "" {
+			name = q.Calendar
+		}
+		key := reportKey{rr.ChannelID, rr.Start}
+		times[key] = append(times[key],
+			calendars[name].elapsed(resp.AskedAt, resp.RespondedAt).Seconds())
+	}
+	report := make([]*ReportRow, 0, len(rows))
+	for key, rr := range rows {
+		ch, err := channel(key.chID)
+		if err != nil {
+			return nil, err
+		}
+		rr.ChannelName = ch.Name
+		rr.MedianResponse = percentile(times[key], 50)
+		rr.P90Response = percentile(times[key], 90)
+		report = append(report, rr)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].ChannelName != report[j].ChannelName {
+			return report[i].ChannelName < report[j].ChannelName
+		}
+		if report[i].ChannelID != report[j].ChannelID {
+			return report[i].ChannelID < report[j].ChannelID
+		}
+		return report[i].Start.Before(report[j].Start)
+	})
+	return report, nil
+}
+
+// boardDomains returns domains of the board or ErrNoBoard. Boards other
+// than the default one are kept in the storage.
+func (r *Reporter) boardDomains(name string) ([]string, error) {
+	if name == DefaultBoard {
+		return r.domains, nil
+	}
+	boards, err := r.st.GetBoards()
+	if err != nil {
+		return nil, err
+	}
+	for _, board := range boards {
+		if board.Name == name {
+			return board.Domains, nil
+		}
+	}
+	return nil, ErrNoBoard
+}
+
+// calendars returns valid calendars kept in the storage by name.
+func (r *Reporter) calendars() (map[string]*calendar, error) {
+	confs, err := r.st.GetCalendars()
+	if err != nil {
+		return nil, err
+	}
+	calendars := make(map[string]*calendar)
+	for _, conf := range confs {
+		if c, err := compileCalendar(conf); err == nil {
+			calendars[conf.Name] = c
+		}
+	}
+	return calendars, nil
+}
+
+// periodStart returns the start of the day or the week of t in loc.
+func periodStart(t time.Time, period string, loc *time.Location) time.Time {
+	t = t.In(loc)
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	if period == ReportWeek {
+		// Monday is the first day
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+	}
+	return start
+}
+
+// percentile returns the p-th percentile of values with the nearest rank
+// method or 0 if there are no values.
+func percentile(values []float64, p int) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// WriteReportCSV writes the report as CSV with a header.
+func WriteReportCSV(w io.Writer, report []*ReportRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"channel_id", "channel_name", "start",
+		"guest_messages", "internal_messages", "questions", "answered",
+		"median_response_seconds", "p90_response_seconds", "unanswered"})
+	for _, rr := range report {
+		cw.Write([]string{
+			rr.ChannelID,
+			rr.ChannelName,
+			rr.Start.Format(time.RFC3339),
+			strconv.FormatUint(uint64(rr.GuestMessages), 10),
+			strconv.FormatUint(uint64(rr.InternalMessages), 10),
+			strconv.FormatUint(uint64(rr.Questions), 10),
+			strconv.FormatUint(uint64(rr.Answered), 10),
+			strconv.FormatFloat(rr.MedianResponse, 'f', 0, 64),
+			strconv.FormatFloat(rr.P90Response, 'f', 0, 64),
+			strconv.FormatUint(uint64(rr.Unanswered), 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
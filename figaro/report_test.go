@@ -0,0 +1,179 @@
+package figaro
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		p      int
+		want   float64
+	}{
+		{name: "no values", p: 50},
+		{name: "one value median", values: []float64{7}, p: 50, want: 7},
+		{name: "one value p90", values: []float64{7}, p: 90, want: 7},
+		{name: "p0", values: []float64{3, 1, 2}, p: 0, want: 1},
+		{name: "unsorted median", values: []float64{3, 1, 2}, p: 50, want: 2},
+		{name: "even median", values: []float64{4, 1, 3, 2}, p: 50, want: 2},
+		{name: "p90 of ten", values: []float64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, p: 90, want: 9},
+		{name: "p90 of two", values: []float64{1, 2}, p: 90, want: 2},
+		{name: "p100", values: []float64{1, 2, 3}, p: 100, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := append([]float64(nil), tt.values...)
+			if got := percentile(tt.values, tt.p); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.values, values) {
+				t.Errorf("values changed to %v", tt.values)
+			}
+		})
+	}
+}
+
+func TestParseReportQuery(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	to := time.Date(2020, 3, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		values  url.Values
+		want    ReportQuery
+		wantErr bool
+	}{
+		{name: "defaults", values: url.Values{"to": {"2020-03-31"}},
+			want: ReportQuery{From: to.AddDate(0, 0, -defaultReportDays), To: to,
+				Period: ReportDay, Location: time.UTC}},
+		{name: "all", values: url.Values{"board": {"sales"}, "channel": {"C1"},
+			"from": {"2020-03-01"}, "to": {"2020-03-31T12:00:00Z"}, "period": {"week"},
+			"tz": {"Europe/Berlin"}, "calendar": {"office"}},
+			want: ReportQuery{Board: "sales", ChannelID: "C1",
+				From: time.Date(2020, 3, 1, 0, 0, 0, 0, berlin),
+				To:   time.Date(2020, 3, 31, 12, 0, 0, 0, time.UTC), Period: ReportWeek,
+				Location: berlin, Calendar: "office"}},
+		{name: "invalid period", values: url.Values{"period": {"month"}}, wantErr: true},
+		{name: "invalid tz", values: url.Values{"tz": {"Mars/Olympus"}}, wantErr: true},
+		{name: "invalid from", values: url.Values{"from": {"March"}}, wantErr: true},
+		{name: "from after to", values: url.Values{"from": {"2020-03-31"}, "to": {"2020-03-01"}},
+			wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseReportQuery(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.From.Equal(tt.want.From) || !got.To.Equal(tt.want.To) {
+				t.Errorf("got %v - %v, want %v - %v", got.From, got.To, tt.want.From, tt.want.To)
+			}
+			got.From, got.To, tt.want.From, tt.want.To = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportPeriods(t *testing.T) {
+	wed := time.Date(2020, 3, 4, 10, 0, 0, 0, time.UTC)
+	mon := time.Date(2020, 3, 9, 9, 0, 0, 0, time.UTC)
+	st := NewMemStorage()
+	// Figaro classifies users when it stores them
+	users := testUsers()
+	r := newRoles(RoleConfig{}, []string{"corp.com"})
+	for _, user := range users {
+		user.Role = r.classify(user)
+	}
+	if err := st.UpdateUsers(users); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpdateChannels([]*Channel{{ID: "C1", Name: "one"}}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := st.UpdateMessages([]*Message{
+		testMessage("UGUEST", wed, "question"),
+		testMessage("UINT", wed.Add(30*time.Minute), "answer"),
+		testMessage("UBOT", wed.Add(time.Hour), "beep"),
+		testMessage("UGUEST", mon, "another question"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.ReplaceResponses(DefaultBoard, "C1", wed, []*Response{
+		{Board: DefaultBoard, ChannelID: "C1", TS: timeToStr(wed), UserID: "UGUEST",
+			AskedAt: wed, ResponseTS: timeToStr(wed.Add(30 * time.Minute)),
+			ResponderID: "UINT", RespondedAt: wed.Add(30 * time.Minute)},
+		{Board: DefaultBoard, ChannelID: "C1", TS: timeToStr(mon), UserID: "UGUEST",
+			AskedAt: mon},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	answered := ReportRow{ChannelID: "C1", ChannelName: "one", GuestMessages: 1,
+		InternalMessages: 1, Questions: 1, Answered: 1, MedianResponse: 1800,
+		P90Response: 1800}
+	unanswered := ReportRow{ChannelID: "C1", ChannelName: "one", GuestMessages: 1,
+		Questions: 1, Unanswered: 1}
+	// The report starts in the middle of the day and of the week
+	tests := []struct {
+		period     string
+		wantStarts []time.Time
+	}{
+		{ReportDay, []time.Time{
+			time.Date(2020, 3, 4, 0, 0, 0, 0, time.UTC),
+			time.Date(2020, 3, 9, 0, 0, 0, 0, time.UTC)}},
+		{ReportWeek, []time.Time{
+			time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC),
+			time.Date(2020, 3, 9, 0, 0, 0, 0, time.UTC)}},
+	}
+	reporter := NewReporter(st, []string{"corp.com"}, RoleConfig{})
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			report, err := reporter.Report(ReportQuery{From: wed.Add(-time.Hour),
+				To: mon.Add(time.Hour), Period: tt.period})
+			if err != nil {
+				t.Fatal(err)
+			}
+			first, second := answered, unanswered
+			first.Start, second.Start = tt.wantStarts[0], tt.wantStarts[1]
+			want := []ReportRow{first, second}
+			if len(report) != len(want) {
+				t.Fatalf("got %d rows, want %d", len(report), len(want))
+			}
+			for i := range want {
+				if !reflect.DeepEqual(*report[i], want[i]) {
+					t.Errorf("row %d: got %+v, want %+v", i, *report[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWriteReportCSV(t *testing.T) {
+	report := []*ReportRow{{ChannelID: "C1", ChannelName: "one, two",
+		Start: time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC), GuestMessages: 3,
+		InternalMessages: 2, Questions: 2, Answered: 1, MedianResponse: 1800.4,
+		P90Response: 3599.6, Unanswered: 1}}
+	var b strings.Builder
+	if err := WriteReportCSV(&b, report); err != nil {
+		t.Fatal(err)
+	}
+	want := "channel_id,channel_name,start,guest_messages,internal_messages," +
+		"questions,answered,median_response_seconds,p90_response_seconds,unanswered\n" +
+		`C1,"one, two",2020-03-02T00:00:00Z,3,2,2,1,1800,3600,1` + "\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
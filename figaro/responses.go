@@ -0,0 +1,223 @@
+package figaro
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// updateResponses derives questions of the channel on the board and first
+// responses to them from messages created at or after since. The question
+// which was open at since is derived again as well.
+func (f *Figaro) updateResponses(sb *servedBoard, chID string, since time.Time) error {
+	last, err := f.st.GetResponseAt(sb.conf.Name, chID, since)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	case last.RespondedAt.IsZero() || !last.RespondedAt.Before(since):
+		since = last.AskedAt
+	}
+	messages, err := f.st.GetMessagesSince(chID, since)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.UserID)
+	}
+	users, err := f.st.GetUsers(ids)
+	if err != nil {
+		return err
+	}
+	idToUser := make(map[string]*User)
+	for _, user := range users {
+		idToUser[user.ID] = user
+	}
+	return f.st.ReplaceResponses(sb.conf.Name, chID, since,
+		f.deriveResponses(sb, messages, idToUser))
+}
+
+// deriveResponses returns questions asked in the messages on the board sorted
+// by creation time. Users are told apart by the domains of the board.
+// Messages of bots neither ask nor answer.
+func (f *Figaro) deriveResponses(sb *servedBoard, messages []*Message,
+	idToUser map[string]*User) []*Response {
+	var responses []*Response
+	var open *Response
+	for _, m := range messages {
+		user := idToUser[m.UserID]
+		if m.UserID == "" || user != nil && user.Role == RoleBot {
+			continue
+		}
+		if f.roles.isInternal(user, sb.conf.Domains) {
+			if open != nil {
+				open.ResponseTS = m.TS
+				open.ResponderID = m.UserID
+				open.RespondedAt = m.CreatedAt
+				open = nil
+			}
+			continue
+		}
+		if open == nil {
+			open = &Response{
+				Board:     sb.conf.Name,
+				ChannelID: m.ChannelID,
+				TS:        m.TS,
+				UserID:    m.UserID,
+				AskedAt:   m.CreatedAt,
+			}
+			responses = append(responses, open)
+		}
+	}
+	return responses
+}
+
+// updateChannelResponses updates responses of channels on the boards they
+// belong to from the earliest time their messages changed at by channel IDs.
+func (f *Figaro) updateChannelResponses(changed map[string]time.Time) {
+	boards := f.servedBoards()
+	for chID, since := range changed {
+		channel, err := f.st.GetChannel(chID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			log.Println("Figaro: Cannot get channel:", err)
+			continue
+		}
+		for _, sb := range boards {
+			if !sb.matches(channel) {
+				continue
+			}
+			if err := f.updateResponses(sb, chID, since); err != nil {
+				log.Println("Figaro: Cannot update responses:", err)
+			}
+		}
+	}
+}
+
+// backfillResponses derives responses of all boards.
+func (f *Figaro) backfillResponses() error {
+	log.Println("Figaro: Backfilling responses...")
+	for _, sb := range f.servedBoards() {
+		if err := f.backfillBoardResponses(sb, false); err != nil {
+			return err
+		}
+	}
+	log.Println("Figaro: Responses backfilled")
+	return nil
+}
+
+// backfillBoardResponses derives responses of channels of the board which
+// have none, for example after the upgrade which introduced them or after
+// the board was created. It derives them all again if all is set.
+func (f *Figaro) backfillBoardResponses(sb *servedBoard, all bool) error {
+	channels, err := f.boardChannels(sb)
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		if !all {
+			_, err := f.st.GetResponseAt(sb.conf.Name, channel.ID, time.Now())
+			if err == nil {
+				continue
+			}
+			if err != sql.ErrNoRows {
+				return err
+			}
+		}
+		if err := f.updateResponses(sb, channel.ID, time.Time{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// slaOf returns SLA of the channel, 0 if there is none.
+func (f *Figaro) slaOf(channel *Channel) time.Duration {
+	if channel.SLA > 0 {
+		return time.Duration(channel.SLA) * time.Second
+	}
+	return f.sla
+}
+
+// setWaiting sets WaitingSince of the channels from their unanswered
+// questions on the board.
+func (f *Figaro) setWaiting(sb *servedBoard, channels []*Channel) error {
+	ids := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		ids = append(ids, channel.ID)
+	}
+	open, err := f.st.GetOpenResponses(sb.conf.Name, ids)
+	if err != nil {
+		return err
+	}
+	idToOpen := make(map[string]*Response)
+	for _, r := range open {
+		idToOpen[r.ChannelID] = r
+	}
+	for _, channel := range channels {
+		channel.WaitingSince = time.Time{}
+		if r := idToOpen[channel.ID]; r != nil {
+			channel.WaitingSince = r.AskedAt
+		}
+	}
+	return nil
+}
+
+// setClock sets WaitStart and Breached of the channel by the business hours
+// of cal at now.
+func (f *Figaro) setClock(channel *Channel, cal *calendar, now time.Time) {
+	channel.WaitStart = time.Time{}
+	if len(channel.Messages) > 0 {
+		channel.WaitStart = cal.next(channel.Messages[0].CreatedAt)
+	}
+	channel.Breached = f.isBreached(channel, cal, now)
+}
+
+// isBreached tells if the question of the channel has waited longer than
+// its SLA in business hours of cal at now.
+func (f *Figaro) isBreached(channel *Channel, cal *calendar, now time.Time) bool {
+	sla := f.slaOf(channel)
+	return !channel.WaitingSince.IsZero() && sla > 0 &&
+		cal.elapsed(channel.WaitingSince, now) > sla
+}
+
+// updateBreaches updates channels of the boards whose questions have waited
+// longer than their SLA since they were pushed.
+func (f *Figaro) updateBreaches() {
+	now := time.Now()
+	ids := make(map[string]bool)
+	for _, sb := range f.servedBoards() {
+		for _, channel := range sb.state.list() {
+			cal := f.calendarOf(sb, channel)
+			if !channel.Breached && f.isBreached(channel, cal, now) {
+				ids[channel.ID] = true
+			}
+		}
+	}
+	for id := range ids {
+		f.updateBoardChannel(id)
+	}
+}
+
+// UpdateChannelSLA sets SLA of a channel in seconds, 0 for the default one,
+// and notifies users. It returns when the board is updated.
+func (f *Figaro) UpdateChannelSLA(id string, sla uint) error {
+	if err := f.st.UpdateChannelSLA(id, sla); err != nil {
+		return err
+	}
+	f.waitBoardChannel(id)
+	return nil
+}
+
+// Responses returns questions of the channel, or of all channels if chID is
+// empty, on the board asked from from till to. It returns ErrNoBoard if
+// there is no such board.
+func (f *Figaro) Responses(board, chID string, from, to time.Time) ([]*Response, error) {
+	if f.servedBoard(board) == nil {
+		return nil, ErrNoBoard
+	}
+	return f.st.GetResponses(board, chID, from, to)
+}
@@ -0,0 +1,75 @@
+package figaro
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBoardResponses(t *testing.T) {
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	f, _, st := newTestFigaro(t, []*Channel{{ID: "C1", Name: "one"}},
+		[]*Message{testMessage("UGUEST", t0, "question")})
+	// UGUEST is internal on the board of its own domain
+	board := &Board{Name: "customer", Include: []string{"^one$"},
+		Domains: []string{"customer.com"}}
+	if err := f.PutBoard(board); err != nil {
+		t.Fatal(err)
+	}
+	// check tells if the channel waits since t0 on the board and how many
+	// questions it has
+	check := func(name string, wantWaiting bool, wantQuestions int) {
+		t.Helper()
+		responses, err := f.Responses(name, "C1", t0.Add(-time.Hour), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(responses) != wantQuestions {
+			t.Errorf("board %s: got %d questions, want %d", name,
+				len(responses), wantQuestions)
+		}
+		for _, r := range responses {
+			if r.Board != name {
+				t.Errorf("board %s: got question of board %s", name, r.Board)
+			}
+		}
+		channel, _ := f.servedBoard(name).state.channel("C1")
+		if channel == nil {
+			t.Fatalf("board %s: no channel", name)
+		}
+		if waiting := channel.WaitingSince.Equal(t0); waiting != wantWaiting {
+			t.Errorf("board %s: got WaitingSince %v, want waiting %v", name,
+				channel.WaitingSince, wantWaiting)
+		}
+	}
+	check(DefaultBoard, true, 1)
+	check("customer", false, 0)
+
+	err := f.processMessages([]*Message{testMessage("UGUEST", t0.Add(time.Minute), "more")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.waitBoardChannel("C1")
+	check(DefaultBoard, true, 1)
+	check("customer", false, 0)
+
+	// Questions are derived again with other domains
+	board.Domains = []string{"corp.com"}
+	if err := f.PutBoard(board); err != nil {
+		t.Fatal(err)
+	}
+	check("customer", true, 1)
+
+	if err := f.DeleteBoard("customer"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Responses("customer", "C1", t0, time.Now()); err != ErrNoBoard {
+		t.Errorf("got %v for a deleted board, want ErrNoBoard", err)
+	}
+	responses, err := st.GetResponses("customer", "", t0, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 0 {
+		t.Errorf("got %d questions of a deleted board, want none", len(responses))
+	}
+}
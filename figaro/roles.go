@@ -0,0 +1,105 @@
+package figaro
+
+import "strings"
+
+// RoleConfig configures how users are classified. Users with emails in the
+// domains Figaro is created with are internal as well.
+type RoleConfig struct {
+	// Teams are IDs of the organization workspaces. Their full members are
+	// internal.
+	Teams []string
+	// Allow are IDs of users who are always internal, for example partners
+	// who answer guests.
+	Allow []string
+	// Deny are IDs of users who are never internal, even if they have
+	// emails in the domains of a board.
+	Deny []string
+	// Admins are IDs of users who may change boards, calendars and SLAs
+	// and manage webhooks. Nobody may if it's empty, unless the API is
+	// served without signing in.
+	Admins []string
+}
+
+// roles classifies users.
+type roles struct {
+	domains []string
+	teams   map[string]bool
+	allow   map[string]bool
+	deny    map[string]bool
+	admins  map[string]bool
+}
+
+func newRoles(conf RoleConfig, domains []string) *roles {
+	r := &roles{
+		domains: domains,
+		teams:   make(map[string]bool),
+		allow:   make(map[string]bool),
+		deny:    make(map[string]bool),
+		admins:  make(map[string]bool),
+	}
+	for _, id := range conf.Teams {
+		r.teams[id] = true
+	}
+	for _, id := range conf.Allow {
+		r.allow[id] = true
+	}
+	for _, id := range conf.Deny {
+		r.deny[id] = true
+	}
+	for _, id := range conf.Admins {
+		r.admins[id] = true
+	}
+	return r
+}
+
+// IsAdmin tells if the user may manage the configuration. Everybody may if
+// userID is empty, that is the API is served without signing in.
+func (f *Figaro) IsAdmin(userID string) bool {
+	return userID == "" || f.roles.admins[userID]
+}
+
+// classify returns the role of the user. The lists win over what Slack
+// reports, guest accounts are guests whatever their emails are.
+func (r *roles) classify(u *User) string {
+	switch {
+	case r.allow[u.ID]:
+		return RoleInternal
+	case r.deny[u.ID]:
+		return RoleExternal
+	case u.IsBot || u.ID == "USLACKBOT":
+		return RoleBot
+	case u.IsStranger:
+		return RoleExternal
+	case u.IsRestricted || u.IsUltraRestricted:
+		return RoleGuest
+	case isInDomains(u.Email, r.domains), r.teams[u.TeamID]:
+		return RoleInternal
+	}
+	return RoleExternal
+}
+
+// isInternal tells if the user answers guests on a board with the domains.
+// Internal users and bots do everywhere, other users do if their emails are
+// in the domains of the board unless they are denied. Unknown users, nil
+// ones, don't.
+func (r *roles) isInternal(u *User, domains []string) bool {
+	if u == nil {
+		return false
+	}
+	switch u.Role {
+	case RoleInternal, RoleBot:
+		return true
+	case RoleGuest:
+		return false
+	}
+	return !r.deny[u.ID] && isInDomains(u.Email, domains)
+}
+
+func isInDomains(email string, domains []string) bool {
+	for _, domain := range domains {
+		if strings.HasSuffix(email, "@"+domain) {
+			return true
+		}
+	}
+	return false
+}
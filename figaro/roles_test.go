@@ -0,0 +1,74 @@
+package figaro
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	r := newRoles(RoleConfig{Teams: []string{"TCORP"}, Allow: []string{"UALLOW", "UALLOWBOT"},
+		Deny: []string{"UDENY", "UDENYBOT"}}, []string{"corp.com"})
+	users := append(testUsers(),
+		&User{ID: "USLACKBOT"},
+		&User{ID: "UALLOWBOT", IsBot: true},
+		&User{ID: "UDENYBOT", IsBot: true},
+		&User{ID: "USTRANGER", Email: "fay@corp.com", IsStranger: true},
+		&User{ID: "UULTRA", Email: "gil@corp.com", IsUltraRestricted: true},
+		&User{ID: "UTEAM", Email: "hal@partner.com", TeamID: "TCORP"},
+		&User{ID: "UTEAMGUEST", Email: "ida@partner.com", TeamID: "TCORP", IsRestricted: true},
+		&User{ID: "USUBDOMAIN", Email: "jon@eu.corp.com"},
+		&User{ID: "UNOEMAIL"},
+	)
+	want := map[string]string{
+		"UINT":       RoleInternal,
+		"UGUEST":     RoleExternal,
+		"UALLOW":     RoleInternal,
+		"UDENY":      RoleExternal,
+		"UBOT":       RoleBot,
+		"URESTR":     RoleGuest,
+		"USLACKBOT":  RoleBot,
+		"UALLOWBOT":  RoleInternal,
+		"UDENYBOT":   RoleExternal,
+		"USTRANGER":  RoleExternal,
+		"UULTRA":     RoleGuest,
+		"UTEAM":      RoleInternal,
+		"UTEAMGUEST": RoleGuest,
+		"USUBDOMAIN": RoleExternal,
+		"UNOEMAIL":   RoleExternal,
+	}
+	for _, user := range users {
+		t.Run(user.ID, func(t *testing.T) {
+			if got := r.classify(user); got != want[user.ID] {
+				t.Errorf("got %q, want %q", got, want[user.ID])
+			}
+		})
+	}
+}
+
+func TestIsInternal(t *testing.T) {
+	r := newRoles(RoleConfig{Allow: []string{"UALLOW"}, Deny: []string{"UDENY"}},
+		[]string{"corp.com"})
+	users := make(map[string]*User)
+	for _, user := range testUsers() {
+		user.Role = r.classify(user)
+		users[user.ID] = user
+	}
+	tests := []struct {
+		userID  string
+		domains []string
+		want    bool
+	}{
+		{userID: "UINT", want: true},
+		{userID: "UBOT", want: true},
+		{userID: "UALLOW", want: true},
+		{userID: "UGUEST"},
+		{userID: "UGUEST", domains: []string{"customer.com"}, want: true},
+		{userID: "UDENY", domains: []string{"corp.com"}},
+		{userID: "URESTR", domains: []string{"corp.com"}},
+		{userID: "UNKNOWN", domains: []string{"corp.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.userID, func(t *testing.T) {
+			if got := r.isInternal(users[tt.userID], tt.domains); got != tt.want {
+				t.Errorf("on %q: got %v, want %v", tt.domains, got, tt.want)
+			}
+		})
+	}
+}
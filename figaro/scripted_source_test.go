@@ -0,0 +1,137 @@
+package figaro
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ScriptedSource is a Source which returns predefined users, channels and
+// message history and emits scripted messages on MessageCh. It runs Figaro
+// without Slack in tests.
+type ScriptedSource struct {
+	mu        sync.Mutex
+	users     []*User
+	channels  []*Channel
+	history   map[string][]*Message
+	members   map[string][]string
+	messageCh chan *Message
+}
+
+var _ Source = (*ScriptedSource)(nil)
+
+// NewScriptedSource creates a source with the given users, channels and
+// message history.
+func NewScriptedSource(users []*User, channels []*Channel,
+	history []*Message) *ScriptedSource {
+	s := &ScriptedSource{
+		users:     users,
+		channels:  channels,
+		history:   make(map[string][]*Message),
+		members:   make(map[string][]string),
+		messageCh: make(chan *Message),
+	}
+	for _, m := range history {
+		setTS(m)
+		s.history[m.ChannelID] = append(s.history[m.ChannelID], m)
+	}
+	return s
+}
+
+// MessageCh returns scripted messages.
+func (s *ScriptedSource) MessageCh() <-chan *Message {
+	return s.messageCh
+}
+
+// Emit sends messages to MessageCh one by one and adds them to the history.
+// Membership changes update members. It blocks until all of them are
+// received.
+func (s *ScriptedSource) Emit(messages ...*Message) {
+	for _, m := range messages {
+		setTS(m)
+		s.mu.Lock()
+		switch m.Type {
+		case "":
+			s.history[m.ChannelID] = append(s.history[m.ChannelID], m)
+		case "member_joined_channel":
+			s.members[m.ChannelID] = append(s.members[m.ChannelID], m.UserID)
+		case "member_left_channel":
+			var members []string
+			for _, id := range s.members[m.ChannelID] {
+				if id != m.UserID {
+					members = append(members, id)
+				}
+			}
+			s.members[m.ChannelID] = members
+		}
+		s.mu.Unlock()
+		s.messageCh <- m
+	}
+}
+
+// setTS sets the Slack timestamp from the creation time if it's missing.
+func setTS(m *Message) {
+	if m.TS == "" && !m.CreatedAt.IsZero() {
+		m.TS = timeToStr(m.CreatedAt)
+	}
+}
+
+// SetUsers replaces users returned by GetUsers.
+func (s *ScriptedSource) SetUsers(users []*User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = users
+}
+
+// SetChannels replaces channels returned by GetChannels.
+func (s *ScriptedSource) SetChannels(channels []*Channel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels = channels
+}
+
+// SetMembers replaces members of the channel returned by GetMembers.
+func (s *ScriptedSource) SetMembers(chID string, userIDs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.members[chID] = userIDs
+}
+
+// GetUsers returns scripted users.
+func (s *ScriptedSource) GetUsers() ([]*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.users, nil
+}
+
+// GetChannels returns scripted channels.
+func (s *ScriptedSource) GetChannels() ([]*Channel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.channels, nil
+}
+
+// GetMessages passes history of the channel newer than ts to process in a
+// single portion sorted by creation time.
+func (s *ScriptedSource) GetMessages(chID string, ts time.Time,
+	process ProcMsgs) error {
+	s.mu.Lock()
+	var messages []*Message
+	for _, m := range s.history[chID] {
+		if m.CreatedAt.After(ts) {
+			messages = append(messages, m)
+		}
+	}
+	s.mu.Unlock()
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+	return process(messages)
+}
+
+// GetMembers returns scripted members of the channel.
+func (s *ScriptedSource) GetMembers(chID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.members[chID]...), nil
+}
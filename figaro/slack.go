@@ -1,31 +1,144 @@
 package figaro
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	nlopesslack "github.com/nlopes/slack"
 )
 
+const (
+	// SlackModeEvents receives messages from Slack Events API.
+	SlackModeEvents = "events"
+	// SlackModeRTM receives messages from the legacy Slack RTM API.
+	SlackModeRTM = "rtm"
+
+	slackPageSize = 200
+	// threadLookback is how long before the backfill start threads are
+	// checked for new replies.
+	threadLookback = 7 * 24 * time.Hour
+	// eventQueueSize is a number of Events API events waiting to be sent
+	// to MessageCh.
+	eventQueueSize = 1024
+	// eventRetention is how long IDs of queued Events API events are kept
+	// to drop retries of them.
+	eventRetention = time.Hour
+)
+
+// Source is a source of Users, Messages and Channels. Slack is the one
+// used in production.
+type Source interface {
+	// MessageCh returns messages as they arrive.
+	MessageCh() <-chan *Message
+	GetUsers() ([]*User, error)
+	GetChannels() ([]*Channel, error)
+	// GetMessages passes messages of a channel starting from the specified
+	// timestamp (not including) to process in portions.
+	GetMessages(chID string, ts time.Time, process ProcMsgs) error
+	// GetMembers returns IDs of users who are members of a channel.
+	GetMembers(chID string) ([]string, error)
+}
+
+// SlackConfig configures Slack.
+type SlackConfig struct {
+	Token string
+	// APIURL is the base URL of Slack Web API. The default one is used if
+	// it's empty.
+	APIURL string
+	// Mode is SlackModeEvents or SlackModeRTM.
+	Mode string
+	// SigningSecret verifies Events API requests. It's required for
+	// SlackModeEvents.
+	SigningSecret string
+	// Types are conversation types to ingest, ChannelType* constants.
+	// Only public channels are ingested if it's empty.
+	Types []string
+}
+
 // Slack fetches Users, Messages and Channels from Slack
 type Slack struct {
-	api       *nlopesslack.Client
-	messageCh chan *Message
+	api           *nlopesslack.Client
+	teamID        string
+	signingSecret string
+	types         []string
+	messageCh     chan *Message
+	// eventCh queues Events API events, they are sent to MessageCh after
+	// Slack gets the answer.
+	eventCh chan interface{}
+
+	mu sync.Mutex
+	// channelTypes contains types of known channels by channel IDs
+	channelTypes map[string]string
+	// queuedEvents contains times Events API events were queued by event
+	// IDs
+	queuedEvents map[string]time.Time
+	prunedAt     time.Time
 }
 
-// NewSlack creates a new slack service
-func NewSlack(token string) *Slack {
+var _ Source = (*Slack)(nil)
+
+// NewSlack creates a new slack service for the workspace of the token. In
+// SlackModeEvents messages come from EventsHandler, so it must be exposed to
+// Slack.
+func NewSlack(conf SlackConfig) (*Slack, error) {
+	if conf.Mode == SlackModeEvents && conf.SigningSecret == "" {
+		return nil, errors.New("signing secret is required for Events API")
+	}
+	if conf.Mode != SlackModeEvents && conf.Mode != SlackModeRTM {
+		return nil, fmt.Errorf("unknown Slack mode %q", conf.Mode)
+	}
 	s := &Slack{}
-	s.api = nlopesslack.New(token)
+	var options []nlopesslack.Option
+	if conf.APIURL != "" {
+		apiURL := conf.APIURL
+		if !strings.HasSuffix(apiURL, "/") {
+			apiURL += "/"
+		}
+		options = append(options, nlopesslack.OptionAPIURL(apiURL))
+	}
+	s.api = nlopesslack.New(conf.Token, options...)
 	// s.api.SetDebug(true)
+	s.signingSecret = conf.SigningSecret
+	s.types = conf.Types
+	if len(s.types) == 0 {
+		s.types = []string{ChannelTypePublic}
+	}
+	for _, t := range s.types {
+		switch t {
+		case ChannelTypePublic, ChannelTypePrivate, ChannelTypeMPIM, ChannelTypeIM:
+		default:
+			return nil, fmt.Errorf("unknown conversation type %q", t)
+		}
+	}
+	auth, err := s.api.AuthTest()
+	if err != nil {
+		return nil, fmt.Errorf("cannot authenticate in Slack: %v", err)
+	}
+	s.teamID = auth.TeamID
+	log.Printf("Slack: authenticated in %s (%s)\n", auth.Team, auth.TeamID)
+	s.channelTypes = make(map[string]string)
 	s.messageCh = make(chan *Message)
-	go s.serveRTM()
-	return s
+	if conf.Mode == SlackModeRTM {
+		go s.serveRTM()
+	} else {
+		s.eventCh = make(chan interface{}, eventQueueSize)
+		s.queuedEvents = make(map[string]time.Time)
+		go s.serveEvents()
+	}
+	return s, nil
+}
+
+// TeamID returns ID of the Slack workspace.
+func (s *Slack) TeamID() string {
+	return s.teamID
 }
 
-// MessageCh channel returns Slack RTM messages
+// MessageCh channel returns Slack messages received from RTM or Events API
 func (s *Slack) MessageCh() <-chan *Message {
 	return s.messageCh
 }
@@ -40,15 +153,13 @@ func (s *Slack) serveRTM() {
 			log.Println("Slack: RTM says Hello")
 		case *nlopesslack.MessageEvent:
 			log.Println("Slack: received RTM message")
-			apiMsg := rtmMsg.Data.(*nlopesslack.MessageEvent).Msg
-			msg := &Message{}
-			msg.UserID = apiMsg.User
-			msg.ChannelID = apiMsg.Channel
-			msg.CreatedAt = strToTime(apiMsg.Timestamp)
-			msg.Text = apiMsg.Text
-			msg.Type = apiMsg.SubType
-			msg.Name = apiMsg.Name
-			s.messageCh <- msg
+			s.handleEvent(ev)
+		case *nlopesslack.ReactionAddedEvent, *nlopesslack.ReactionRemovedEvent:
+			log.Println("Slack: received RTM reaction")
+			s.handleEvent(ev)
+		case *nlopesslack.MemberJoinedChannelEvent, *nlopesslack.MemberLeftChannelEvent:
+			log.Println("Slack: received RTM membership change")
+			s.handleEvent(ev)
 		case *nlopesslack.RTMError:
 			log.Printf("Slack: RTM Error: %s\n", ev.Error())
 		case *nlopesslack.InvalidAuthEvent:
@@ -62,6 +173,118 @@ func (s *Slack) serveRTM() {
 	}
 }
 
+// handleEvent converts an RTM or Events API event and sends it to
+// MessageCh.
+func (s *Slack) handleEvent(event interface{}) {
+	switch ev := event.(type) {
+	case *nlopesslack.MessageEvent:
+		if !s.isIngested(ev.Channel) {
+			return
+		}
+		s.messageCh <- s.newMessage(ev.Channel, (*nlopesslack.Message)(ev))
+	case *nlopesslack.ReactionAddedEvent:
+		s.handleReaction("reaction_added", ev.Item.Type, ev.Item.Channel,
+			ev.Item.Timestamp, ev.User, ev.Reaction)
+	case *nlopesslack.ReactionRemovedEvent:
+		s.handleReaction("reaction_removed", ev.Item.Type, ev.Item.Channel,
+			ev.Item.Timestamp, ev.User, ev.Reaction)
+	case *nlopesslack.MemberJoinedChannelEvent:
+		s.handleMembership("member_joined_channel", ev.Channel, ev.User)
+	case *nlopesslack.MemberLeftChannelEvent:
+		s.handleMembership("member_left_channel", ev.Channel, ev.User)
+	}
+}
+
+// handleMembership sends a membership change to MessageCh as a message with
+// the type of the event and the user who joined or left.
+func (s *Slack) handleMembership(typ, chID, userID string) {
+	if !s.isIngested(chID) {
+		return
+	}
+	s.messageCh <- &Message{Type: typ, ChannelID: chID, UserID: userID}
+}
+
+// handleReaction sends a reaction event to MessageCh as a message with
+// the type of the event, the reacting user, the Slack timestamp of the
+// message reacted to and the reaction name as Name.
+func (s *Slack) handleReaction(typ, itemType, chID, ts, userID, name string) {
+	if itemType != "message" || !s.isIngested(chID) {
+		return
+	}
+	s.messageCh <- &Message{
+		Type:      typ,
+		ChannelID: chID,
+		TS:        ts,
+		UserID:    userID,
+		Name:      name,
+	}
+}
+
+// isIngested tells if messages of the channel should be ingested according
+// to the configured conversation types.
+func (s *Slack) isIngested(chID string) bool {
+	s.mu.Lock()
+	t, ok := s.channelTypes[chID]
+	s.mu.Unlock()
+	if !ok {
+		// The channel is not backfilled yet, guess the type by the ID
+		switch {
+		case strings.HasPrefix(chID, "D"):
+			t = ChannelTypeIM
+		case strings.HasPrefix(chID, "G"):
+			t = ChannelTypePrivate
+		default:
+			t = ChannelTypePublic
+		}
+	}
+	for _, typ := range s.types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Slack) newMessage(chID string, apiMessage *nlopesslack.Message) *Message {
+	apiMsg := &apiMessage.Msg
+	msg := &Message{TeamID: s.teamID}
+	switch apiMsg.SubType {
+	case "message_changed":
+		// The edited message comes inside
+		if apiMessage.SubMessage != nil {
+			apiMsg = apiMessage.SubMessage
+		}
+	case "message_deleted":
+		msg.TS = apiMsg.DeletedTimestamp
+		msg.ChannelID = chID
+		msg.Type = apiMsg.SubType
+		return msg
+	}
+	msg.TS = apiMsg.Timestamp
+	if apiMsg.Edited != nil {
+		msg.EditedAt = strToTime(apiMsg.Edited.Timestamp)
+	}
+	msg.UserID = apiMsg.User
+	msg.ChannelID = chID
+	msg.CreatedAt = strToTime(apiMsg.Timestamp)
+	msg.Text = apiMsg.Text
+	msg.Type = apiMessage.SubType
+	msg.Name = apiMsg.Name
+	msg.ThreadTS = apiMsg.ThreadTimestamp
+	msg.ParentUserID = apiMsg.ParentUserId
+	msg.IsReply = msg.ThreadTS != "" && msg.ThreadTS != apiMsg.Timestamp
+	if apiMsg.Reactions != nil {
+		msg.Reactions = make([]*Reaction, 0, len(apiMsg.Reactions))
+		for _, r := range apiMsg.Reactions {
+			msg.Reactions = append(msg.Reactions, &Reaction{
+				Name:    r.Name,
+				UserIDs: r.Users,
+			})
+		}
+	}
+	return msg
+}
+
 // GetUsers returns all slack users
 func (s *Slack) GetUsers() ([]*User, error) {
 	apiUsers, err := s.api.GetUsers()
@@ -75,6 +298,14 @@ func (s *Slack) GetUsers() ([]*User, error) {
 		user.Name = apiUser.Name
 		user.FullName = apiUser.RealName
 		user.Email = apiUser.Profile.Email
+		user.TeamID = apiUser.TeamID
+		if user.TeamID == "" {
+			user.TeamID = s.teamID
+		}
+		user.IsBot = apiUser.IsBot
+		user.IsRestricted = apiUser.IsRestricted
+		user.IsUltraRestricted = apiUser.IsUltraRestricted
+		user.IsStranger = apiUser.IsStranger
 		users = append(users, user)
 	}
 	return users, nil
@@ -85,19 +316,25 @@ func (s *Slack) GetUsers() ([]*User, error) {
 type ProcMsgs func(messages []*Message) error
 
 // GetMessages gets all messages starting from specified timestamp
-// (not including)
+// (not including) together with thread replies. Replies posted after ts to
+// threads started within threadLookback before ts are returned as well.
 func (s *Slack) GetMessages(chID string, ts time.Time, process ProcMsgs) error {
-	if ts.IsZero() {
-		ts = time.Unix(1, 0)
+	oldest := ts
+	if oldest.IsZero() {
+		oldest = time.Unix(1, 0)
 	}
-	query := nlopesslack.HistoryParameters{
-		Oldest: timeToStr(ts),
-		Latest: timeToStr(time.Now()),
-		Count:  1000,
+	query := &nlopesslack.GetConversationHistoryParameters{
+		ChannelID: chID,
+		Oldest:    timeToStr(oldest),
+		Latest:    timeToStr(time.Now()),
+		Limit:     slackPageSize,
 	}
 	log.Printf("Slack: message query: %+v\n", query)
 	for {
-		history, err := s.api.GetChannelHistory(chID, query)
+		history, err := s.api.GetConversationHistory(query)
+		if rateLimited(err) {
+			continue
+		}
 		if err != nil {
 			log.Println("Slack: cannot get messages from Slack API:", err)
 			return err
@@ -108,45 +345,223 @@ func (s *Slack) GetMessages(chID string, ts time.Time, process ProcMsgs) error {
 				continue
 			}
 			log.Printf("Slack: message: %+v\n", apiMsg)
-			msg := &Message{}
-			msg.UserID = apiMsg.User
-			msg.ChannelID = chID
-			msg.CreatedAt = strToTime(apiMsg.Timestamp)
-			msg.Text = apiMsg.Text
-			msg.Type = apiMsg.SubType
-			msg.Name = apiMsg.Name
-			messages = append(messages, msg)
+			messages = append(messages, s.newMessage(chID, &apiMsg))
+			if apiMsg.ReplyCount > 0 {
+				replies, err := s.getReplies(chID, apiMsg.Timestamp, time.Time{})
+				if err != nil {
+					log.Println("Slack: cannot get replies from Slack API:", err)
+					return err
+				}
+				messages = append(messages, replies...)
+			}
 		}
 		if err := process(messages); err != nil {
 			log.Println("Cannot process messages:", err)
 			return err
 		}
-		if !history.HasMore {
+		if !history.HasMore || history.ResponseMetaData.NextCursor == "" {
 			break
 		}
-		query.Oldest = history.Latest
+		query.Cursor = history.ResponseMetaData.NextCursor
+	}
+	if !ts.IsZero() {
+		if err := s.getNewReplies(chID, ts, process); err != nil {
+			return err
+		}
 	}
 	log.Printf("Slack: channel %v is processed.\n", chID)
 	return nil
 }
 
+// getNewReplies passes replies posted after ts to threads started within
+// threadLookback before ts to process. The Slack client doesn't decode
+// latest_reply of thread parents, so threads are skipped by their replies
+// only if Slack lists them.
+func (s *Slack) getNewReplies(chID string, ts time.Time, process ProcMsgs) error {
+	query := &nlopesslack.GetConversationHistoryParameters{
+		ChannelID: chID,
+		Oldest:    timeToStr(ts.Add(-threadLookback)),
+		Latest:    timeToStr(ts),
+		Inclusive: true,
+		Limit:     slackPageSize,
+	}
+	for {
+		history, err := s.api.GetConversationHistory(query)
+		if rateLimited(err) {
+			continue
+		}
+		if err != nil {
+			log.Println("Slack: cannot get messages from Slack API:", err)
+			return err
+		}
+		var replies []*Message
+		for _, apiMsg := range history.Messages {
+			if apiMsg.Type != "message" || apiMsg.ReplyCount == 0 ||
+				!hasRepliesAfter(&apiMsg, ts) {
+				continue
+			}
+			threadReplies, err := s.getReplies(chID, apiMsg.Timestamp, ts)
+			if err != nil {
+				log.Println("Slack: cannot get replies from Slack API:", err)
+				return err
+			}
+			replies = append(replies, threadReplies...)
+		}
+		if len(replies) > 0 {
+			if err := process(replies); err != nil {
+				log.Println("Cannot process messages:", err)
+				return err
+			}
+		}
+		if !history.HasMore || history.ResponseMetaData.NextCursor == "" {
+			break
+		}
+		query.Cursor = history.ResponseMetaData.NextCursor
+	}
+	return nil
+}
+
+// hasRepliesAfter tells if the thread parent may have replies posted after
+// ts. It's true unless Slack lists the replies and all of them are older.
+func hasRepliesAfter(parent *nlopesslack.Message, ts time.Time) bool {
+	if len(parent.Replies) == 0 {
+		return true
+	}
+	for _, reply := range parent.Replies {
+		if reply.Timestamp > timeToStr(ts) {
+			return true
+		}
+	}
+	return false
+}
+
+// getReplies returns replies in the thread started by the message with the
+// timestamp threadTS posted after oldest, all of them if it's zero.
+func (s *Slack) getReplies(chID string, threadTS string, oldest time.Time) ([]*Message, error) {
+	query := &nlopesslack.GetConversationRepliesParameters{
+		ChannelID: chID,
+		Timestamp: threadTS,
+		Limit:     slackPageSize,
+	}
+	if !oldest.IsZero() {
+		query.Oldest = timeToStr(oldest)
+	}
+	var replies []*Message
+	for {
+		apiMsgs, hasMore, cursor, err := s.api.GetConversationReplies(query)
+		if rateLimited(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, apiMsg := range apiMsgs {
+			// The parent message is returned as well
+			if apiMsg.Type != "message" || apiMsg.Timestamp == threadTS {
+				continue
+			}
+			replies = append(replies, s.newMessage(chID, &apiMsg))
+		}
+		if !hasMore || cursor == "" {
+			break
+		}
+		query.Cursor = cursor
+	}
+	return replies, nil
+}
+
 // GetChannels returns all slack channels without messages
 func (s *Slack) GetChannels() ([]*Channel, error) {
-	apiChannels, err := s.api.GetChannels(false)
-	if err != nil {
-		return nil, err
+	query := &nlopesslack.GetConversationsParameters{
+		ExcludeArchived: "false",
+		Limit:           slackPageSize,
+		Types:           s.types,
 	}
-	channels := make([]*Channel, 0, len(apiChannels))
-	for _, apiCh := range apiChannels {
-		channel := &Channel{}
-		channel.ID = apiCh.ID
-		channel.Name = apiCh.Name
-		channel.Archived = apiCh.IsArchived
-		channels = append(channels, channel)
+	var channels []*Channel
+	for {
+		apiChannels, cursor, err := s.api.GetConversations(query)
+		if rateLimited(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, apiCh := range apiChannels {
+			channel := &Channel{}
+			channel.ID = apiCh.ID
+			channel.Name = apiCh.Name
+			if apiCh.IsIM {
+				// Direct messages have no name
+				channel.Name = apiCh.User
+			}
+			channel.Type = channelType(&apiCh)
+			channel.Shared = apiCh.IsShared
+			channel.ExtShared = apiCh.IsExtShared
+			channel.Archived = apiCh.IsArchived
+			channel.TeamID = s.teamID
+			channels = append(channels, channel)
+		}
+		if cursor == "" {
+			break
+		}
+		query.Cursor = cursor
 	}
+	s.mu.Lock()
+	for _, channel := range channels {
+		s.channelTypes[channel.ID] = channel.Type
+	}
+	s.mu.Unlock()
 	return channels, nil
 }
 
+// GetMembers returns IDs of users who are members of a channel
+func (s *Slack) GetMembers(chID string) ([]string, error) {
+	query := &nlopesslack.GetUsersInConversationParameters{
+		ChannelID: chID,
+		Limit:     slackPageSize,
+	}
+	var userIDs []string
+	for {
+		ids, cursor, err := s.api.GetUsersInConversation(query)
+		if rateLimited(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, ids...)
+		if cursor == "" {
+			break
+		}
+		query.Cursor = cursor
+	}
+	return userIDs, nil
+}
+
+func channelType(apiCh *nlopesslack.Channel) string {
+	switch {
+	case apiCh.IsIM:
+		return ChannelTypeIM
+	case apiCh.IsMpIM:
+		return ChannelTypeMPIM
+	case apiCh.IsPrivate || apiCh.IsGroup:
+		return ChannelTypePrivate
+	}
+	return ChannelTypePublic
+}
+
+// rateLimited waits for the time Slack asks to if err says that a request
+// was rate limited.
+func rateLimited(err error) bool {
+	rlErr, ok := err.(*nlopesslack.RateLimitedError)
+	if !ok {
+		return false
+	}
+	log.Println("Slack: rate limited, retrying in", rlErr.RetryAfter)
+	time.Sleep(rlErr.RetryAfter)
+	return true
+}
+
 func timeToStr(t time.Time) string {
 	return fmt.Sprintf("%010d.%06d", t.Unix(), t.Nanosecond()/1e3)
 }
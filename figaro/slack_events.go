@@ -0,0 +1,197 @@
+package figaro
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	nlopesslack "github.com/nlopes/slack"
+)
+
+// maxEventSize limits the size of Events API request bodies.
+const maxEventSize = 1 << 20
+
+// eventsAPIRequest is the outer part of Events API requests.
+// See https://api.slack.com/events-api#receiving_events
+type eventsAPIRequest struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	TeamID    string          `json:"team_id"`
+	EventID   string          `json:"event_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// EventsHandler receives Slack Events API requests. It verifies request
+// signatures, answers URL verification challenges and sends messages to
+// MessageCh. Events are queued and answered at once, since Slack retries
+// requests which are not answered in 3 seconds. Retries of queued events are
+// dropped.
+func (s *Slack) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	body, ok := readEvent(w, r)
+	if !ok {
+		return
+	}
+	s.serveEvent(w, r.Header, body)
+}
+
+// EventsRouter receives Events API requests for several workspaces and
+// passes every one to the Slack of the workspace it comes from. The Slack
+// verifies the request, so the workspaces may use different apps.
+func EventsRouter(slacks ...*Slack) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readEvent(w, r)
+		if !ok {
+			return
+		}
+		req := &eventsAPIRequest{}
+		if err := json.Unmarshal(body, req); err != nil {
+			log.Println("Slack: cannot parse event:", err)
+			http.Error(w, "Cannot parse request", http.StatusBadRequest)
+			return
+		}
+		for _, s := range slacks {
+			// URL verification requests don't have teams
+			if req.TeamID == "" || req.TeamID == s.teamID {
+				s.serveEvent(w, r.Header, body)
+				return
+			}
+		}
+		log.Println("Slack: event from unknown workspace:", req.TeamID)
+		http.Error(w, "Unknown workspace", http.StatusNotFound)
+	}
+}
+
+func readEvent(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxEventSize))
+	if err != nil {
+		log.Println("Slack: cannot read event:", err)
+		http.Error(w, "Cannot read request", http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+func (s *Slack) serveEvent(w http.ResponseWriter, header http.Header, body []byte) {
+	if err := s.verifyRequest(header, body); err != nil {
+		log.Println("Slack: cannot verify event:", err)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	req := &eventsAPIRequest{}
+	if err := json.Unmarshal(body, req); err != nil {
+		log.Println("Slack: cannot parse event:", err)
+		http.Error(w, "Cannot parse request", http.StatusBadRequest)
+		return
+	}
+	switch req.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, req.Challenge)
+	case "event_callback":
+		event, err := parseEvent(req.Event)
+		if err != nil {
+			log.Println("Slack: cannot parse event:", err)
+			http.Error(w, "Cannot parse event", http.StatusBadRequest)
+			return
+		}
+		if event != nil && !s.queueEvent(req.EventID, event, header) {
+			log.Println("Slack: event queue is full, event", req.EventID, "is left to retry")
+			http.Error(w, "Too many events", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		log.Println("Slack: unknown Events API request:", req.Type)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// queueEvent queues the event with the ID to be sent to MessageCh. It
+// returns false if the queue is full. Retries of events which are already
+// queued are dropped.
+func (s *Slack) queueEvent(id string, event interface{}, header http.Header) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.prunedAt) > eventRetention {
+		for queuedID, t := range s.queuedEvents {
+			if now.Sub(t) > eventRetention {
+				delete(s.queuedEvents, queuedID)
+			}
+		}
+		s.prunedAt = now
+	}
+	if retry := header.Get("X-Slack-Retry-Num"); retry != "" {
+		log.Printf("Slack: retry %s of event %s: %s\n", retry, id,
+			header.Get("X-Slack-Retry-Reason"))
+		if _, ok := s.queuedEvents[id]; ok {
+			return true
+		}
+	}
+	select {
+	case s.eventCh <- event:
+	default:
+		return false
+	}
+	if id != "" {
+		s.queuedEvents[id] = now
+	}
+	return true
+}
+
+// serveEvents sends queued Events API events to MessageCh.
+func (s *Slack) serveEvents() {
+	for event := range s.eventCh {
+		s.handleEvent(event)
+	}
+}
+
+// verifyRequest checks the request signature made with the signing secret.
+// See https://api.slack.com/docs/verifying-requests-from-slack
+func (s *Slack) verifyRequest(header http.Header, body []byte) error {
+	sv, err := nlopesslack.NewSecretsVerifier(header, s.signingSecret)
+	if err != nil {
+		return err
+	}
+	if _, err := sv.Write(body); err != nil {
+		return err
+	}
+	return sv.Ensure()
+}
+
+// parseEvent parses an inner Events API event into the RTM event type with
+// the same shape. It returns nil for events Figaro doesn't handle.
+func parseEvent(data json.RawMessage) (interface{}, error) {
+	header := struct {
+		Type string `json:"type"`
+	}{}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+	var event interface{}
+	switch header.Type {
+	case "message":
+		event = &nlopesslack.MessageEvent{}
+	case "reaction_added":
+		event = &nlopesslack.ReactionAddedEvent{}
+	case "reaction_removed":
+		event = &nlopesslack.ReactionRemovedEvent{}
+	case "member_joined_channel":
+		event = &nlopesslack.MemberJoinedChannelEvent{}
+	case "member_left_channel":
+		event = &nlopesslack.MemberLeftChannelEvent{}
+	default:
+		return nil, nil
+	}
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
@@ -0,0 +1,399 @@
+package figaro
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adyatlov/figaro/figaro/slacktest"
+	nlopesslack "github.com/nlopes/slack"
+)
+
+// historyFixtures returns fixtures with the public channel C1 with n
+// messages of U1, one per second from 1500000000.
+func historyFixtures(n int) *slacktest.Fixtures {
+	channel := nlopesslack.Channel{}
+	channel.ID = "C1"
+	channel.Name = "one"
+	fixtures := &slacktest.Fixtures{
+		Users:    []nlopesslack.User{{ID: "U1", Name: "ann"}},
+		Channels: []nlopesslack.Channel{channel},
+		History:  make(map[string][]nlopesslack.Message),
+	}
+	for i := 0; i < n; i++ {
+		ts := fmt.Sprintf("%010d.%06d", 1500000000+i, 0)
+		fixtures.History["C1"] = append(fixtures.History["C1"],
+			*messageEvent("C1", "U1", ts, fmt.Sprint(i)))
+	}
+	return fixtures
+}
+
+// messageEvent returns a message event of the user.
+func messageEvent(chID, userID, ts, text string) *nlopesslack.Message {
+	m := &nlopesslack.Message{}
+	m.Type = "message"
+	m.Channel = chID
+	m.User = userID
+	m.Timestamp = ts
+	m.Text = text
+	return m
+}
+
+// newTestSlack creates Slack which talks to the stand-in.
+func newTestSlack(t *testing.T, srv *slacktest.Server, mode string) *Slack {
+	s, err := NewSlack(SlackConfig{
+		Token:         "xoxb-test",
+		APIURL:        srv.APIURL,
+		Mode:          mode,
+		SigningSecret: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestGetMessagesPagination(t *testing.T) {
+	srv := slacktest.NewServer(historyFixtures(25))
+	defer srv.Close()
+	srv.PageSize = 10
+	s := newTestSlack(t, srv, SlackModeEvents)
+	tests := []struct {
+		name         string
+		ts           time.Time
+		wantPortions []int
+	}{
+		{name: "all", wantPortions: []int{10, 10, 5}},
+		{name: "newer than ts", ts: time.Unix(1500000014, 0), wantPortions: []int{10}},
+		{name: "page boundary", ts: time.Unix(1500000004, 0), wantPortions: []int{10, 10}},
+		{name: "nothing new", ts: time.Unix(1500000024, 0), wantPortions: []int{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var portions []int
+			seen := make(map[string]bool)
+			err := s.GetMessages("C1", tt.ts, func(messages []*Message) error {
+				portions = append(portions, len(messages))
+				for _, m := range messages {
+					if seen[m.TS] {
+						t.Errorf("message %s is passed twice", m.TS)
+					}
+					seen[m.TS] = true
+					if !m.CreatedAt.After(tt.ts) {
+						t.Errorf("message %s is not newer than %s", m.TS, tt.ts)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(portions, tt.wantPortions) {
+				t.Errorf("got portions %v, want %v", portions, tt.wantPortions)
+			}
+		})
+	}
+}
+
+func TestGetMessagesThreads(t *testing.T) {
+	fixtures := historyFixtures(0)
+	// Parents and replies by their timestamps
+	for _, m := range []struct{ ts, thread string }{
+		{"1499000000.000000", ""},                  // Older than threadLookback
+		{"1500100001.000000", "1499000000.000000"}, // Missed
+		{"1500000000.000000", ""},
+		{"1500000010.000000", "1500000000.000000"},
+		{"1500100000.000000", "1500000000.000000"},
+		{"1500100002.000000", ""},
+		{"1500100003.000000", "1500100002.000000"},
+	} {
+		msg := messageEvent("C1", "U1", m.ts, m.ts)
+		msg.ThreadTimestamp = m.thread
+		fixtures.History["C1"] = append(fixtures.History["C1"], *msg)
+	}
+	srv := slacktest.NewServer(fixtures)
+	defer srv.Close()
+	s := newTestSlack(t, srv, SlackModeEvents)
+	tests := []struct {
+		name string
+		ts   time.Time
+		want []string
+	}{{
+		name: "all",
+		want: []string{"1499000000.000000", "1500100001.000000", "1500000000.000000",
+			"1500000010.000000", "1500100000.000000", "1500100002.000000",
+			"1500100003.000000"},
+	}, {
+		name: "new replies to old threads",
+		ts:   time.Unix(1500050000, 0),
+		want: []string{"1500100000.000000", "1500100002.000000", "1500100003.000000"},
+	}, {
+		name: "new replies to the last message",
+		ts:   time.Unix(1500000000, 0),
+		want: []string{"1500000010.000000", "1500100000.000000",
+			"1500100002.000000", "1500100003.000000"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]bool)
+			err := s.GetMessages("C1", tt.ts, func(messages []*Message) error {
+				for _, m := range messages {
+					if got[m.TS] {
+						t.Errorf("message %s is passed twice", m.TS)
+					}
+					got[m.TS] = true
+					if m.IsReply != (m.ThreadTS != "" && m.ThreadTS != m.TS) {
+						t.Errorf("message %s: IsReply %v", m.TS, m.IsReply)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := make(map[string]bool)
+			for _, ts := range tt.want {
+				want[ts] = true
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestChannelsHistoryPaging(t *testing.T) {
+	srv := slacktest.NewServer(historyFixtures(25))
+	defer srv.Close()
+	api := nlopesslack.New("xoxb-test", nlopesslack.OptionAPIURL(srv.APIURL))
+	params := nlopesslack.NewHistoryParameters()
+	params.Count = 10
+	var pages []int
+	seen := make(map[string]bool)
+	for {
+		history, err := api.GetChannelHistory("C1", params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, len(history.Messages))
+		for _, m := range history.Messages {
+			if seen[m.Timestamp] {
+				t.Errorf("message %s is returned twice", m.Timestamp)
+			}
+			seen[m.Timestamp] = true
+		}
+		if !history.HasMore {
+			break
+		}
+		// The oldest message of the page comes last
+		params.Latest = history.Messages[len(history.Messages)-1].Timestamp
+	}
+	if !reflect.DeepEqual(pages, []int{10, 10, 5}) {
+		t.Errorf("got pages %v, want [10 10 5]", pages)
+	}
+}
+
+func TestHandleEvent(t *testing.T) {
+	srv := slacktest.NewServer(historyFixtures(0))
+	defer srv.Close()
+	s := newTestSlack(t, srv, SlackModeEvents)
+	const (
+		ts       = "1500000000.000100"
+		threadTS = "1500000000.000050"
+		editTS   = "1500000060.000000"
+	)
+	reply := messageEvent("C1", "U1", ts, "reply")
+	reply.ThreadTimestamp = threadTS
+	reply.ParentUserId = "U2"
+	edited := messageEvent("C1", "", editTS, "")
+	edited.SubType = "message_changed"
+	edited.SubMessage = &messageEvent("C1", "U1", ts, "edited").Msg
+	edited.SubMessage.Edited = &nlopesslack.Edited{User: "U1", Timestamp: editTS}
+	deleted := messageEvent("C1", "", editTS, "")
+	deleted.SubType = "message_deleted"
+	deleted.DeletedTimestamp = ts
+	reaction := func(itemType, chID string) nlopesslack.ReactionAddedEvent {
+		ev := nlopesslack.ReactionAddedEvent{Type: "reaction_added", User: "U1", Reaction: "eyes"}
+		ev.Item.Type = itemType
+		ev.Item.Channel = chID
+		ev.Item.Timestamp = ts
+		return ev
+	}
+	added := reaction("message", "C1")
+	removed := nlopesslack.ReactionRemovedEvent(reaction("message", "C1"))
+	file := reaction("file", "C1")
+	private := reaction("message", "G1")
+	tests := []struct {
+		name  string
+		event interface{}
+		want  *Message // nil if the event is ignored
+	}{{
+		name:  "message",
+		event: (*nlopesslack.MessageEvent)(messageEvent("C1", "U1", ts, "hi")),
+		want: &Message{TS: ts, UserID: "U1", ChannelID: "C1",
+			CreatedAt: strToTime(ts), Text: "hi", TeamID: "TFIGARO"},
+	}, {
+		name:  "thread reply",
+		event: (*nlopesslack.MessageEvent)(reply),
+		want: &Message{TS: ts, UserID: "U1", ChannelID: "C1",
+			CreatedAt: strToTime(ts), Text: "reply", ThreadTS: threadTS,
+			ParentUserID: "U2", IsReply: true, TeamID: "TFIGARO"},
+	}, {
+		name:  "edited message",
+		event: (*nlopesslack.MessageEvent)(edited),
+		want: &Message{TS: ts, UserID: "U1", ChannelID: "C1",
+			CreatedAt: strToTime(ts), EditedAt: strToTime(editTS), Text: "edited",
+			Type: "message_changed", TeamID: "TFIGARO"},
+	}, {
+		name:  "deleted message",
+		event: (*nlopesslack.MessageEvent)(deleted),
+		want: &Message{TS: ts, ChannelID: "C1", Type: "message_deleted",
+			TeamID: "TFIGARO"},
+	}, {
+		name:  "message in private channel",
+		event: (*nlopesslack.MessageEvent)(messageEvent("G1", "U1", ts, "hi")),
+	}, {
+		name:  "reaction added",
+		event: &added,
+		want: &Message{Type: "reaction_added", ChannelID: "C1", TS: ts,
+			UserID: "U1", Name: "eyes"},
+	}, {
+		name:  "reaction removed",
+		event: &removed,
+		want: &Message{Type: "reaction_removed", ChannelID: "C1", TS: ts,
+			UserID: "U1", Name: "eyes"},
+	}, {
+		name:  "reaction to file",
+		event: &file,
+	}, {
+		name:  "reaction in private channel",
+		event: &private,
+	}, {
+		name:  "member joined",
+		event: &nlopesslack.MemberJoinedChannelEvent{Type: "member_joined_channel", User: "U1", Channel: "C1"},
+		want:  &Message{Type: "member_joined_channel", ChannelID: "C1", UserID: "U1"},
+	}, {
+		name:  "member left",
+		event: &nlopesslack.MemberLeftChannelEvent{Type: "member_left_channel", User: "U1", Channel: "C1"},
+		want:  &Message{Type: "member_left_channel", ChannelID: "C1", UserID: "U1"},
+	}, {
+		name:  "member joined direct messages",
+		event: &nlopesslack.MemberJoinedChannelEvent{Type: "member_joined_channel", User: "U1", Channel: "D1"},
+	}, {
+		name:  "other event",
+		event: &nlopesslack.HelloEvent{},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan struct{})
+			go func() {
+				s.handleEvent(tt.event)
+				close(done)
+			}()
+			var got *Message
+			select {
+			case got = <-s.MessageCh():
+			case <-done:
+			}
+			<-done
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRTMEvents(t *testing.T) {
+	srv := slacktest.NewServer(historyFixtures(0))
+	defer srv.Close()
+	s := newTestSlack(t, srv, SlackModeRTM)
+	if err := srv.WaitForRTM(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.PostMessage(*messageEvent("C1", "U1", "", "hi")); err != nil {
+		t.Fatal(err)
+	}
+	err := srv.SendEvent(map[string]interface{}{
+		"type":     "reaction_added",
+		"user":     "U1",
+		"reaction": "eyes",
+		"item":     map[string]string{"type": "message", "channel": "C1", "ts": "1500000000.000100"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.ChangeMembership("C1", "U2", true); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for len(got) < 3 {
+		select {
+		case m := <-s.MessageCh():
+			got = append(got, m.Type+":"+m.ChannelID+":"+m.UserID)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got only %v", got)
+		}
+	}
+	want := []string{":C1:U1", "reaction_added:C1:U1", "member_joined_channel:C1:U2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestEventsAPIRetries(t *testing.T) {
+	srv := slacktest.NewServer(historyFixtures(0))
+	defer srv.Close()
+	s := newTestSlack(t, srv, SlackModeEvents)
+	hs := httptest.NewServer(http.HandlerFunc(s.EventsHandler))
+	defer hs.Close()
+	// post posts a message event with the ID as its text
+	post := func(id, retry string) int {
+		body := fmt.Sprintf(`{"type": "event_callback", "team_id": "TFIGARO",
+			"event_id": %q, "event": {"type": "message", "channel": "C1",
+			"user": "U1", "text": %q, "ts": "1500000000.000100"}}`, id, id)
+		req, err := http.NewRequest(http.MethodPost, hs.URL, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		slacktest.Sign(req.Header, "secret", []byte(body), time.Now())
+		if retry != "" {
+			req.Header.Set("X-Slack-Retry-Num", retry)
+			req.Header.Set("X-Slack-Retry-Reason", "http_timeout")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// Nobody reads MessageCh yet, requests are answered anyway
+	for _, req := range []struct{ id, retry string }{
+		{"Ev1", ""}, {"Ev1", "1"}, {"Ev2", "1"}, {"Ev1", "2"},
+	} {
+		if code := post(req.id, req.retry); code != http.StatusOK {
+			t.Fatalf("%s retry %q: got %d", req.id, req.retry, code)
+		}
+	}
+	var got []string
+	for len(got) < 2 {
+		select {
+		case m := <-s.MessageCh():
+			got = append(got, m.Text)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got only %v", got)
+		}
+	}
+	if !reflect.DeepEqual(got, []string{"Ev1", "Ev2"}) {
+		t.Errorf("got %v, want [Ev1 Ev2]", got)
+	}
+	select {
+	case m := <-s.MessageCh():
+		t.Errorf("retry of %s is not dropped", m.Text)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,43 @@
+package slacktest
+
+import (
+	"encoding/json"
+	"os"
+
+	nlopesslack "github.com/nlopes/slack"
+)
+
+// Fixtures is the data served by Server. It uses the Slack API types, so a
+// fixture file looks like Slack API responses:
+//
+//	{
+//	  "users": [{"id": "U1", "name": "bob", "profile": {"email": "bob@example.com"}}],
+//	  "channels": [{"id": "C1", "name": "general"}],
+//	  "history": {"C1": [{"type": "message", "user": "U1", "text": "hi", "ts": "1500000000.000100"}]},
+//	  "members": {"C1": ["U1"]}
+//	}
+//
+// Message timestamps must have the "%010d.%06d" form Slack uses.
+type Fixtures struct {
+	Users    []nlopesslack.User    `json:"users"`
+	Channels []nlopesslack.Channel `json:"channels"`
+	// History contains messages by channel ID in any order.
+	History map[string][]nlopesslack.Message `json:"history"`
+	// Members contains IDs of channel members by channel ID. Members of
+	// channels which are not here are taken from the channel "members".
+	Members map[string][]string `json:"members"`
+}
+
+// LoadFixtures reads fixtures from a JSON file.
+func LoadFixtures(path string) (*Fixtures, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fixtures := &Fixtures{}
+	if err := json.NewDecoder(f).Decode(fixtures); err != nil {
+		return nil, err
+	}
+	return fixtures, nil
+}
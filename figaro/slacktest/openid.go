@@ -0,0 +1,85 @@
+package slacktest
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	nlopesslack "github.com/nlopes/slack"
+)
+
+// openIDTokenPrefix makes access tokens of Sign in with Slack from user IDs.
+const openIDTokenPrefix = "xoxp-openid-"
+
+// authorize approves Sign in with Slack for SignInUser, or the first user
+// of the fixtures if it's empty, and redirects back with a code.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	redirect, err := url.Parse(r.FormValue("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	userID := s.SignInUser
+	if userID == "" && len(s.fixtures.Users) > 0 {
+		userID = s.fixtures.Users[0].ID
+	}
+	s.mu.Unlock()
+	q := redirect.Query()
+	if userID == "" {
+		q.Set("error", "access_denied")
+	} else {
+		// The code is the user ID, the stand-in doesn't need secrets
+		q.Set("code", userID)
+	}
+	q.Set("state", r.FormValue("state"))
+	redirect.RawQuery = q.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) openIDToken(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") == "" || r.FormValue("client_secret") == "" {
+		writeError(w, "invalid_client_id")
+		return
+	}
+	if _, ok := s.user(r.FormValue("code")); !ok {
+		writeError(w, "invalid_code")
+		return
+	}
+	writeOK(w, map[string]interface{}{
+		"access_token": openIDTokenPrefix + r.FormValue("code"),
+		"token_type":   "Bearer",
+	})
+}
+
+func (s *Server) openIDUserInfo(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !strings.HasPrefix(token, openIDTokenPrefix) {
+		writeError(w, "invalid_auth")
+		return
+	}
+	user, ok := s.user(strings.TrimPrefix(token, openIDTokenPrefix))
+	if !ok {
+		writeError(w, "invalid_auth")
+		return
+	}
+	writeOK(w, map[string]interface{}{
+		"sub":                       user.ID,
+		"https://slack.com/user_id": user.ID,
+		"https://slack.com/team_id": user.TeamID,
+		"email":                     user.Profile.Email,
+		"email_verified":            true,
+		"name":                      user.RealName,
+	})
+}
+
+func (s *Server) user(id string) (nlopesslack.User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.fixtures.Users {
+		if user.ID == id {
+			return user, true
+		}
+	}
+	return nlopesslack.User{}, false
+}
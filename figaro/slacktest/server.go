@@ -0,0 +1,626 @@
+// Package slacktest provides a local stand-in for the Slack Web API and RTM
+// which serves fixtures. It allows running Figaro against "Slack" without
+// internet access.
+package slacktest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	nlopesslack "github.com/nlopes/slack"
+)
+
+const (
+	defaultPageSize = 100
+	rtmQueueSize    = 64
+)
+
+// Server is a local stand-in for the Slack Web API and RTM. It implements
+// auth.test, users.list, conversations.list, conversations.history,
+// conversations.replies, conversations.members, chat.postMessage, the
+// legacy channels.list and channels.history, rtm.connect and rtm.start, and
+// Sign in with Slack. Point Slack to APIURL to use it.
+type Server struct {
+	// APIURL is the base URL of the Web API, for example
+	// http://127.0.0.1:34567/
+	APIURL string
+	// Token is the expected token. Any token is accepted if it's empty.
+	Token string
+	// PageSize limits the number of items returned by a single call.
+	PageSize int
+	// AuthorizeURL is the Sign in with Slack authorization endpoint.
+	AuthorizeURL string
+	// SignInUser is ID of the user who signs in with Slack. The first user
+	// of the fixtures signs in if it's empty.
+	SignInUser string
+	// TeamID is ID of the workspace, TFIGARO by default.
+	TeamID string
+
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+	mu       sync.Mutex
+	fixtures Fixtures
+	lastTS   time.Time
+	rtmConns map[chan []byte]struct{}
+	rtmCond  *sync.Cond
+}
+
+// NewServer starts a server which serves the fixtures.
+func NewServer(fixtures *Fixtures) *Server {
+	s := &Server{
+		PageSize: defaultPageSize,
+		TeamID:   "TFIGARO",
+		rtmConns: make(map[chan []byte]struct{}),
+	}
+	s.rtmCond = sync.NewCond(&s.mu)
+	s.upgrader.CheckOrigin = func(r *http.Request) bool { return true }
+	if fixtures != nil {
+		s.fixtures = *fixtures
+	}
+	if s.fixtures.History == nil {
+		s.fixtures.History = make(map[string][]nlopesslack.Message)
+	}
+	if s.fixtures.Members == nil {
+		s.fixtures.Members = make(map[string][]string)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth.test", s.auth(s.authTest))
+	mux.HandleFunc("/users.list", s.auth(s.usersList))
+	mux.HandleFunc("/channels.list", s.auth(s.channelsList))
+	mux.HandleFunc("/channels.history", s.auth(s.channelsHistory))
+	mux.HandleFunc("/conversations.list", s.auth(s.conversationsList))
+	mux.HandleFunc("/conversations.history", s.auth(s.conversationsHistory))
+	mux.HandleFunc("/conversations.replies", s.auth(s.conversationsReplies))
+	mux.HandleFunc("/conversations.members", s.auth(s.conversationsMembers))
+	mux.HandleFunc("/chat.postMessage", s.auth(s.chatPostMessage))
+	mux.HandleFunc("/rtm.connect", s.auth(s.rtmConnect))
+	mux.HandleFunc("/rtm.start", s.auth(s.rtmConnect))
+	mux.HandleFunc("/ws", s.rtm)
+	mux.HandleFunc("/openid/connect/authorize", s.authorize)
+	mux.HandleFunc("/openid.connect.token", s.openIDToken)
+	mux.HandleFunc("/openid.connect.userInfo", s.openIDUserInfo)
+	s.srv = httptest.NewServer(mux)
+	s.APIURL = s.srv.URL + "/"
+	s.AuthorizeURL = s.srv.URL + "/openid/connect/authorize"
+	log.Println("Slack stand-in: listening on", s.srv.URL)
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.srv.CloseClientConnections()
+	s.srv.Close()
+}
+
+// WaitForRTM blocks until at least one RTM client is connected.
+func (s *Server) WaitForRTM(timeout time.Duration) error {
+	timer := time.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		s.rtmCond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer timer.Stop()
+	deadline := time.Now().Add(timeout)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.rtmConns) == 0 {
+		if !time.Now().Before(deadline) {
+			return errors.New("slacktest: no RTM clients connected")
+		}
+		s.rtmCond.Wait()
+	}
+	return nil
+}
+
+// SendEvent sends an event to all RTM clients. The event must marshal to
+// a JSON object with a "type" field, for example nlopesslack.MessageEvent.
+func (s *Server) SendEvent(event interface{}) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.rtmConns {
+		select {
+		case ch <- data:
+		default:
+			log.Println("Slack stand-in: RTM client is too slow, event dropped")
+		}
+	}
+	return nil
+}
+
+// PostMessage adds the message to the channel history and sends it to RTM
+// clients. Missing type and timestamp are filled in.
+func (s *Server) PostMessage(msg nlopesslack.Message) error {
+	if msg.Type == "" {
+		msg.Type = "message"
+	}
+	s.mu.Lock()
+	if msg.Timestamp == "" {
+		msg.Timestamp = s.nextTS()
+	}
+	if msg.SubType == "" || msg.SubType == "thread_broadcast" {
+		s.fixtures.History[msg.Channel] = append(
+			s.fixtures.History[msg.Channel], msg)
+	}
+	s.mu.Unlock()
+	return s.SendEvent(msg)
+}
+
+// Messages returns the history of the channel, for example messages posted
+// with chat.postMessage.
+func (s *Server) Messages(chID string) []nlopesslack.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]nlopesslack.Message(nil), s.fixtures.History[chID]...)
+}
+
+// ChangeMembership adds the user to members of the channel or removes them
+// and sends member_joined_channel or member_left_channel to RTM clients.
+func (s *Server) ChangeMembership(chID, userID string, joined bool) error {
+	s.mu.Lock()
+	members := s.members(chID)
+	var changed []string
+	for _, id := range members {
+		if id != userID {
+			changed = append(changed, id)
+		}
+	}
+	typ := "member_left_channel"
+	if joined {
+		changed = append(changed, userID)
+		typ = "member_joined_channel"
+	}
+	s.fixtures.Members[chID] = changed
+	s.mu.Unlock()
+	return s.SendEvent(map[string]string{
+		"type":    typ,
+		"user":    userID,
+		"channel": chID,
+	})
+}
+
+// nextTS returns a unique timestamp in the Slack format. It must be called
+// under s.mu.
+func (s *Server) nextTS() string {
+	t := time.Now()
+	if !t.After(s.lastTS) {
+		t = s.lastTS.Add(time.Microsecond)
+	}
+	s.lastTS = t
+	return fmt.Sprintf("%010d.%06d", t.Unix(), t.Nanosecond()/1e3)
+}
+
+func (s *Server) auth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.FormValue("token")
+		if token == "" {
+			token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if token == "" {
+			writeError(w, "not_authed")
+			return
+		}
+		if s.Token != "" && token != s.Token {
+			writeError(w, "invalid_auth")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) authTest(w http.ResponseWriter, r *http.Request) {
+	writeOK(w, map[string]interface{}{
+		"url":     s.srv.URL + "/",
+		"team":    "Figaro",
+		"user":    "figaro",
+		"team_id": s.TeamID,
+		"user_id": "UFIGARO",
+	})
+}
+
+// chatPostMessage posts the text as a message of the app bot. Messages to
+// user IDs are kept in the history with the user ID as the channel ID.
+func (s *Server) chatPostMessage(w http.ResponseWriter, r *http.Request) {
+	chID := r.FormValue("channel")
+	if chID == "" {
+		writeError(w, "channel_not_found")
+		return
+	}
+	text := r.FormValue("text")
+	if text == "" {
+		writeError(w, "no_text")
+		return
+	}
+	msg := nlopesslack.Message{}
+	msg.Type = "message"
+	msg.SubType = "bot_message"
+	msg.BotID = "BFIGARO"
+	msg.Channel = chID
+	msg.Text = text
+	s.mu.Lock()
+	msg.Timestamp = s.nextTS()
+	s.fixtures.History[chID] = append(s.fixtures.History[chID], msg)
+	s.mu.Unlock()
+	if err := s.SendEvent(msg); err != nil {
+		log.Println("Slack stand-in: Cannot send event:", err)
+	}
+	writeOK(w, map[string]interface{}{
+		"channel": chID,
+		"ts":      msg.Timestamp,
+		"message": msg,
+	})
+}
+
+func (s *Server) usersList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	users := append([]nlopesslack.User(nil), s.fixtures.Users...)
+	s.mu.Unlock()
+	from, to, next, err := s.page(r, len(users))
+	if err != nil {
+		writeError(w, "invalid_cursor")
+		return
+	}
+	writeOK(w, map[string]interface{}{
+		"members":           users[from:to],
+		"response_metadata": map[string]string{"next_cursor": next},
+	})
+}
+
+func (s *Server) channelsList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	channels := append([]nlopesslack.Channel(nil), s.fixtures.Channels...)
+	s.mu.Unlock()
+	if r.FormValue("exclude_archived") == "1" ||
+		r.FormValue("exclude_archived") == "true" {
+		active := channels[:0]
+		for _, ch := range channels {
+			if !ch.IsArchived {
+				active = append(active, ch)
+			}
+		}
+		channels = active
+	}
+	writeOK(w, map[string]interface{}{"channels": channels})
+}
+
+// channelsHistory returns messages between oldest and latest newest first.
+// If there are more messages than count, it sets has_more, so the client
+// continues with latest set to the timestamp of the last returned message.
+func (s *Server) channelsHistory(w http.ResponseWriter, r *http.Request) {
+	history, ok := s.history(r)
+	if !ok {
+		writeError(w, "channel_not_found")
+		return
+	}
+	count := s.PageSize
+	if c, err := strconv.Atoi(r.FormValue("count")); err == nil && c > 0 && c < count {
+		count = c
+	}
+	hasMore := len(history) > count
+	if hasMore {
+		history = history[:count]
+	}
+	writeOK(w, map[string]interface{}{
+		"latest":   r.FormValue("latest"),
+		"messages": history,
+		"has_more": hasMore,
+	})
+}
+
+// conversationsHistory returns messages between oldest and latest newest
+// first paginated with cursors.
+func (s *Server) conversationsHistory(w http.ResponseWriter, r *http.Request) {
+	history, ok := s.history(r)
+	if !ok {
+		writeError(w, "channel_not_found")
+		return
+	}
+	from, to, next, err := s.page(r, len(history))
+	if err != nil {
+		writeError(w, "invalid_cursor")
+		return
+	}
+	writeOK(w, map[string]interface{}{
+		"messages":          history[from:to],
+		"has_more":          next != "",
+		"response_metadata": map[string]string{"next_cursor": next},
+	})
+}
+
+// conversationsReplies returns the thread parent message followed by its
+// replies between oldest and latest oldest first.
+func (s *Server) conversationsReplies(w http.ResponseWriter, r *http.Request) {
+	threadTS := r.FormValue("ts")
+	all, ok := s.messages(r.FormValue("channel"))
+	if !ok {
+		writeError(w, "channel_not_found")
+		return
+	}
+	var thread []nlopesslack.Message
+	for _, m := range all {
+		if m.Timestamp == threadTS || m.ThreadTimestamp == threadTS {
+			thread = append(thread, m)
+		}
+	}
+	if len(thread) == 0 {
+		writeError(w, "thread_not_found")
+		return
+	}
+	thread = between(thread, r)
+	// Replies are sorted oldest first
+	for i, j := 0, len(thread)-1; i < j; i, j = i+1, j-1 {
+		thread[i], thread[j] = thread[j], thread[i]
+	}
+	from, to, next, err := s.page(r, len(thread))
+	if err != nil {
+		writeError(w, "invalid_cursor")
+		return
+	}
+	writeOK(w, map[string]interface{}{
+		"messages":          thread[from:to],
+		"has_more":          next != "",
+		"response_metadata": map[string]string{"next_cursor": next},
+	})
+}
+
+// messages returns a copy of all messages of the channel sorted newest first
+// and false if the channel doesn't exist. Thread parents get reply_count.
+func (s *Server) messages(chID string) ([]nlopesslack.Message, bool) {
+	s.mu.Lock()
+	all, ok := s.fixtures.History[chID]
+	if !ok {
+		ok = s.hasChannel(chID)
+	}
+	all = append([]nlopesslack.Message(nil), all...)
+	s.mu.Unlock()
+	replyCounts := make(map[string]int)
+	for _, m := range all {
+		if isReply(m) {
+			replyCounts[m.ThreadTimestamp]++
+		}
+	}
+	for i := range all {
+		if n := replyCounts[all[i].Timestamp]; n > 0 {
+			all[i].ThreadTimestamp = all[i].Timestamp
+			all[i].ReplyCount = n
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Timestamp > all[j].Timestamp
+	})
+	return all, ok
+}
+
+func isReply(m nlopesslack.Message) bool {
+	return m.ThreadTimestamp != "" && m.ThreadTimestamp != m.Timestamp
+}
+
+// history returns top level messages of the requested channel between
+// oldest and latest sorted newest first and false if the channel doesn't
+// exist. Thread replies are returned by conversations.replies only, except
+// for ones also sent to the channel.
+func (s *Server) history(r *http.Request) ([]nlopesslack.Message, bool) {
+	all, ok := s.messages(r.FormValue("channel"))
+	if !ok {
+		return nil, false
+	}
+	var topLevel []nlopesslack.Message
+	for _, m := range all {
+		if !isReply(m) || m.SubType == "thread_broadcast" {
+			topLevel = append(topLevel, m)
+		}
+	}
+	return between(topLevel, r), true
+}
+
+// between returns messages between oldest and latest request parameters.
+func between(all []nlopesslack.Message, r *http.Request) []nlopesslack.Message {
+	latest := r.FormValue("latest")
+	oldest := r.FormValue("oldest")
+	inclusive := r.FormValue("inclusive") == "1" || r.FormValue("inclusive") == "true"
+	messages := []nlopesslack.Message{}
+	for _, m := range all {
+		if latest != "" && (m.Timestamp > latest || !inclusive && m.Timestamp == latest) {
+			continue
+		}
+		if oldest != "" && (m.Timestamp < oldest || !inclusive && m.Timestamp == oldest) {
+			continue
+		}
+		messages = append(messages, m)
+	}
+	return messages
+}
+
+func (s *Server) conversationsList(w http.ResponseWriter, r *http.Request) {
+	types := map[string]bool{"public_channel": true}
+	if t := r.FormValue("types"); t != "" {
+		types = make(map[string]bool)
+		for _, typ := range strings.Split(t, ",") {
+			types[strings.TrimSpace(typ)] = true
+		}
+	}
+	excludeArchived := r.FormValue("exclude_archived") == "1" ||
+		r.FormValue("exclude_archived") == "true"
+	s.mu.Lock()
+	var channels []nlopesslack.Channel
+	for _, ch := range s.fixtures.Channels {
+		if excludeArchived && ch.IsArchived || !types[conversationType(ch)] {
+			continue
+		}
+		channels = append(channels, ch)
+	}
+	s.mu.Unlock()
+	from, to, next, err := s.page(r, len(channels))
+	if err != nil {
+		writeError(w, "invalid_cursor")
+		return
+	}
+	writeOK(w, map[string]interface{}{
+		"channels":          channels[from:to],
+		"response_metadata": map[string]string{"next_cursor": next},
+	})
+}
+
+func (s *Server) conversationsMembers(w http.ResponseWriter, r *http.Request) {
+	chID := r.FormValue("channel")
+	s.mu.Lock()
+	ok := s.hasChannel(chID)
+	members := append([]string{}, s.members(chID)...)
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, "channel_not_found")
+		return
+	}
+	from, to, next, err := s.page(r, len(members))
+	if err != nil {
+		writeError(w, "invalid_cursor")
+		return
+	}
+	writeOK(w, map[string]interface{}{
+		"members":           members[from:to],
+		"response_metadata": map[string]string{"next_cursor": next},
+	})
+}
+
+// members returns IDs of members of the channel. It must be called under
+// s.mu.
+func (s *Server) members(chID string) []string {
+	if members, ok := s.fixtures.Members[chID]; ok {
+		return members
+	}
+	for _, ch := range s.fixtures.Channels {
+		if ch.ID == chID {
+			return ch.Members
+		}
+	}
+	return nil
+}
+
+// conversationType returns the conversations.list type of a channel.
+func conversationType(ch nlopesslack.Channel) string {
+	switch {
+	case ch.IsIM:
+		return "im"
+	case ch.IsMpIM:
+		return "mpim"
+	case ch.IsPrivate || ch.IsGroup:
+		return "private_channel"
+	}
+	return "public_channel"
+}
+
+// hasChannel must be called under s.mu.
+func (s *Server) hasChannel(id string) bool {
+	for _, ch := range s.fixtures.Channels {
+		if ch.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) rtmConnect(w http.ResponseWriter, r *http.Request) {
+	wsURL := "ws" + strings.TrimPrefix(s.srv.URL, "http") + "/ws"
+	writeOK(w, map[string]interface{}{
+		"url":  wsURL,
+		"self": map[string]string{"id": "UFIGARO", "name": "figaro"},
+		"team": map[string]string{"id": s.TeamID, "name": "Figaro", "domain": "figaro"},
+	})
+}
+
+func (s *Server) rtm(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Slack stand-in: cannot upgrade:", err)
+		return
+	}
+	defer conn.Close()
+	out := make(chan []byte, rtmQueueSize)
+	out <- []byte(`{"type":"hello"}`)
+	s.mu.Lock()
+	s.rtmConns[out] = struct{}{}
+	s.rtmCond.Broadcast()
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.rtmConns, out)
+		s.mu.Unlock()
+	}()
+	// Answer pings
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			var msg struct {
+				ID   int    `json:"id"`
+				Type string `json:"type"`
+			}
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type == "ping" {
+				pong := fmt.Sprintf(`{"type":"pong","reply_to":%d}`, msg.ID)
+				select {
+				case out <- []byte(pong):
+				default:
+				}
+			}
+		}
+	}()
+	for {
+		select {
+		case data := <-out:
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// page returns bounds of the current page of n items for a cursor based
+// method and the next cursor. Cursors are offsets.
+func (s *Server) page(r *http.Request, n int) (from, to int, next string, err error) {
+	if cursor := r.FormValue("cursor"); cursor != "" {
+		if from, err = strconv.Atoi(cursor); err != nil || from < 0 || from > n {
+			return 0, 0, "", errors.New("invalid cursor")
+		}
+	}
+	limit := s.PageSize
+	if l, err := strconv.Atoi(r.FormValue("limit")); err == nil && l > 0 && l < limit {
+		limit = l
+	}
+	to = from + limit
+	if to >= n {
+		return from, n, "", nil
+	}
+	return from, to, strconv.Itoa(to), nil
+}
+
+func writeOK(w http.ResponseWriter, fields map[string]interface{}) {
+	fields["ok"] = true
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fields)
+}
+
+func writeError(w http.ResponseWriter, slackErr string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":    false,
+		"error": slackErr,
+	})
+}
@@ -0,0 +1,139 @@
+// Package smtptest provides a local stand-in for an SMTP server which keeps
+// the mail it receives. It allows testing email alerts without a mail
+// server.
+package smtptest
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Mail is a message received by the server.
+type Mail struct {
+	From string
+	To   []string
+	Data string // Headers and body with CRLF line endings
+}
+
+// Server is a local stand-in for an SMTP server. It implements HELO, EHLO,
+// MAIL, RCPT, DATA, RSET, NOOP and QUIT without authentication and TLS.
+type Server struct {
+	// Addr is host:port the server listens on, for example
+	// 127.0.0.1:34567
+	Addr string
+
+	ln   net.Listener
+	mu   sync.Mutex
+	mail []Mail
+}
+
+// NewServer starts a server on a random local port.
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Addr: ln.Addr().String(), ln: ln}
+	go s.serve()
+	log.Println("SMTP stand-in: listening on", s.Addr)
+	return s, nil
+}
+
+// Close shuts down the server.
+func (s *Server) Close() error {
+	return s.ln.Close()
+}
+
+// Mail returns the mail received so far.
+func (s *Server) Mail() []Mail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Mail(nil), s.mail...)
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle talks SMTP with a client till it quits.
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	reply := func(line string) {
+		w.WriteString(line + "\r\n")
+		w.Flush()
+	}
+	reply("220 localhost SMTP stand-in")
+	var mail Mail
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(line)
+		if i := strings.Index(verb, " "); i >= 0 {
+			verb = verb[:i]
+		}
+		switch verb {
+		case "HELO", "EHLO":
+			reply("250 localhost")
+		case "MAIL":
+			mail = Mail{From: address(line)}
+			reply("250 OK")
+		case "RCPT":
+			mail.To = append(mail.To, address(line))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" || line == ".\n" {
+					break
+				}
+				// Leading dots are doubled by clients
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			mail.Data = data.String()
+			s.mu.Lock()
+			s.mail = append(s.mail, mail)
+			s.mu.Unlock()
+			mail = Mail{}
+			reply("250 OK")
+		case "RSET":
+			mail = Mail{}
+			reply("250 OK")
+		case "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// address returns the address in angle brackets of a MAIL or RCPT command.
+func address(line string) string {
+	start := strings.Index(line, "<")
+	end := strings.LastIndex(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
@@ -0,0 +1,65 @@
+package figaro
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// storageDriver adapts Storage to a database. Queries in storage_queries.go
+// are written for PostgreSQL, other drivers rebind them to their dialect.
+type storageDriver struct {
+	// name is the database/sql driver name.
+	name string
+	// dsn converts the Dbaddr URL to the data source name of the driver.
+	dsn        func(connURL string) string
+	maxConns   int
+	migrations []migration
+	// createMigrationsTable creates figaro.schema_migrations.
+	createMigrationsTable string
+	// lock and unlock keep replicas from migrating at the same time. They
+	// are empty if the database doesn't need it.
+	lock, unlock string
+	rebind       func(query string) string
+	// array converts a slice to the argument of "= ANY($N)".
+	array func(a []string) interface{}
+}
+
+var postgresDriver = &storageDriver{
+	name:                  "postgres",
+	dsn:                   func(connURL string) string { return connURL },
+	maxConns:              maxDBConn,
+	migrations:            postgresMigrations,
+	createMigrationsTable: queryCreateMigrationsTable,
+	lock:                  queryLockMigrations,
+	unlock:                queryUnlockMigrations,
+	rebind:                func(query string) string { return query },
+	array:                 func(a []string) interface{} { return pq.Array(a) },
+}
+
+// storageDrivers maps Dbaddr URL schemes to drivers.
+var storageDrivers = map[string]*storageDriver{
+	"postgres":   postgresDriver,
+	"postgresql": postgresDriver,
+}
+
+// openDB opens the database with the URL connection using the driver
+// selected by the URL scheme.
+func openDB(connURL string) (*sql.DB, *storageDriver, error) {
+	i := strings.Index(connURL, ":")
+	if i < 0 {
+		return nil, nil, fmt.Errorf("no scheme in DB address %q", connURL)
+	}
+	d, ok := storageDrivers[connURL[:i]]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown DB address scheme %q", connURL[:i])
+	}
+	db, err := sql.Open(d.name, d.dsn(connURL))
+	if err != nil {
+		return nil, nil, err
+	}
+	db.SetMaxOpenConns(d.maxConns)
+	return db, d, nil
+}
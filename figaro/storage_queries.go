@@ -1,10 +1,38 @@
 package figaro
 
-// Schema
-const queryCreateSchema = `--Creates Figaro's schema
+// Migrations
+const queryCreateMigrationsTable = `--Creates the table of applied migrations
 CREATE SCHEMA IF NOT EXISTS figaro;
+CREATE TABLE IF NOT EXISTS figaro.schema_migrations (
+	version		INTEGER PRIMARY KEY,
+	name		VARCHAR NOT NULL,
+	applied_at	TIMESTAMP NOT NULL DEFAULT now()
+);
+`
+
+const queryLockMigrations = `--Waits for other replicas to finish migrations
+SELECT pg_advisory_lock($1);
+`
+
+const queryUnlockMigrations = `--Lets other replicas run migrations
+SELECT pg_advisory_unlock($1);
+`
+
+const queryGetSchemaVersion = `--Returns the version of the last applied migration
+SELECT COALESCE(MAX(version), 0) FROM figaro.schema_migrations;
+`
+
+const queryAddMigration = `--Records an applied migration
+INSERT INTO figaro.schema_migrations (version, name) VALUES ($1, $2);
+`
 
---Creates table for Slack users
+const queryRemoveMigration = `--Records a reverted migration
+DELETE FROM figaro.schema_migrations WHERE version = $1;
+`
+
+// queryMigrate1Up creates the schema created before migrations were
+// introduced, so it is safe to run against existing databases.
+const queryMigrate1Up = `--Creates table for Slack users
 CREATE TABLE IF NOT EXISTS figaro.users (
 	user_id		VARCHAR,
 	name 		VARCHAR,
@@ -38,51 +66,426 @@ CREATE INDEX IF NOT EXISTS channels_name_idx
 	ON figaro.channels (name);
 `
 
+const queryMigrate1Down = `--Drops Figaro's tables
+DROP TABLE IF EXISTS figaro.channels;
+DROP TABLE IF EXISTS figaro.messages;
+DROP TABLE IF EXISTS figaro.users;
+`
+
+const queryMigrate2Up = `--Adds conversation types and sharing to channels
+ALTER TABLE figaro.channels ADD COLUMN IF NOT EXISTS type VARCHAR
+	NOT NULL DEFAULT 'public_channel';
+ALTER TABLE figaro.channels ADD COLUMN IF NOT EXISTS shared BOOLEAN
+	NOT NULL DEFAULT FALSE;
+ALTER TABLE figaro.channels ADD COLUMN IF NOT EXISTS ext_shared BOOLEAN
+	NOT NULL DEFAULT FALSE;
+`
+
+const queryMigrate2Down = `--Removes conversation types and sharing from channels
+ALTER TABLE figaro.channels DROP COLUMN IF EXISTS ext_shared;
+ALTER TABLE figaro.channels DROP COLUMN IF EXISTS shared;
+ALTER TABLE figaro.channels DROP COLUMN IF EXISTS type;
+`
+
+const queryMigrate3Up = `--Adds thread replies to messages
+ALTER TABLE figaro.messages ADD COLUMN IF NOT EXISTS thread_ts VARCHAR
+	NOT NULL DEFAULT '';
+ALTER TABLE figaro.messages ADD COLUMN IF NOT EXISTS parent_user_id VARCHAR
+	NOT NULL DEFAULT '';
+ALTER TABLE figaro.messages ADD COLUMN IF NOT EXISTS is_reply BOOLEAN
+	NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS messages_channel_id_thread_ts_idx
+	ON figaro.messages (channel_id, thread_ts);
+`
+
+const queryMigrate3Down = `--Removes thread replies from messages
+DROP INDEX IF EXISTS figaro.messages_channel_id_thread_ts_idx;
+ALTER TABLE figaro.messages DROP COLUMN IF EXISTS is_reply;
+ALTER TABLE figaro.messages DROP COLUMN IF EXISTS parent_user_id;
+ALTER TABLE figaro.messages DROP COLUMN IF EXISTS thread_ts;
+`
+
+const queryMigrate4Up = `--Identifies messages by Slack timestamps. Messages stored
+--before it was introduced get it from created_at.
+ALTER TABLE figaro.messages ADD COLUMN IF NOT EXISTS ts VARCHAR;
+UPDATE figaro.messages SET ts =
+	lpad(floor(extract(epoch FROM created_at))::BIGINT::TEXT, 10, '0') || '.' ||
+	lpad((extract(microseconds FROM created_at)::BIGINT % 1000000)::TEXT, 6, '0')
+WHERE ts IS NULL;
+ALTER TABLE figaro.messages ALTER COLUMN ts SET NOT NULL;
+DROP INDEX IF EXISTS figaro.messages_user_id_channel_id_created_at_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS messages_channel_id_ts_idx
+	ON figaro.messages (channel_id, ts);
+
+--Adds edits and deletions to messages
+ALTER TABLE figaro.messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP;
+ALTER TABLE figaro.messages ADD COLUMN IF NOT EXISTS deleted BOOLEAN
+	NOT NULL DEFAULT FALSE;
+`
+
+const queryMigrate4Down = `--Removes edits, deletions and Slack timestamps from messages
+DELETE FROM figaro.messages WHERE deleted;
+ALTER TABLE figaro.messages DROP COLUMN IF EXISTS deleted;
+ALTER TABLE figaro.messages DROP COLUMN IF EXISTS edited_at;
+DROP INDEX IF EXISTS figaro.messages_channel_id_ts_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS messages_user_id_channel_id_created_at_idx 
+	ON figaro.messages (user_id, channel_id, created_at);
+ALTER TABLE figaro.messages DROP COLUMN IF EXISTS ts;
+`
+
+const queryMigrate5Up = `--Creates table for reactions to Slack messages
+CREATE TABLE IF NOT EXISTS figaro.reactions (
+	channel_id	VARCHAR NOT NULL,
+	ts			VARCHAR NOT NULL,
+	user_id		VARCHAR NOT NULL,
+	reaction	VARCHAR NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS reactions_channel_id_ts_user_id_reaction_idx
+	ON figaro.reactions (channel_id, ts, user_id, reaction);
+`
+
+const queryMigrate5Down = `--Drops table for reactions
+DROP TABLE IF EXISTS figaro.reactions;
+`
+
+const queryMigrate6Up = `--Creates table for members of Slack channels
+CREATE TABLE IF NOT EXISTS figaro.members (
+	channel_id	VARCHAR NOT NULL,
+	user_id		VARCHAR NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS members_channel_id_user_id_idx
+	ON figaro.members (channel_id, user_id);
+CREATE INDEX IF NOT EXISTS members_user_id_idx ON figaro.members (user_id);
+`
+
+const queryMigrate6Down = `--Drops table for members
+DROP TABLE IF EXISTS figaro.members;
+`
+
+const queryMigrate7Up = `--Creates table for boards
+CREATE TABLE IF NOT EXISTS figaro.boards (
+	name			VARCHAR PRIMARY KEY,
+	include			TEXT NOT NULL DEFAULT '[]',
+	exclude			TEXT NOT NULL DEFAULT '[]',
+	channels		TEXT NOT NULL DEFAULT '[]',
+	message_limit	INTEGER NOT NULL DEFAULT 0,
+	domains			TEXT NOT NULL DEFAULT '[]'
+);
+`
+
+const queryMigrate7Down = `--Drops table for boards
+DROP TABLE IF EXISTS figaro.boards;
+`
+
+const queryMigrate8Up = `--Adds Slack workspaces to users, channels, messages and boards
+ALTER TABLE figaro.users ADD COLUMN IF NOT EXISTS team_id VARCHAR
+	NOT NULL DEFAULT '';
+ALTER TABLE figaro.channels ADD COLUMN IF NOT EXISTS team_id VARCHAR
+	NOT NULL DEFAULT '';
+ALTER TABLE figaro.messages ADD COLUMN IF NOT EXISTS team_id VARCHAR
+	NOT NULL DEFAULT '';
+ALTER TABLE figaro.boards ADD COLUMN IF NOT EXISTS teams TEXT
+	NOT NULL DEFAULT '[]';
+`
+
+const queryMigrate8Down = `--Removes Slack workspaces
+ALTER TABLE figaro.boards DROP COLUMN IF EXISTS teams;
+ALTER TABLE figaro.messages DROP COLUMN IF EXISTS team_id;
+ALTER TABLE figaro.channels DROP COLUMN IF EXISTS team_id;
+ALTER TABLE figaro.users DROP COLUMN IF EXISTS team_id;
+`
+
+const queryMigrate9Up = `--Adds roles to users
+ALTER TABLE figaro.users ADD COLUMN IF NOT EXISTS role VARCHAR
+	NOT NULL DEFAULT '';
+`
+
+const queryMigrate9Down = `--Removes roles of users
+ALTER TABLE figaro.users DROP COLUMN IF EXISTS role;
+`
+
+const queryMigrate10Up = `--Creates table for guest questions and first responses to them
+CREATE TABLE IF NOT EXISTS figaro.responses (
+	channel_id		VARCHAR NOT NULL,
+	ts				VARCHAR NOT NULL,
+	user_id			VARCHAR NOT NULL,
+	asked_at		TIMESTAMP NOT NULL,
+	response_ts		VARCHAR NOT NULL DEFAULT '',
+	responder_id	VARCHAR NOT NULL DEFAULT '',
+	responded_at	TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS responses_channel_id_ts_idx
+	ON figaro.responses (channel_id, ts);
+CREATE INDEX IF NOT EXISTS responses_channel_id_asked_at_idx
+	ON figaro.responses (channel_id, asked_at);
+CREATE INDEX IF NOT EXISTS responses_asked_at_idx
+	ON figaro.responses (asked_at);
+
+--Adds SLA of channels in seconds
+ALTER TABLE figaro.channels ADD COLUMN IF NOT EXISTS sla INTEGER
+	NOT NULL DEFAULT 0;
+`
+
+const queryMigrate10Down = `--Drops table for responses and SLA of channels
+ALTER TABLE figaro.channels DROP COLUMN IF EXISTS sla;
+DROP TABLE IF EXISTS figaro.responses;
+`
+
+const queryMigrate11Up = `--Creates table for business hours calendars
+CREATE TABLE IF NOT EXISTS figaro.calendars (
+	name		VARCHAR PRIMARY KEY,
+	time_zone	VARCHAR NOT NULL DEFAULT '',
+	hours		TEXT NOT NULL DEFAULT '[]',
+	holidays	TEXT NOT NULL DEFAULT ''
+);
+
+--Attaches calendars to channels and boards
+ALTER TABLE figaro.channels ADD COLUMN IF NOT EXISTS calendar VARCHAR
+	NOT NULL DEFAULT '';
+ALTER TABLE figaro.boards ADD COLUMN IF NOT EXISTS calendar VARCHAR
+	NOT NULL DEFAULT '';
+`
+
+const queryMigrate11Down = `--Drops table for calendars
+ALTER TABLE figaro.boards DROP COLUMN IF EXISTS calendar;
+ALTER TABLE figaro.channels DROP COLUMN IF EXISTS calendar;
+DROP TABLE IF EXISTS figaro.calendars;
+`
+
+const queryMigrate12Up = `--Creates table for alerts sent about unanswered questions
+CREATE TABLE IF NOT EXISTS figaro.notifications (
+	channel_id	VARCHAR PRIMARY KEY,
+	asked_at	TIMESTAMP NOT NULL,
+	sent_at		TIMESTAMP NOT NULL,
+	count		INTEGER NOT NULL DEFAULT 0
+);
+`
+
+const queryMigrate12Down = `--Drops table for alerts
+DROP TABLE IF EXISTS figaro.notifications;
+`
+
+const queryMigrate13Up = `--Creates table for outbound webhooks
+CREATE TABLE IF NOT EXISTS figaro.webhooks (
+	name		VARCHAR PRIMARY KEY,
+	url			VARCHAR NOT NULL,
+	secret		VARCHAR NOT NULL DEFAULT '',
+	board		VARCHAR NOT NULL DEFAULT '',
+	events		TEXT NOT NULL DEFAULT '[]'
+);
+
+--Creates table for the delivery log of webhooks
+CREATE TABLE IF NOT EXISTS figaro.deliveries (
+	id				BIGSERIAL PRIMARY KEY,
+	webhook			VARCHAR NOT NULL,
+	event			VARCHAR NOT NULL,
+	payload			TEXT NOT NULL,
+	status			VARCHAR NOT NULL,
+	attempts		INTEGER NOT NULL DEFAULT 0,
+	response_code	INTEGER NOT NULL DEFAULT 0,
+	last_error		TEXT NOT NULL DEFAULT '',
+	created_at		TIMESTAMP NOT NULL,
+	next_attempt_at	TIMESTAMP NOT NULL,
+	delivered_at	TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS deliveries_webhook_id_idx
+	ON figaro.deliveries (webhook, id);
+CREATE INDEX IF NOT EXISTS deliveries_status_next_attempt_at_idx
+	ON figaro.deliveries (status, next_attempt_at);
+`
+
+const queryMigrate13Down = `--Drops tables for webhooks
+DROP TABLE IF EXISTS figaro.deliveries;
+DROP TABLE IF EXISTS figaro.webhooks;
+`
+
+const queryMigrate14Up = `--Adds boards to responses, boards derive questions with their own domains
+ALTER TABLE figaro.responses ADD COLUMN IF NOT EXISTS board VARCHAR
+	NOT NULL DEFAULT 'default';
+DROP INDEX IF EXISTS figaro.responses_channel_id_ts_idx;
+DROP INDEX IF EXISTS figaro.responses_channel_id_asked_at_idx;
+DROP INDEX IF EXISTS figaro.responses_asked_at_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS responses_board_channel_id_ts_idx
+	ON figaro.responses (board, channel_id, ts);
+CREATE INDEX IF NOT EXISTS responses_board_channel_id_asked_at_idx
+	ON figaro.responses (board, channel_id, asked_at);
+CREATE INDEX IF NOT EXISTS responses_board_asked_at_idx
+	ON figaro.responses (board, asked_at);
+`
+
+const queryMigrate14Down = `--Keeps responses of the default board only
+DELETE FROM figaro.responses WHERE board <> 'default';
+DROP INDEX IF EXISTS figaro.responses_board_channel_id_ts_idx;
+DROP INDEX IF EXISTS figaro.responses_board_channel_id_asked_at_idx;
+DROP INDEX IF EXISTS figaro.responses_board_asked_at_idx;
+ALTER TABLE figaro.responses DROP COLUMN IF EXISTS board;
+CREATE UNIQUE INDEX IF NOT EXISTS responses_channel_id_ts_idx
+	ON figaro.responses (channel_id, ts);
+CREATE INDEX IF NOT EXISTS responses_channel_id_asked_at_idx
+	ON figaro.responses (channel_id, asked_at);
+CREATE INDEX IF NOT EXISTS responses_asked_at_idx
+	ON figaro.responses (asked_at);
+`
+
 // Queries
 const queryUpdateUser = `--Creates user, if user exists, then update
-INSERT INTO figaro.users VALUES ($1, $2, $3, $4) 
-ON CONFLICT(user_id) DO UPDATE SET (name, full_name, email)=($2, $3, $4);
+INSERT INTO figaro.users (user_id, name, full_name, email, team_id, role)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT(user_id) DO UPDATE
+SET (name, full_name, email, team_id, role) = ($2, $3, $4, $5, $6);
 `
 
 const queryGetUsers = `--Returns users by user IDs
-SELECT * FROM figaro.users WHERE user_id IN $1;
+SELECT user_id, name, full_name, email, team_id, role
+FROM figaro.users WHERE user_id = ANY($1);
 `
 
 const queryCountUsers = `--Counts users
 SELECT COUNT(*) FROM figaro.users;
 `
 
-const queryUpdateMessage = `--Creates message, if message with the same user_id, 
---channel_id and careated_at exists, then update text
-INSERT INTO figaro.messages VALUES ($1, $2, $3, $4)
-ON CONFLICT(user_id, channel_id, created_at) DO UPDATE SET message_text = $4;
+const queryUpdateMessage = `--Creates message, if message with the same channel_id
+--and ts exists, then update it
+INSERT INTO figaro.messages (ts, user_id, channel_id, created_at, message_text,
+	thread_ts, parent_user_id, is_reply, edited_at, team_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT(channel_id, ts) DO UPDATE
+SET (user_id, created_at, message_text, thread_ts, parent_user_id, is_reply,
+	edited_at, team_id) = ($2, $4, $5, $6, $7, $8, $9, $10);
+`
+
+const queryMessageExists = `--Tells if the message is stored
+SELECT EXISTS (SELECT 1 FROM figaro.messages WHERE channel_id = $1 AND ts = $2);
+`
+
+const queryEditMessage = `--Updates text of an edited message. Keeps the
+--edit time if the new one is unknown.
+UPDATE figaro.messages
+SET (message_text, edited_at) = ($3, COALESCE($4, edited_at))
+WHERE channel_id = $1 AND ts = $2;
+`
+
+const queryDeleteMessage = `--Marks a message as deleted.
+UPDATE figaro.messages SET deleted = TRUE
+WHERE channel_id = $1 AND ts = $2;
 `
 
 const queryGetMessagesByChannel = `--Returns limited amount of messages for a 
---channel sorted descendingly by created_at.
-SELECT * FROM figaro.messages
-WHERE figaro.messages.channel_id = $1
+--channel sorted descendingly by created_at. Thread replies are included.
+SELECT ts, user_id, channel_id, created_at, edited_at, message_text,
+	thread_ts, parent_user_id, is_reply, team_id
+FROM figaro.messages
+WHERE figaro.messages.channel_id = $1 AND NOT figaro.messages.deleted
 ORDER BY figaro.messages.created_at DESC LIMIT $2;
 `
 
+const queryGetMessagesSince = `--Returns messages of a channel created at or
+--after the time sorted ascendingly by created_at.
+SELECT ts, user_id, channel_id, created_at, edited_at, message_text,
+	thread_ts, parent_user_id, is_reply, team_id
+FROM figaro.messages
+WHERE channel_id = $1 AND created_at >= $2 AND NOT deleted
+ORDER BY created_at, ts;
+`
+
+const queryGetMessagesBetween = `--Returns messages of a channel or of all
+--channels if the channel ID is empty created from the first time till the
+--second one sorted ascendingly by created_at.
+SELECT ts, user_id, channel_id, created_at, edited_at, message_text,
+	thread_ts, parent_user_id, is_reply, team_id
+FROM figaro.messages
+WHERE ($1 = '' OR channel_id = $1) AND created_at >= $2 AND created_at < $3
+	AND NOT deleted
+ORDER BY created_at, channel_id, ts;
+`
+
 const queryGetLastMessageTS = `--Returns a timestamp of the channel's last message.
 SELECT created_at FROM figaro.messages
 WHERE channel_id = $1 ORDER BY created_at DESC LIMIT 1;
 `
 
+const queryAddReaction = `--Adds a reaction to a message.
+INSERT INTO figaro.reactions (channel_id, ts, user_id, reaction)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT(channel_id, ts, user_id, reaction) DO NOTHING;
+`
+
+const queryRemoveReaction = `--Removes a reaction from a message.
+DELETE FROM figaro.reactions
+WHERE channel_id = $1 AND ts = $2 AND user_id = $3 AND reaction = $4;
+`
+
+const queryRemoveReactions = `--Removes all reactions from a message.
+DELETE FROM figaro.reactions WHERE channel_id = $1 AND ts = $2;
+`
+
+const queryGetReactions = `--Returns reactions to messages of a channel.
+SELECT ts, reaction, user_id FROM figaro.reactions
+WHERE channel_id = $1 AND ts = ANY($2)
+ORDER BY ts, reaction, user_id;
+`
+
+const queryAddMember = `--Adds a member to a channel.
+INSERT INTO figaro.members (channel_id, user_id) VALUES ($1, $2)
+ON CONFLICT(channel_id, user_id) DO NOTHING;
+`
+
+const queryRemoveMember = `--Removes a member from a channel.
+DELETE FROM figaro.members WHERE channel_id = $1 AND user_id = $2;
+`
+
+const queryRemoveMembers = `--Removes all members from a channel.
+DELETE FROM figaro.members WHERE channel_id = $1;
+`
+
+const queryGetMembers = `--Returns IDs of members of a channel.
+SELECT user_id FROM figaro.members WHERE channel_id = $1 ORDER BY user_id;
+`
+
+const queryUpdateBoard = `--Creates board, if board exists, then update.
+INSERT INTO figaro.boards
+	(name, include, exclude, channels, message_limit, domains, teams, calendar)
+VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT(name) DO UPDATE
+SET (include, exclude, channels, message_limit, domains, teams, calendar) =
+	($2, $3, $4, $5, $6, $7, $8);
+`
+
+const queryDeleteBoard = `--Deletes board.
+DELETE FROM figaro.boards WHERE name = $1;
+`
+
+const queryGetBoards = `--Returns all boards.
+SELECT name, include, exclude, channels, message_limit, domains, teams,
+	calendar
+FROM figaro.boards ORDER BY name;
+`
+
 const queryCountMessages = `--Counts messages.
 SELECT COUNT(*) FROM figaro.messages;
 `
 
 const queryUpdateChannel = `--Creates channel, if channel exists, then update.
-INSERT INTO figaro.channels VALUES($1, $2, FALSE, $3)
-ON CONFLICT(channel_id) DO UPDATE SET (name, archived) = ($2, $3);
+INSERT INTO figaro.channels
+	(channel_id, name, ok, archived, type, shared, ext_shared, team_id)
+VALUES($1, $2, FALSE, $3, $4, $5, $6, $7)
+ON CONFLICT(channel_id) DO UPDATE
+SET (name, archived, type, shared, ext_shared, team_id) =
+	($2, $3, $4, $5, $6, $7);
 `
 
 const queryUpdateChannelStatus = `--Updates channel status.
 UPDATE figaro.channels SET ok = $2 WHERE channel_id = $1;
 `
 
+const queryUpdateChannelSLA = `--Updates SLA of a channel.
+UPDATE figaro.channels SET sla = $2 WHERE channel_id = $1;
+`
+
+const queryUpdateChannelCalendar = `--Attaches a calendar to a channel.
+UPDATE figaro.channels SET calendar = $2 WHERE channel_id = $1;
+`
+
 const queryUpdateChannelArch = `--Archives or unarchives a channel.
 UPDATE figaro.channels SET archived = $2 WHERE channel_id = $1;
 `
@@ -92,13 +495,156 @@ UPDATE figaro.channels SET name = $2 WHERE channel_id = $1;
 `
 
 const queryGetChannel = `--Returns channel by its ID.
-SELECT * FROM figaro.channels WHERE channel_id = $1
+SELECT channel_id, name, ok, archived, type, shared, ext_shared, team_id, sla,
+	calendar
+FROM figaro.channels WHERE channel_id = $1
 `
 
 const queryGetChannels = `--Returns all.
-SELECT * FROM figaro.channels;
+SELECT channel_id, name, ok, archived, type, shared, ext_shared, team_id, sla,
+	calendar
+FROM figaro.channels;
 `
 
 const queryCountChannels = `--Counts channels
 SELECT COUNT(*) FROM figaro.channels;
 `
+
+const queryUpdateCalendar = `--Creates calendar, if calendar exists, then update.
+INSERT INTO figaro.calendars (name, time_zone, hours, holidays)
+VALUES($1, $2, $3, $4)
+ON CONFLICT(name) DO UPDATE
+SET (time_zone, hours, holidays) = ($2, $3, $4);
+`
+
+const queryDeleteCalendar = `--Deletes calendar.
+DELETE FROM figaro.calendars WHERE name = $1;
+`
+
+const queryGetCalendars = `--Returns all calendars.
+SELECT name, time_zone, hours, holidays FROM figaro.calendars ORDER BY name;
+`
+
+const queryUpdateNotification = `--Creates notification of a channel, if it
+--exists, then update.
+INSERT INTO figaro.notifications (channel_id, asked_at, sent_at, count)
+VALUES($1, $2, $3, $4)
+ON CONFLICT(channel_id) DO UPDATE
+SET (asked_at, sent_at, count) = ($2, $3, $4);
+`
+
+const queryDeleteNotification = `--Deletes notification of a channel.
+DELETE FROM figaro.notifications WHERE channel_id = $1;
+`
+
+const queryGetNotifications = `--Returns all notifications.
+SELECT channel_id, asked_at, sent_at, count FROM figaro.notifications
+ORDER BY channel_id;
+`
+
+const queryUpdateWebhook = `--Creates webhook, if webhook exists, then update.
+INSERT INTO figaro.webhooks (name, url, secret, board, events)
+VALUES($1, $2, $3, $4, $5)
+ON CONFLICT(name) DO UPDATE
+SET (url, secret, board, events) = ($2, $3, $4, $5);
+`
+
+const queryDeleteWebhook = `--Deletes webhook.
+DELETE FROM figaro.webhooks WHERE name = $1;
+`
+
+const queryGetWebhooks = `--Returns all webhooks.
+SELECT name, url, secret, board, events FROM figaro.webhooks ORDER BY name;
+`
+
+const queryAddDelivery = `--Adds delivery and returns its ID.
+INSERT INTO figaro.deliveries (webhook, event, payload, status, attempts,
+	response_code, last_error, created_at, next_attempt_at, delivered_at)
+VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING id;
+`
+
+const queryUpdateDelivery = `--Updates the outcome of a delivery.
+UPDATE figaro.deliveries
+SET (status, attempts, response_code, last_error, next_attempt_at,
+	delivered_at) = ($2, $3, $4, $5, $6, $7)
+WHERE id = $1;
+`
+
+const queryGetDelivery = `--Returns delivery by its ID.
+SELECT id, webhook, event, payload, status, attempts, response_code,
+	last_error, created_at, next_attempt_at, delivered_at
+FROM figaro.deliveries WHERE id = $1;
+`
+
+const queryGetDeliveries = `--Returns last deliveries of a webhook.
+SELECT id, webhook, event, payload, status, attempts, response_code,
+	last_error, created_at, next_attempt_at, delivered_at
+FROM figaro.deliveries WHERE webhook = $1
+ORDER BY id DESC LIMIT $2;
+`
+
+const queryGetDueDeliveries = `--Returns pending deliveries due at the time, up to
+--the limit for every webhook.
+SELECT id, webhook, event, payload, status, attempts, response_code,
+	last_error, created_at, next_attempt_at, delivered_at
+FROM (
+	SELECT *, ROW_NUMBER() OVER (PARTITION BY webhook ORDER BY id) AS n
+	FROM figaro.deliveries
+	WHERE status = 'pending' AND next_attempt_at <= $1
+) AS due
+WHERE n <= $2
+ORDER BY id;
+`
+
+const queryRemoveDeliveries = `--Removes finished deliveries created before the
+--time.
+DELETE FROM figaro.deliveries WHERE status <> 'pending' AND created_at < $1;
+`
+
+const queryGetResponseAt = `--Returns the last question of a channel on a board
+--asked at or before the time.
+SELECT board, channel_id, ts, user_id, asked_at, response_ts, responder_id,
+	responded_at
+FROM figaro.responses
+WHERE board = $1 AND channel_id = $2 AND asked_at <= $3
+ORDER BY asked_at DESC, ts DESC LIMIT 1;
+`
+
+const queryRemoveResponses = `--Removes questions of a channel on a board asked
+--at or after the time.
+DELETE FROM figaro.responses
+WHERE board = $1 AND channel_id = $2 AND asked_at >= $3;
+`
+
+const queryRemoveBoardResponses = `--Removes questions of a board.
+DELETE FROM figaro.responses WHERE board = $1;
+`
+
+const queryAddResponse = `--Adds a question, if it exists, then update it.
+INSERT INTO figaro.responses (board, channel_id, ts, user_id, asked_at,
+	response_ts, responder_id, responded_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT(board, channel_id, ts) DO UPDATE
+SET (user_id, asked_at, response_ts, responder_id, responded_at) =
+	($4, $5, $6, $7, $8);
+`
+
+const queryGetOpenResponses = `--Returns unanswered questions of channels on a
+--board.
+SELECT board, channel_id, ts, user_id, asked_at, response_ts, responder_id,
+	responded_at
+FROM figaro.responses
+WHERE board = $1 AND channel_id = ANY($2) AND responded_at IS NULL;
+`
+
+const queryGetResponses = `--Returns questions of a channel or of all channels
+--if the channel ID is empty on a board asked from the first time till the
+--second one.
+SELECT board, channel_id, ts, user_id, asked_at, response_ts, responder_id,
+	responded_at
+FROM figaro.responses
+WHERE board = $1 AND ($2 = '' OR channel_id = $2) AND asked_at >= $3
+	AND asked_at < $4
+ORDER BY asked_at, channel_id, ts;
+`
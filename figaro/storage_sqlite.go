@@ -0,0 +1,82 @@
+package figaro
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3" // registers the sqlite3 driver
+)
+
+// sqliteBusyTimeout is how long SQLite waits for other processes, for example
+// "figaro-server migrate", to release the database file, in milliseconds.
+const sqliteBusyTimeout = "5000"
+
+// sqliteDriver keeps the data in an SQLite file. Figaro doesn't need a
+// database server with it. The Dbaddr URL looks like sqlite:///var/lib/figaro.db
+// for an absolute path or sqlite:figaro.db for a relative one.
+var sqliteDriver = &storageDriver{
+	name: "sqlite3",
+	dsn:  sqliteDSN,
+	// SQLite allows only one writer, so a single connection serializes
+	// writes instead of failing them with "database is locked".
+	maxConns:              1,
+	migrations:            sqliteMigrations,
+	createMigrationsTable: querySQLiteCreateMigrationsTable,
+	rebind:                sqliteRebind,
+	array:                 sqliteArray,
+}
+
+func init() {
+	storageDrivers["sqlite"] = sqliteDriver
+}
+
+// sqliteMigrations must have the same versions as postgresMigrations.
+var sqliteMigrations = []migration{
+	{1, "initial schema", querySQLiteMigrate1Up, querySQLiteMigrate1Down},
+	{2, "channel types", querySQLiteMigrate2Up, querySQLiteMigrate2Down},
+	{3, "thread replies", querySQLiteMigrate3Up, querySQLiteMigrate3Down},
+	{4, "message timestamps, edits and deletions", querySQLiteMigrate4Up, querySQLiteMigrate4Down},
+	{5, "reactions", querySQLiteMigrate5Up, querySQLiteMigrate5Down},
+	{6, "channel members", querySQLiteMigrate6Up, querySQLiteMigrate6Down},
+	{7, "boards", querySQLiteMigrate7Up, querySQLiteMigrate7Down},
+	{8, "Slack workspaces", querySQLiteMigrate8Up, querySQLiteMigrate8Down},
+	{9, "user roles", querySQLiteMigrate9Up, querySQLiteMigrate9Down},
+	{10, "responses and SLA", querySQLiteMigrate10Up, querySQLiteMigrate10Down},
+	{11, "business hours calendars", querySQLiteMigrate11Up, querySQLiteMigrate11Down},
+	{12, "alert notifications", querySQLiteMigrate12Up, querySQLiteMigrate12Down},
+	{13, "webhooks", querySQLiteMigrate13Up, querySQLiteMigrate13Down},
+	{14, "responses per board", querySQLiteMigrate14Up, querySQLiteMigrate14Down},
+}
+
+func sqliteDSN(connURL string) string {
+	path := strings.TrimPrefix(connURL, "sqlite:")
+	path = strings.TrimPrefix(path, "//")
+	if strings.Contains(path, "?") {
+		return path + "&_busy_timeout=" + sqliteBusyTimeout
+	}
+	return path + "?_busy_timeout=" + sqliteBusyTimeout
+}
+
+var (
+	sqliteSchemaRe = regexp.MustCompile(`\bfigaro\.`)
+	sqliteAnyRe    = regexp.MustCompile(`= ANY\(\$(\d+)\)`)
+	sqliteParamRe  = regexp.MustCompile(`\$(\d+)`)
+)
+
+// sqliteRebind rewrites a PostgreSQL query for SQLite. SQLite has no
+// schemas and arrays, and numbers parameters as ?N.
+func sqliteRebind(query string) string {
+	query = sqliteSchemaRe.ReplaceAllString(query, "")
+	query = sqliteAnyRe.ReplaceAllString(query, "IN (SELECT value FROM json_each($$$1))")
+	return sqliteParamRe.ReplaceAllString(query, "?$1")
+}
+
+// sqliteArray passes a slice as a JSON array which json_each expands.
+func sqliteArray(a []string) interface{} {
+	if a == nil {
+		a = []string{}
+	}
+	b, _ := json.Marshal(a)
+	return string(b)
+}
@@ -0,0 +1,290 @@
+package figaro
+
+// SQLite versions of migrations in storage_queries.go. Other queries are
+// rebound by sqliteRebind.
+
+const querySQLiteCreateMigrationsTable = `--Creates the table of applied migrations
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version		INTEGER PRIMARY KEY,
+	name		VARCHAR NOT NULL,
+	applied_at	TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+const querySQLiteMigrate1Up = `--Creates table for Slack users
+CREATE TABLE IF NOT EXISTS users (
+	user_id		VARCHAR,
+	name 		VARCHAR,
+	full_name	VARCHAR,
+	email 		VARCHAR
+);
+CREATE UNIQUE INDEX IF NOT EXISTS users_user_id_idx ON users (user_id);
+
+--Creates table for Slack Message
+CREATE TABLE IF NOT EXISTS messages (
+	user_id			VARCHAR,
+	channel_id		VARCHAR,
+	created_at		TIMESTAMP,
+	message_text	TEXT
+);
+CREATE UNIQUE INDEX IF NOT EXISTS messages_user_id_channel_id_created_at_idx
+	ON messages (user_id, channel_id, created_at);
+CREATE INDEX IF NOT EXISTS messages_created_at_idx
+	ON messages (created_at);
+
+--Creates table for Slack Channels
+CREATE TABLE IF NOT EXISTS channels (
+	channel_id	VARCHAR,
+	name		VARCHAR,
+	ok			BOOLEAN,
+	archived	BOOLEAN
+);
+CREATE UNIQUE INDEX IF NOT EXISTS channels_channel_id_idx
+	ON channels (channel_id);
+CREATE INDEX IF NOT EXISTS channels_name_idx
+	ON channels (name);
+`
+
+const querySQLiteMigrate1Down = `--Drops Figaro's tables
+DROP TABLE IF EXISTS channels;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS users;
+`
+
+const querySQLiteMigrate2Up = `--Adds conversation types and sharing to channels
+ALTER TABLE channels ADD COLUMN type VARCHAR NOT NULL DEFAULT 'public_channel';
+ALTER TABLE channels ADD COLUMN shared BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE channels ADD COLUMN ext_shared BOOLEAN NOT NULL DEFAULT FALSE;
+`
+
+const querySQLiteMigrate2Down = `--Removes conversation types and sharing from channels
+ALTER TABLE channels DROP COLUMN ext_shared;
+ALTER TABLE channels DROP COLUMN shared;
+ALTER TABLE channels DROP COLUMN type;
+`
+
+const querySQLiteMigrate3Up = `--Adds thread replies to messages
+ALTER TABLE messages ADD COLUMN thread_ts VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN parent_user_id VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN is_reply BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS messages_channel_id_thread_ts_idx
+	ON messages (channel_id, thread_ts);
+`
+
+const querySQLiteMigrate3Down = `--Removes thread replies from messages
+DROP INDEX IF EXISTS messages_channel_id_thread_ts_idx;
+ALTER TABLE messages DROP COLUMN is_reply;
+ALTER TABLE messages DROP COLUMN parent_user_id;
+ALTER TABLE messages DROP COLUMN thread_ts;
+`
+
+const querySQLiteMigrate4Up = `--Identifies messages by Slack timestamps. Messages stored
+--before it was introduced get it from created_at.
+ALTER TABLE messages ADD COLUMN ts VARCHAR NOT NULL DEFAULT '';
+UPDATE messages SET ts = printf('%010d.%06d',
+	CAST(strftime('%s', created_at) AS INTEGER),
+	CAST(strftime('%f', created_at) * 1000000 AS INTEGER) % 1000000)
+WHERE ts = '';
+DROP INDEX IF EXISTS messages_user_id_channel_id_created_at_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS messages_channel_id_ts_idx
+	ON messages (channel_id, ts);
+
+--Adds edits and deletions to messages
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN deleted BOOLEAN NOT NULL DEFAULT FALSE;
+`
+
+const querySQLiteMigrate4Down = `--Removes edits, deletions and Slack timestamps from messages
+DELETE FROM messages WHERE deleted;
+ALTER TABLE messages DROP COLUMN deleted;
+ALTER TABLE messages DROP COLUMN edited_at;
+DROP INDEX IF EXISTS messages_channel_id_ts_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS messages_user_id_channel_id_created_at_idx
+	ON messages (user_id, channel_id, created_at);
+ALTER TABLE messages DROP COLUMN ts;
+`
+
+const querySQLiteMigrate5Up = `--Creates table for reactions to Slack messages
+CREATE TABLE IF NOT EXISTS reactions (
+	channel_id	VARCHAR NOT NULL,
+	ts			VARCHAR NOT NULL,
+	user_id		VARCHAR NOT NULL,
+	reaction	VARCHAR NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS reactions_channel_id_ts_user_id_reaction_idx
+	ON reactions (channel_id, ts, user_id, reaction);
+`
+
+const querySQLiteMigrate5Down = `--Drops table for reactions
+DROP TABLE IF EXISTS reactions;
+`
+
+const querySQLiteMigrate6Up = `--Creates table for members of Slack channels
+CREATE TABLE IF NOT EXISTS members (
+	channel_id	VARCHAR NOT NULL,
+	user_id		VARCHAR NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS members_channel_id_user_id_idx
+	ON members (channel_id, user_id);
+CREATE INDEX IF NOT EXISTS members_user_id_idx ON members (user_id);
+`
+
+const querySQLiteMigrate6Down = `--Drops table for members
+DROP TABLE IF EXISTS members;
+`
+
+const querySQLiteMigrate7Up = `--Creates table for boards
+CREATE TABLE IF NOT EXISTS boards (
+	name			VARCHAR PRIMARY KEY,
+	include			TEXT NOT NULL DEFAULT '[]',
+	exclude			TEXT NOT NULL DEFAULT '[]',
+	channels		TEXT NOT NULL DEFAULT '[]',
+	message_limit	INTEGER NOT NULL DEFAULT 0,
+	domains			TEXT NOT NULL DEFAULT '[]'
+);
+`
+
+const querySQLiteMigrate7Down = `--Drops table for boards
+DROP TABLE IF EXISTS boards;
+`
+
+const querySQLiteMigrate8Up = `--Adds Slack workspaces to users, channels, messages and boards
+ALTER TABLE users ADD COLUMN team_id VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE channels ADD COLUMN team_id VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN team_id VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE boards ADD COLUMN teams TEXT NOT NULL DEFAULT '[]';
+`
+
+const querySQLiteMigrate8Down = `--Removes Slack workspaces
+ALTER TABLE boards DROP COLUMN teams;
+ALTER TABLE messages DROP COLUMN team_id;
+ALTER TABLE channels DROP COLUMN team_id;
+ALTER TABLE users DROP COLUMN team_id;
+`
+
+const querySQLiteMigrate9Up = `--Adds roles to users
+ALTER TABLE users ADD COLUMN role VARCHAR NOT NULL DEFAULT '';
+`
+
+const querySQLiteMigrate9Down = `--Removes roles of users
+ALTER TABLE users DROP COLUMN role;
+`
+
+const querySQLiteMigrate10Up = `--Creates table for guest questions and first responses to them
+CREATE TABLE IF NOT EXISTS responses (
+	channel_id		VARCHAR NOT NULL,
+	ts				VARCHAR NOT NULL,
+	user_id			VARCHAR NOT NULL,
+	asked_at		TIMESTAMP NOT NULL,
+	response_ts		VARCHAR NOT NULL DEFAULT '',
+	responder_id	VARCHAR NOT NULL DEFAULT '',
+	responded_at	TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS responses_channel_id_ts_idx
+	ON responses (channel_id, ts);
+CREATE INDEX IF NOT EXISTS responses_channel_id_asked_at_idx
+	ON responses (channel_id, asked_at);
+CREATE INDEX IF NOT EXISTS responses_asked_at_idx
+	ON responses (asked_at);
+
+--Adds SLA of channels in seconds
+ALTER TABLE channels ADD COLUMN sla INTEGER NOT NULL DEFAULT 0;
+`
+
+const querySQLiteMigrate10Down = `--Drops table for responses and SLA of channels
+ALTER TABLE channels DROP COLUMN sla;
+DROP TABLE IF EXISTS responses;
+`
+
+const querySQLiteMigrate11Up = `--Creates table for business hours calendars
+CREATE TABLE IF NOT EXISTS calendars (
+	name		VARCHAR PRIMARY KEY,
+	time_zone	VARCHAR NOT NULL DEFAULT '',
+	hours		TEXT NOT NULL DEFAULT '[]',
+	holidays	TEXT NOT NULL DEFAULT ''
+);
+
+--Attaches calendars to channels and boards
+ALTER TABLE channels ADD COLUMN calendar VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE boards ADD COLUMN calendar VARCHAR NOT NULL DEFAULT '';
+`
+
+const querySQLiteMigrate11Down = `--Drops table for calendars
+ALTER TABLE boards DROP COLUMN calendar;
+ALTER TABLE channels DROP COLUMN calendar;
+DROP TABLE IF EXISTS calendars;
+`
+
+const querySQLiteMigrate12Up = `--Creates table for alerts sent about unanswered questions
+CREATE TABLE IF NOT EXISTS notifications (
+	channel_id	VARCHAR PRIMARY KEY,
+	asked_at	TIMESTAMP NOT NULL,
+	sent_at		TIMESTAMP NOT NULL,
+	count		INTEGER NOT NULL DEFAULT 0
+);
+`
+
+const querySQLiteMigrate12Down = `--Drops table for alerts
+DROP TABLE IF EXISTS notifications;
+`
+
+const querySQLiteMigrate13Up = `--Creates table for outbound webhooks
+CREATE TABLE IF NOT EXISTS webhooks (
+	name		VARCHAR PRIMARY KEY,
+	url			VARCHAR NOT NULL,
+	secret		VARCHAR NOT NULL DEFAULT '',
+	board		VARCHAR NOT NULL DEFAULT '',
+	events		TEXT NOT NULL DEFAULT '[]'
+);
+
+--Creates table for the delivery log of webhooks
+CREATE TABLE IF NOT EXISTS deliveries (
+	id				INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook			VARCHAR NOT NULL,
+	event			VARCHAR NOT NULL,
+	payload			TEXT NOT NULL,
+	status			VARCHAR NOT NULL,
+	attempts		INTEGER NOT NULL DEFAULT 0,
+	response_code	INTEGER NOT NULL DEFAULT 0,
+	last_error		TEXT NOT NULL DEFAULT '',
+	created_at		TIMESTAMP NOT NULL,
+	next_attempt_at	TIMESTAMP NOT NULL,
+	delivered_at	TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS deliveries_webhook_id_idx
+	ON deliveries (webhook, id);
+CREATE INDEX IF NOT EXISTS deliveries_status_next_attempt_at_idx
+	ON deliveries (status, next_attempt_at);
+`
+
+const querySQLiteMigrate13Down = `--Drops tables for webhooks
+DROP TABLE IF EXISTS deliveries;
+DROP TABLE IF EXISTS webhooks;
+`
+
+const querySQLiteMigrate14Up = `--Adds boards to responses, boards derive questions with their own domains
+ALTER TABLE responses ADD COLUMN board VARCHAR NOT NULL DEFAULT 'default';
+DROP INDEX IF EXISTS responses_channel_id_ts_idx;
+DROP INDEX IF EXISTS responses_channel_id_asked_at_idx;
+DROP INDEX IF EXISTS responses_asked_at_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS responses_board_channel_id_ts_idx
+	ON responses (board, channel_id, ts);
+CREATE INDEX IF NOT EXISTS responses_board_channel_id_asked_at_idx
+	ON responses (board, channel_id, asked_at);
+CREATE INDEX IF NOT EXISTS responses_board_asked_at_idx
+	ON responses (board, asked_at);
+`
+
+const querySQLiteMigrate14Down = `--Keeps responses of the default board only
+DELETE FROM responses WHERE board <> 'default';
+DROP INDEX IF EXISTS responses_board_channel_id_ts_idx;
+DROP INDEX IF EXISTS responses_board_channel_id_asked_at_idx;
+DROP INDEX IF EXISTS responses_board_asked_at_idx;
+ALTER TABLE responses DROP COLUMN board;
+CREATE UNIQUE INDEX IF NOT EXISTS responses_channel_id_ts_idx
+	ON responses (channel_id, ts);
+CREATE INDEX IF NOT EXISTS responses_channel_id_asked_at_idx
+	ON responses (channel_id, asked_at);
+CREATE INDEX IF NOT EXISTS responses_asked_at_idx
+	ON responses (asked_at);
+`
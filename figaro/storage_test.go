@@ -0,0 +1,388 @@
+package figaro
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newTestStorage returns Storage on an SQLite file which is removed after
+// the test.
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	st, err := NewStorage("sqlite://" + filepath.Join(t.TempDir(), "figaro.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func TestStorageMessages(t *testing.T) {
+	st := newTestStorage(t)
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	if err := st.UpdateUsers(testUsers()); err != nil {
+		t.Fatal(err)
+	}
+	users, err := st.GetUsers([]string{"UINT", "UGUEST", "UNKNOWN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var emails []string
+	for _, user := range users {
+		emails = append(emails, user.Email)
+	}
+	sort.Strings(emails)
+	if want := []string{"ann@corp.com", "bob@customer.com"}; !reflect.DeepEqual(emails, want) {
+		t.Errorf("got users %q, want %q", emails, want)
+	}
+
+	question := testMessage("UGUEST", t0, "question")
+	question.Reactions = []*Reaction{{Name: "eyes", UserIDs: []string{"UINT"}}}
+	answer := testMessage("UINT", t0.Add(time.Minute), "answer")
+	answer.ThreadTS, answer.ParentUserID, answer.IsReply = question.TS, "UGUEST", true
+	created, err := st.UpdateMessages([]*Message{question, answer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 2 {
+		t.Errorf("got %d created messages, want 2", len(created))
+	}
+	if created, err = st.UpdateMessages([]*Message{answer}); err != nil || len(created) != 0 {
+		t.Errorf("got %d created messages and error %v for a stored one", len(created), err)
+	}
+	messages, err := st.GetMessagesByChannel("C1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].Text != "answer" || messages[1].Text != "question" {
+		t.Fatalf("got messages %+v, want the answer and the question", messages)
+	}
+	if m := messages[0]; m.ThreadTS != question.TS || m.ParentUserID != "UGUEST" ||
+		!m.IsReply || !m.CreatedAt.Equal(answer.CreatedAt) {
+		t.Errorf("got the answer %+v", m)
+	}
+	if r := messages[1].Reactions; len(r) != 1 || r[0].Name != "eyes" ||
+		!reflect.DeepEqual(r[0].UserIDs, []string{"UINT"}) {
+		t.Errorf("got reactions %+v, want eyes of UINT", r)
+	}
+
+	if err := st.EditMessage("C1", question.TS, "edited", t0.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.AddReaction("C1", question.TS, "UGUEST", "+1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.RemoveReaction("C1", question.TS, "UINT", "eyes"); err != nil {
+		t.Fatal(err)
+	}
+	messages, err = st.GetMessagesByChannel("C1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Text != "answer" {
+		t.Fatalf("got messages %+v, want the answer only", messages)
+	}
+	since, err := st.GetMessagesSince("C1", t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(since) != 2 || since[0].Text != "edited" || !since[0].EditedAt.Equal(t0.Add(time.Hour)) {
+		t.Fatalf("got messages %+v, want the edited question first", since)
+	}
+	messages, err = st.GetMessagesByChannel("C1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := messages[1].Reactions; len(r) != 1 || r[0].Name != "+1" {
+		t.Errorf("got reactions %+v, want +1 only", r)
+	}
+
+	between, err := st.GetMessagesBetween("", t0, t0.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(between) != 1 || between[0].TS != question.TS {
+		t.Errorf("got messages %+v, want the question", between)
+	}
+	last, err := st.GetLastMessageTS("C1")
+	if err != nil || !last.Equal(answer.CreatedAt) {
+		t.Errorf("got the last message at %v and error %v, want %v", last, err, answer.CreatedAt)
+	}
+	if err := st.DeleteMessage("C1", answer.TS); err != nil {
+		t.Fatal(err)
+	}
+	if messages, err = st.GetMessagesByChannel("C1", 10); err != nil || len(messages) != 1 {
+		t.Errorf("got %d messages and error %v after deletion, want 1", len(messages), err)
+	}
+
+	if err := st.UpdateMembers("C1", []string{"UINT", "UGUEST"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.AddMember("C1", "UBOT"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.RemoveMember("C1", "UGUEST"); err != nil {
+		t.Fatal(err)
+	}
+	members, err := st.GetMembers("C1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"UBOT", "UINT"}; !reflect.DeepEqual(members, want) {
+		t.Errorf("got members %q, want %q", members, want)
+	}
+}
+
+func TestStorageChannels(t *testing.T) {
+	st := newTestStorage(t)
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	err := st.UpdateChannels([]*Channel{
+		{ID: "C1", Name: "one", Type: ChannelTypePublic},
+		{ID: "C2", Name: "other", Type: ChannelTypePrivate, Shared: true,
+			ExtShared: true, TeamID: "T2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, update := range []error{
+		st.UpdateChannelStatus("C2", true),
+		st.UpdateChannelSLA("C2", 600),
+		st.UpdateChannelCalendar("C2", "office"),
+		st.UpdateChannelArch("C2", true),
+		st.UpdateChannelName("C2", "two"),
+	} {
+		if update != nil {
+			t.Fatal(update)
+		}
+	}
+	channel, err := st.GetChannel("C2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Channel{ID: "C2", Name: "two", Type: ChannelTypePrivate, Shared: true,
+		ExtShared: true, Ok: true, Archived: true, TeamID: "T2", Calendar: "office",
+		SLA: 600}
+	if !reflect.DeepEqual(channel, want) {
+		t.Errorf("got channel %+v, want %+v", channel, want)
+	}
+	if _, err := st.GetChannel("C3"); err != sql.ErrNoRows {
+		t.Errorf("got error %v for an unknown channel, want sql.ErrNoRows", err)
+	}
+
+	// Channels without messages aren't matched
+	if _, err := st.UpdateMessages([]*Message{testMessage("UGUEST", t0, "question")}); err != nil {
+		t.Fatal(err)
+	}
+	channels, err := st.GetChannelsByRegex("^(one|two)$", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(channels) != 1 || channels[0].ID != "C1" || len(channels[0].Messages) != 1 {
+		t.Errorf("got channels %+v, want C1 with its message", channels)
+	}
+}
+
+func TestStorageResponses(t *testing.T) {
+	st := newTestStorage(t)
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	answered := &Response{Board: DefaultBoard, ChannelID: "C1", TS: timeToStr(t0),
+		UserID: "UGUEST", AskedAt: t0, ResponseTS: timeToStr(t0.Add(time.Minute)),
+		ResponderID: "UINT", RespondedAt: t0.Add(time.Minute)}
+	open := &Response{Board: DefaultBoard, ChannelID: "C1", TS: timeToStr(t0.Add(time.Hour)),
+		UserID: "UGUEST", AskedAt: t0.Add(time.Hour)}
+	if err := st.ReplaceResponses(DefaultBoard, "C1", t0, []*Response{answered, open}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpdateBoard(&Board{Name: "customer", Include: []string{"^one$"}, MessageLimit: 10}); err != nil {
+		t.Fatal(err)
+	}
+	other := *open
+	other.Board = "customer"
+	if err := st.ReplaceResponses("customer", "C1", t0, []*Response{&other}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := st.GetResponseAt(DefaultBoard, "C1", t0.Add(30*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r, answered) {
+		t.Errorf("got question %+v, want %+v", r, answered)
+	}
+	if _, err := st.GetResponseAt(DefaultBoard, "C1", t0.Add(-time.Second)); err != sql.ErrNoRows {
+		t.Errorf("got error %v before questions, want sql.ErrNoRows", err)
+	}
+	responses, err := st.GetOpenResponses(DefaultBoard, []string{"C1", "C2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 || !reflect.DeepEqual(responses[0], open) {
+		t.Errorf("got open questions %+v, want %+v", responses, open)
+	}
+	responses, err = st.GetResponses(DefaultBoard, "", t0, t0.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 2 || responses[0].TS != answered.TS || responses[1].TS != open.TS {
+		t.Errorf("got questions %+v, want both in order", responses)
+	}
+
+	// Questions are replaced since the time only
+	if err := st.ReplaceResponses(DefaultBoard, "C1", t0.Add(time.Minute), nil); err != nil {
+		t.Fatal(err)
+	}
+	if responses, err = st.GetResponses(DefaultBoard, "C1", t0, t0.Add(2*time.Hour)); err != nil || len(responses) != 1 {
+		t.Errorf("got %d questions and error %v, want the answered one", len(responses), err)
+	}
+	if err := st.DeleteBoard("customer"); err != nil {
+		t.Fatal(err)
+	}
+	if responses, err = st.GetResponses("customer", "", t0, t0.Add(2*time.Hour)); err != nil || len(responses) != 0 {
+		t.Errorf("got %d questions and error %v of a deleted board, want none", len(responses), err)
+	}
+}
+
+func TestStorageBoards(t *testing.T) {
+	st := newTestStorage(t)
+	board := &Board{Name: "support", Include: []string{"^support-"},
+		Exclude: []string{"-old$"}, Channels: []string{"C1"}, MessageLimit: 5,
+		Domains: []string{"corp.com"}, Teams: []string{"T1"}, Calendar: "office"}
+	for _, b := range []*Board{board, {Name: "all", Include: []string{".*"}, MessageLimit: 3}} {
+		if err := st.UpdateBoard(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	board.MessageLimit = 10
+	if err := st.UpdateBoard(board); err != nil {
+		t.Fatal(err)
+	}
+	boards, err := st.GetBoards()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(boards, func(i, j int) bool { return boards[i].Name > boards[j].Name })
+	if len(boards) != 2 || !reflect.DeepEqual(boards[0], board) {
+		t.Fatalf("got boards %+v, want %+v first", boards, board)
+	}
+	if err := st.DeleteBoard("all"); err != nil {
+		t.Fatal(err)
+	}
+	if boards, err = st.GetBoards(); err != nil || len(boards) != 1 {
+		t.Errorf("got %d boards and error %v after deletion, want 1", len(boards), err)
+	}
+}
+
+func TestStorageWebhooks(t *testing.T) {
+	st := newTestStorage(t)
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	webhook := &Webhook{Name: "tickets", URL: "https://tickets.corp.com/figaro",
+		Secret: "secret", Board: "support", Events: []string{WebhookEventGuestMessage}}
+	for _, w := range []*Webhook{webhook, {Name: "chat", URL: "https://chat.corp.com"}} {
+		if err := st.UpdateWebhook(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	webhooks, err := st.GetWebhooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].Name > webhooks[j].Name })
+	if len(webhooks) != 2 || !reflect.DeepEqual(webhooks[0], webhook) {
+		t.Fatalf("got webhooks %+v, want %+v first", webhooks, webhook)
+	}
+	if err := st.DeleteWebhook("chat"); err != nil {
+		t.Fatal(err)
+	}
+	if webhooks, err = st.GetWebhooks(); err != nil || len(webhooks) != 1 {
+		t.Errorf("got %d webhooks and error %v after deletion, want 1", len(webhooks), err)
+	}
+
+	// Two deliveries of tickets and one of chat are due at t0
+	var deliveries []*Delivery
+	for i, name := range []string{"tickets", "chat", "tickets"} {
+		d := &Delivery{Webhook: name, Event: WebhookEventStatusChange, Payload: "{}",
+			Status: DeliveryPending, CreatedAt: t0.Add(time.Duration(i) * time.Second),
+			NextAttemptAt: t0}
+		if err := st.AddDelivery(d); err != nil {
+			t.Fatal(err)
+		}
+		if d.ID == 0 {
+			t.Fatal("got no delivery ID")
+		}
+		deliveries = append(deliveries, d)
+	}
+	due, err := st.GetDueDeliveries(t0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 || due[0].ID != deliveries[0].ID || due[1].ID != deliveries[1].ID {
+		t.Errorf("got due deliveries %+v, want the first of every webhook", due)
+	}
+	if due, err = st.GetDueDeliveries(t0.Add(-time.Second), 10); err != nil || len(due) != 0 {
+		t.Errorf("got %d due deliveries and error %v before they're due, want none", len(due), err)
+	}
+
+	d := deliveries[0]
+	d.Status, d.Attempts, d.ResponseCode, d.Error = DeliveryDelivered, 2, 200, "timeout"
+	d.DeliveredAt = t0.Add(time.Minute)
+	if err := st.UpdateDelivery(d); err != nil {
+		t.Fatal(err)
+	}
+	got, err := st.GetDelivery(d.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, d) {
+		t.Errorf("got delivery %+v, want %+v", got, d)
+	}
+	if _, err := st.GetDelivery(d.ID + 100); err != sql.ErrNoRows {
+		t.Errorf("got error %v for an unknown delivery, want sql.ErrNoRows", err)
+	}
+	tickets, err := st.GetDeliveries("tickets", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tickets) != 2 || tickets[0].ID != deliveries[2].ID {
+		t.Errorf("got deliveries %+v, want the latest first", tickets)
+	}
+
+	// Only finished deliveries are removed
+	if err := st.RemoveDeliveries(t0.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if tickets, err = st.GetDeliveries("tickets", 10); err != nil || len(tickets) != 1 {
+		t.Errorf("got %d deliveries and error %v, want the pending one", len(tickets), err)
+	}
+}
+
+func TestOpenStorage(t *testing.T) {
+	connURL := "sqlite://" + filepath.Join(t.TempDir(), "figaro.db")
+	if _, err := OpenStorage(connURL); err == nil {
+		t.Error("got no error for an empty database")
+	}
+	old := LatestSchemaVersion() - 1
+	if _, err := MigrateStorage(connURL, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenStorage(connURL); err == nil {
+		t.Error("got no error for an old schema")
+	}
+	if v := schemaVersion(t, connURL); v != old {
+		t.Errorf("got version %d, want %d unchanged", v, old)
+	}
+	if _, err := MigrateStorage(connURL, LatestSchemaVersion()); err != nil {
+		t.Fatal(err)
+	}
+	st, err := OpenStorage(connURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if _, err := st.GetBoards(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,502 @@
+package figaro
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Webhook event types.
+const (
+	// WebhookEventEnteredBad carries a channel which entered the Bad half
+	// of the board in Channel.
+	WebhookEventEnteredBad = "channel_entered_bad"
+	// WebhookEventLeftBad carries a channel which left the Bad half of the
+	// board in Channel and its half now in Half, empty if it left the
+	// board.
+	WebhookEventLeftBad = "channel_left_bad"
+	// WebhookEventGuestMessage carries a new message of a guest in Message
+	// and its channel without messages in Channel.
+	WebhookEventGuestMessage = "guest_message"
+	// WebhookEventStatusChange carries a channel which was marked as OK or
+	// not OK in Channel and the flag in Ok.
+	WebhookEventStatusChange = "status_change"
+)
+
+var webhookEvents = map[string]bool{
+	WebhookEventEnteredBad:   true,
+	WebhookEventLeftBad:      true,
+	WebhookEventGuestMessage: true,
+	WebhookEventStatusChange: true,
+}
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed" // All attempts failed
+)
+
+const (
+	// webhookTimeout limits how long a webhook answers.
+	webhookTimeout = 10 * time.Second
+	// webhookAttempts is how many times a delivery is attempted.
+	webhookAttempts = 8
+	// webhookBackoff is the delay before the second attempt, it doubles
+	// with every next one up to webhookMaxBackoff.
+	webhookBackoff    = 10 * time.Second
+	webhookMaxBackoff = time.Hour
+	// webhookPollInterval is how often due deliveries are looked for.
+	webhookPollInterval = 5 * time.Second
+	// deliveryBatchSize is how many due deliveries of a webhook are
+	// attempted at once.
+	deliveryBatchSize = 100
+	// deliveryRetention is how long finished deliveries stay in the log.
+	deliveryRetention = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrNoWebhook is returned for webhooks which don't exist.
+	ErrNoWebhook = errors.New("webhook not found")
+	// ErrNoDelivery is returned for deliveries which don't exist.
+	ErrNoDelivery = errors.New("delivery not found")
+)
+
+// webhookError tells what's wrong with a webhook.
+type webhookError string
+
+func (e webhookError) Error() string {
+	return string(e)
+}
+
+// WebhookEvent is the JSON payload posted to webhooks.
+type WebhookEvent struct {
+	Type    string // WebhookEvent* constants
+	Board   string
+	Time    time.Time
+	Channel *Channel `json:",omitempty"`
+	Half    string   `json:",omitempty"`
+	Message *Message `json:",omitempty"`
+	Ok      *bool    `json:",omitempty"`
+}
+
+// Webhooks returns all webhooks sorted by name.
+func (f *Figaro) Webhooks() []*Webhook {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	webhooks := make([]*Webhook, 0, len(f.webhooks))
+	for _, webhook := range f.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].Name < webhooks[j].Name
+	})
+	return webhooks
+}
+
+// Webhook returns the webhook by its name or ErrNoWebhook.
+func (f *Figaro) Webhook(name string) (*Webhook, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	webhook := f.webhooks[name]
+	if webhook == nil {
+		return nil, ErrNoWebhook
+	}
+	return webhook, nil
+}
+
+// PutWebhook creates the webhook or replaces the one with the same name.
+// The secret of the replaced webhook stays if the new one has none.
+func (f *Figaro) PutWebhook(conf *Webhook) error {
+	if conf.Secret == "" {
+		if old, err := f.Webhook(conf.Name); err == nil {
+			conf.Secret = old.Secret
+		}
+	}
+	if err := f.validateWebhook(conf); err != nil {
+		return err
+	}
+	if err := f.st.UpdateWebhook(conf); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.webhooks[conf.Name] = conf
+	f.mu.Unlock()
+	log.Println("Figaro: Webhook updated:", conf.Name)
+	return nil
+}
+
+func (f *Figaro) validateWebhook(conf *Webhook) error {
+	if !boardNameRe.MatchString(conf.Name) {
+		return webhookError(fmt.Sprintf("invalid webhook name %q, use lowercase letters, digits, - and _", conf.Name))
+	}
+	u, err := url.Parse(conf.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return webhookError(fmt.Sprintf("invalid webhook URL %q", conf.URL))
+	}
+	if conf.Secret == "" {
+		return webhookError("webhook secret is required to sign payloads")
+	}
+	board := conf.Board
+	if board == "" {
+		board = DefaultBoard
+	}
+	if f.servedBoard(board) == nil {
+		return webhookError(fmt.Sprintf("unknown board %q", conf.Board))
+	}
+	for _, event := range conf.Events {
+		if !webhookEvents[event] {
+			return webhookError(fmt.Sprintf("unknown webhook event %q", event))
+		}
+	}
+	return nil
+}
+
+// DeleteWebhook deletes the webhook. Its deliveries stay in the log, the
+// pending ones fail.
+func (f *Figaro) DeleteWebhook(name string) error {
+	if _, err := f.Webhook(name); err != nil {
+		return err
+	}
+	if err := f.st.DeleteWebhook(name); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	delete(f.webhooks, name)
+	f.mu.Unlock()
+	log.Println("Figaro: Webhook deleted:", name)
+	return nil
+}
+
+// Deliveries returns lim last deliveries of the webhook, the latest first.
+func (f *Figaro) Deliveries(name string, lim uint) ([]*Delivery, error) {
+	if _, err := f.Webhook(name); err != nil {
+		return nil, err
+	}
+	return f.st.GetDeliveries(name, lim)
+}
+
+// Delivery returns the delivery of the webhook by its ID or ErrNoDelivery.
+func (f *Figaro) Delivery(name string, id int64) (*Delivery, error) {
+	if _, err := f.Webhook(name); err != nil {
+		return nil, err
+	}
+	d, err := f.st.GetDelivery(id)
+	if err == sql.ErrNoRows || err == nil && d.Webhook != name {
+		return nil, ErrNoDelivery
+	}
+	return d, err
+}
+
+// Redeliver posts the payload of the delivery to the webhook again as a new
+// delivery.
+func (f *Figaro) Redeliver(name string, id int64) (*Delivery, error) {
+	d, err := f.Delivery(name, id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	redelivery := &Delivery{
+		Webhook:       d.Webhook,
+		Event:         d.Event,
+		Payload:       d.Payload,
+		Status:        DeliveryPending,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+	if err := f.st.AddDelivery(redelivery); err != nil {
+		return nil, err
+	}
+	f.wakeDeliveries()
+	return redelivery, nil
+}
+
+// loadWebhooks loads the webhooks kept in the storage.
+func (f *Figaro) loadWebhooks() error {
+	webhooks, err := f.st.GetWebhooks()
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, webhook := range webhooks {
+		f.webhooks[webhook.Name] = webhook
+	}
+	return nil
+}
+
+// hasWebhooks tells if there are webhooks to emit events to.
+func (f *Figaro) hasWebhooks() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.webhooks) > 0
+}
+
+// emit adds deliveries of the event to webhooks of the board which
+// subscribed to it.
+func (f *Figaro) emit(sb *servedBoard, event *WebhookEvent) {
+	var names []string
+	f.mu.RLock()
+	for _, webhook := range f.webhooks {
+		board := webhook.Board
+		if board == "" {
+			board = DefaultBoard
+		}
+		if board != sb.conf.Name || !subscribed(webhook, event.Type) {
+			continue
+		}
+		names = append(names, webhook.Name)
+	}
+	f.mu.RUnlock()
+	if len(names) == 0 {
+		return
+	}
+	event.Board = sb.conf.Name
+	event.Time = time.Now()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Fatalln("Figaro: Cannot marshal webhook event:", err)
+	}
+	for _, name := range names {
+		d := &Delivery{
+			Webhook:       name,
+			Event:         event.Type,
+			Payload:       string(payload),
+			Status:        DeliveryPending,
+			CreatedAt:     event.Time,
+			NextAttemptAt: event.Time,
+		}
+		if err := f.st.AddDelivery(d); err != nil {
+			log.Println("Figaro: Cannot add delivery:", err)
+		}
+	}
+	f.wakeDeliveries()
+}
+
+func subscribed(webhook *Webhook, event string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, e := range webhook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// emitChange emits events about the change of the channel on the board
+// from old in oldHalf to channel in half. old is nil for new channels,
+// channel is nil for removed ones.
+func (f *Figaro) emitChange(sb *servedBoard, old *Channel, oldHalf string,
+	channel *Channel, half string) {
+	if !f.hasWebhooks() {
+		return
+	}
+	switch {
+	case half == HalfBad && oldHalf != HalfBad:
+		f.emit(sb, &WebhookEvent{Type: WebhookEventEnteredBad, Channel: channel, Half: half})
+	case oldHalf == HalfBad && half != HalfBad:
+		event := &WebhookEvent{Type: WebhookEventLeftBad, Channel: channel, Half: half}
+		if channel == nil {
+			event.Channel = old
+		}
+		f.emit(sb, event)
+	}
+	if old != nil && channel != nil && old.Ok != channel.Ok {
+		ok := channel.Ok
+		f.emit(sb, &WebhookEvent{Type: WebhookEventStatusChange, Channel: channel, Ok: &ok})
+	}
+}
+
+// emitGuestMessages emits events about new messages of guests to the
+// boards of their channels.
+func (f *Figaro) emitGuestMessages(messages []*Message) error {
+	if len(messages) == 0 || !f.hasWebhooks() {
+		return nil
+	}
+	ids := make([]string, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.UserID)
+	}
+	users, err := f.st.GetUsers(ids)
+	if err != nil {
+		return err
+	}
+	idToUser := make(map[string]*User)
+	for _, user := range users {
+		idToUser[user.ID] = user
+	}
+	idToChannel := make(map[string]*Channel)
+	boards := f.servedBoards()
+	for _, m := range messages {
+		if m.UserID == "" {
+			continue
+		}
+		channel, ok := idToChannel[m.ChannelID]
+		if !ok {
+			channel, err = f.st.GetChannel(m.ChannelID)
+			if err == sql.ErrNoRows {
+				channel, err = nil, nil
+			}
+			if err != nil {
+				return err
+			}
+			idToChannel[m.ChannelID] = channel
+		}
+		if channel == nil {
+			continue
+		}
+		// Boards tell guests by their own domains
+		for _, sb := range boards {
+			if sb.matches(channel) && !f.roles.isInternal(idToUser[m.UserID], sb.conf.Domains) {
+				f.emit(sb, &WebhookEvent{Type: WebhookEventGuestMessage, Channel: channel, Message: m})
+			}
+		}
+	}
+	return nil
+}
+
+// wakeDeliveries makes deliverWebhooks look for due deliveries now.
+func (f *Figaro) wakeDeliveries() {
+	select {
+	case f.deliverCh <- struct{}{}:
+	default:
+	}
+}
+
+// deliverWebhooks attempts due deliveries as they come and removes old
+// ones from the log. Every webhook gets its deliveries in order from a
+// goroutine of its own, so that slow webhooks don't hold up the others.
+func (f *Figaro) deliverWebhooks() {
+	pollCh := time.Tick(webhookPollInterval)
+	cleanCh := time.Tick(time.Hour)
+	doneCh := make(chan string)
+	busy := make(map[string]bool) // Webhooks which are being delivered to
+	for {
+		select {
+		case <-f.deliverCh:
+		case <-pollCh:
+		case name := <-doneCh:
+			delete(busy, name)
+		case <-cleanCh:
+			if err := f.st.RemoveDeliveries(time.Now().Add(-deliveryRetention)); err != nil {
+				log.Println("Figaro: Cannot remove old deliveries:", err)
+			}
+			continue
+		}
+		deliveries, err := f.st.GetDueDeliveries(time.Now(), deliveryBatchSize)
+		if err != nil {
+			log.Println("Figaro: Cannot get deliveries:", err)
+			continue
+		}
+		queues := make(map[string][]*Delivery)
+		for _, d := range deliveries {
+			if !busy[d.Webhook] {
+				queues[d.Webhook] = append(queues[d.Webhook], d)
+			}
+		}
+		for name, queue := range queues {
+			busy[name] = true
+			go func(name string, queue []*Delivery) {
+				for _, d := range queue {
+					f.deliver(d)
+				}
+				doneCh <- name
+			}(name, queue)
+		}
+	}
+}
+
+// deliver attempts the delivery and schedules the next attempt if it
+// fails.
+func (f *Figaro) deliver(d *Delivery) {
+	now := time.Now()
+	webhook, err := f.Webhook(d.Webhook)
+	if err != nil {
+		d.Status = DeliveryFailed
+		d.Error = "webhook deleted"
+	} else {
+		d.Attempts++
+		d.ResponseCode, err = f.post(webhook, d)
+		switch {
+		case err == nil:
+			d.Status = DeliveryDelivered
+			d.Error = ""
+			d.DeliveredAt = now
+		case d.Attempts >= webhookAttempts:
+			d.Status = DeliveryFailed
+			d.Error = err.Error()
+		default:
+			d.Error = err.Error()
+			d.NextAttemptAt = now.Add(webhookDelay(d.Attempts))
+		}
+	}
+	if err := f.st.UpdateDelivery(d); err != nil {
+		log.Println("Figaro: Cannot update delivery:", err)
+	}
+	if d.Status == DeliveryFailed {
+		log.Printf("Figaro: Delivery %d to webhook %s failed: %s\n", d.ID, d.Webhook, d.Error)
+	}
+}
+
+// webhookDelay returns the delay after the attempt.
+func webhookDelay(attempts uint) time.Duration {
+	delay := webhookBackoff
+	for i := uint(1); i < attempts && delay < webhookMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxBackoff {
+		delay = webhookMaxBackoff
+	}
+	return delay
+}
+
+// post posts the payload of the delivery to the webhook. It returns the
+// HTTP status, responses other than 2xx are errors.
+func (f *Figaro) post(webhook *Webhook, d *Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader([]byte(d.Payload)))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Figaro-Webhook")
+	req.Header.Set("X-Figaro-Event", d.Event)
+	req.Header.Set("X-Figaro-Delivery", strconv.FormatInt(d.ID, 10))
+	// Every attempt is signed at its own time, receivers reject old ones
+	now := time.Now()
+	req.Header.Set("X-Figaro-Request-Timestamp", strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set("X-Figaro-Signature-256", SignWebhookPayload(webhook.Secret, []byte(d.Payload), now))
+	resp, err := f.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhookPayload returns the X-Figaro-Signature-256 header of the
+// payload posted at t: "sha256=" and hex HMAC-SHA256 with the secret of
+// "v0:", X-Figaro-Request-Timestamp, which is t in Unix seconds, ":" and the
+// payload. Receivers compare it with the header in constant time and reject
+// old timestamps, so that posts can't be replayed.
+func SignWebhookPayload(secret string, payload []byte, t time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%d:", t.Unix())
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
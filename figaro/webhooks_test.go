@@ -0,0 +1,164 @@
+package figaro
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	payload := []byte(`{"Type":"guest_message"}`)
+	t0 := time.Unix(1500000000, 0)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(`v0:1500000000:{"Type":"guest_message"}`))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := SignWebhookPayload("secret", payload, t0); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	for _, other := range []string{
+		SignWebhookPayload("other", payload, t0),
+		SignWebhookPayload("secret", payload, t0.Add(time.Second)),
+		SignWebhookPayload("secret", []byte(`{"Type":"status_change"}`), t0),
+	} {
+		if other == want {
+			t.Errorf("got the same signature %s for other input", other)
+		}
+	}
+}
+
+func TestWebhookPostHeaders(t *testing.T) {
+	f, _, _ := newTestFigaro(t, nil, nil)
+	header := make(chan http.Header, 1)
+	body := make(chan []byte, 1)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header <- r.Header
+		b, _ := ioutil.ReadAll(r.Body)
+		body <- b
+	}))
+	defer hs.Close()
+	webhook := &Webhook{Name: "tickets", URL: hs.URL, Secret: "secret"}
+	d := &Delivery{ID: 7, Webhook: "tickets", Event: WebhookEventGuestMessage,
+		Payload: `{"Type":"guest_message"}`}
+	before := time.Now().Unix()
+	if _, err := f.post(webhook, d); err != nil {
+		t.Fatal(err)
+	}
+	h, b := <-header, <-body
+	if h.Get("X-Figaro-Event") != WebhookEventGuestMessage || h.Get("X-Figaro-Delivery") != "7" {
+		t.Errorf("got event %q and delivery %q", h.Get("X-Figaro-Event"),
+			h.Get("X-Figaro-Delivery"))
+	}
+	ts, err := strconv.ParseInt(h.Get("X-Figaro-Request-Timestamp"), 10, 64)
+	if err != nil || ts < before || ts > time.Now().Unix() {
+		t.Fatalf("got timestamp %q, want now", h.Get("X-Figaro-Request-Timestamp"))
+	}
+	if sig := h.Get("X-Figaro-Signature-256"); sig != SignWebhookPayload("secret", b, time.Unix(ts, 0)) {
+		t.Errorf("got signature %s of %s at %d", sig, b, ts)
+	}
+}
+
+func TestWebhooksDeliverConcurrently(t *testing.T) {
+	f, _, st := newTestFigaro(t, nil, nil)
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := make(chan string, 1)
+	fastServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fast <- r.Header.Get("X-Figaro-Delivery")
+	}))
+	defer fastServer.Close()
+	for name, url := range map[string]string{"slow": slow.URL, "fast": fastServer.URL} {
+		if err := f.PutWebhook(&Webhook{Name: name, URL: url, Secret: "secret"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The slow webhook gets the first delivery
+	now := time.Now()
+	for _, name := range []string{"slow", "fast"} {
+		d := &Delivery{Webhook: name, Event: WebhookEventStatusChange, Payload: "{}",
+			Status: DeliveryPending, CreatedAt: now, NextAttemptAt: now}
+		if err := st.AddDelivery(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.wakeDeliveries()
+	select {
+	case id := <-fast:
+		if id != "2" {
+			t.Errorf("got delivery %s, want 2", id)
+		}
+	case <-time.After(webhookTimeout / 2):
+		t.Fatal("the slow webhook holds up the fast one")
+	}
+}
+
+func TestGuestMessagesEmittedOnce(t *testing.T) {
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	f, _, st := newTestFigaro(t, []*Channel{{ID: "C1", Name: "one"}},
+		[]*Message{testMessage("UGUEST", t0, "question")})
+	err := f.PutWebhook(&Webhook{Name: "tickets", URL: "http://127.0.0.1:1",
+		Secret: "secret", Events: []string{WebhookEventGuestMessage}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Slack retries the event and backfill brings the messages again
+	m := testMessage("UGUEST", t0.Add(time.Minute), "more")
+	for _, messages := range [][]*Message{
+		{m},
+		{m},
+		{testMessage("UGUEST", t0, "question"), m},
+	} {
+		if err := f.processMessages(messages); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deliveries, err := st.GetDeliveries("tickets", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || !strings.Contains(deliveries[0].Payload, `"more"`) {
+		t.Errorf("got %d deliveries, want one about the new message", len(deliveries))
+	}
+}
+
+func TestGuestMessagesByBoardDomains(t *testing.T) {
+	t0 := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	f, _, st := newTestFigaro(t, []*Channel{{ID: "C1", Name: "one"}},
+		[]*Message{testMessage("UGUEST", t0, "question")})
+	// UGUEST is internal on the board of its own domain
+	err := f.PutBoard(&Board{Name: "customer", Include: []string{"^one$"},
+		Domains: []string{"customer.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, board := range []string{DefaultBoard, "customer"} {
+		err := f.PutWebhook(&Webhook{Name: board, URL: "http://127.0.0.1:1",
+			Secret: "secret", Board: board, Events: []string{WebhookEventGuestMessage}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = f.processMessages([]*Message{testMessage("UGUEST", t0.Add(time.Minute), "more")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for board, want := range map[string]int{DefaultBoard: 1, "customer": 0} {
+		deliveries, err := st.GetDeliveries(board, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) != want {
+			t.Errorf("board %s: got %d deliveries, want %d", board, len(deliveries), want)
+		}
+	}
+}
@@ -24,6 +24,14 @@ Handlebars.registerHelper('parseTime', function(t) {
   return moment(d, "minute").fromNow();
 });
 
+// Go marshals zero time.Time as 0001-01-01T00:00:00Z
+Handlebars.registerHelper('edited', function(t) {
+  if (!t || t.indexOf("0001-") === 0) {
+    return "";
+  }
+  return "(edited)";
+});
+
 $(function () {
 // Grab the template script
 var theTemplateScript = $("#channel-template").html();
@@ -31,23 +39,135 @@ var theTemplateScript = $("#channel-template").html();
 // Compile the template
 var theTemplate = Handlebars.compile(theTemplateScript);
 
-var socket = new WebSocket("ws://localhost:8080");
-console.log("Connected")
-socket.onmessage = function (event) {
-  var data = JSON.parse(event.data)
-  console.log(data)
-  var bad = {
-    "channels": data.Bad
+// Channels on the board by ID with the half of the board they belong to
+var board = {};
+
+function lastMessageTime(channel) {
+  return Date.parse(channel.Messages[0].CreatedAt);
+}
+
+// The time the last message started to wait in business hours
+function waitStart(channel) {
+  if (channel.WaitStart && channel.WaitStart.indexOf("0001-") !== 0) {
+    return Date.parse(channel.WaitStart);
   }
-  var ok = {
-    "channels": data.Ok
+  return lastMessageTime(channel);
+}
+
+function byWaitStart(a, b) {
+  return waitStart(a) - waitStart(b) || lastMessageTime(a) - lastMessageTime(b);
+}
+
+function render() {
+  var bad = [];
+  var ok = [];
+  for (var id in board) {
+    if (board[id].half === "Ok") {
+      ok.push(board[id].channel);
+    } else {
+      bad.push(board[id].channel);
+    }
   }
+  bad.sort(byWaitStart);
+  ok.sort(byWaitStart);
   // Pass our data to the template
-  var compiledHtmlOk = theTemplate(bad);
-  var compiledHtmlBad = theTemplate(ok);
+  $('.channels-up').html(theTemplate({"channels": bad}));
+  $('.channels-down').html(theTemplate({"channels": ok}));
+}
+
+// Epoch and sequence number of the last applied event, see PROTOCOL.md
+var epoch = null;
+var seq = 0;
+
+function apply(data) {
+  if (data.Version !== 1) {
+    console.log("Unknown protocol version: " + data.Version)
+    return;
+  }
+  if (data.Type !== "snapshot" && data.Epoch === epoch && data.Seq <= seq) {
+    return;
+  }
+  epoch = data.Epoch;
+  seq = data.Seq;
+  switch (data.Type) {
+  case "snapshot":
+    board = {};
+    (data.Board.Bad || []).forEach(function (channel) {
+      board[channel.ID] = {half: "Bad", channel: channel};
+    });
+    (data.Board.Ok || []).forEach(function (channel) {
+      board[channel.ID] = {half: "Ok", channel: channel};
+    });
+    break;
+  case "channel_upsert":
+    board[data.Channel.ID] = {half: data.Half, channel: data.Channel};
+    break;
+  case "channel_remove":
+    delete board[data.ID];
+    break;
+  case "status_change":
+    if (board[data.ID]) {
+      board[data.ID].half = data.Half;
+      board[data.ID].channel.Ok = data.Ok;
+    }
+    break;
+  }
+  render();
+}
+
+// ?board=<name> shows a named board instead of the default one
+var boardName = new URLSearchParams(window.location.search).get("board");
+var boardPath = boardName ? "/boards/" + encodeURIComponent(boardName) : "";
+
+// Some proxies don't pass WebSocket, then the board falls back to
+// Server-Sent Events
+var useSSE = false;
 
-  // Add the compiled html to the page
-  $('.channels-up').html(compiledHtmlOk);
-  $('.channels-down').html(compiledHtmlBad);
+function connectSSE() {
+  var source = new EventSource("http://localhost:8080" + boardPath + "/events");
+  console.log("Connected with SSE")
+  source.onmessage = function (event) {
+    apply(JSON.parse(event.data));
+  }
 }
+
+function connect() {
+  if (useSSE) {
+    connectSSE();
+    return;
+  }
+  var url = "ws://localhost:8080" + boardPath;
+  if (boardPath || epoch !== null) {
+    url += "/ws";
+  }
+  if (epoch !== null) {
+    url += "?epoch=" + epoch + "&since=" + seq;
+  }
+  var socket = new WebSocket(url);
+  var opened = false;
+  socket.onopen = function () {
+    opened = true;
+    console.log("Connected")
+  }
+  socket.onmessage = function (event) {
+    var data = JSON.parse(event.data)
+    console.log(data)
+    apply(data);
+  }
+  socket.onclose = function () {
+    if (!opened && epoch === null) {
+      useSSE = true;
+    }
+    setTimeout(connect, 1000);
+  }
+}
+// Users sign in with Slack before they see the board
+$.get("backend/auth/me").done(connect).fail(function (xhr) {
+  if (xhr.status === 401) {
+    window.location = "backend/auth/login";
+  } else {
+    // Figaro runs without signing in
+    connect();
+  }
+});
 });